@@ -0,0 +1,61 @@
+package data
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+// A Group is the count of the records which share a value of a field,
+// as counted by GroupBy. The records which lack the field are grouped
+// by a nil Value, and numbers are float64, as though decoded from json.
+type Group struct {
+	Value interface{}
+	Count int
+}
+
+// SortGroups orders the groups by their values, as Order would: nil,
+// then numbers, then strings, then booleans, and then any others,
+// by their json encodings. A DB sorts the Groups of GroupBy, so that
+// every DB groups records identically.
+func SortGroups(gs []Group) {
+	sort.SliceStable(gs, func(i, j int) bool {
+		return lessValue(gs[i].Value, gs[j].Value)
+	})
+}
+
+// rankValue orders the types of values, as does mongo
+func rankValue(v interface{}) int {
+	switch v.(type) {
+	case nil:
+		return 0
+	case float64:
+		return 1
+	case string:
+		return 2
+	case bool:
+		return 3
+	default:
+		return 4
+	}
+}
+
+func lessValue(v, w interface{}) bool {
+	if rv, rw := rankValue(v), rankValue(w); rv != rw {
+		return rv < rw
+	}
+
+	switch v := v.(type) {
+	case float64:
+		return v < w.(float64)
+	case string:
+		return v < w.(string)
+	case bool:
+		return !v && w.(bool)
+	case nil:
+		return false
+	default:
+		bv, _ := json.Marshal(v)
+		bw, _ := json.Marshal(w)
+		return string(bv) < string(bw)
+	}
+}
@@ -0,0 +1,148 @@
+package mem
+
+import (
+	"github.com/elos/data"
+)
+
+// The aggregates are evaluated in process, over a snapshot of the
+// records the query matches, disregarding its order, skip and limit.
+
+func (q *memQuery) Count() (int, error) {
+	n := 0
+	err := q.aggregate("count", func(*stored) { n++ })
+	return n, err
+}
+
+func (q *memQuery) Exists() (bool, error) {
+	n, err := q.Count()
+	return n > 0, err
+}
+
+func (q *memQuery) Sum(field string) (float64, error) {
+	vs, err := q.numbers(field)
+	if err != nil {
+		return 0, err
+	}
+
+	sum := 0.0
+	for _, v := range vs {
+		sum += v
+	}
+	return sum, nil
+}
+
+func (q *memQuery) Min(field string) (float64, error) {
+	vs, err := q.numbers(field)
+	if err != nil || len(vs) == 0 {
+		return 0, q.none(err)
+	}
+
+	min := vs[0]
+	for _, v := range vs[1:] {
+		if v < min {
+			min = v
+		}
+	}
+	return min, nil
+}
+
+func (q *memQuery) Max(field string) (float64, error) {
+	vs, err := q.numbers(field)
+	if err != nil || len(vs) == 0 {
+		return 0, q.none(err)
+	}
+
+	max := vs[0]
+	for _, v := range vs[1:] {
+		if v > max {
+			max = v
+		}
+	}
+	return max, nil
+}
+
+func (q *memQuery) Avg(field string) (float64, error) {
+	vs, err := q.numbers(field)
+	if err != nil || len(vs) == 0 {
+		return 0, q.none(err)
+	}
+
+	sum := 0.0
+	for _, v := range vs {
+		sum += v
+	}
+	return sum / float64(len(vs)), nil
+}
+
+func (q *memQuery) GroupBy(field string) ([]data.Group, error) {
+	var (
+		groups  []data.Group
+		indices = make(map[interface{}]int)
+	)
+
+	err := q.aggregate("aggregate", func(s *stored) {
+		v := s.attrs[field]
+
+		key := hashKey(v)
+		i, ok := indices[key]
+		if !ok {
+			i = len(groups)
+			indices[key] = i
+			groups = append(groups, data.Group{Value: copyValue(v)})
+		}
+		groups[i].Count++
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	data.SortGroups(groups)
+	return groups, nil
+}
+
+// aggregate calls fn with each record the query matches
+func (q *memQuery) aggregate(op string, fn func(*stored)) error {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	if err := q.db.registered(q.kind); err != nil {
+		return data.WrapError(err, op, q.kind, "")
+	}
+
+	matched := &memQuery{
+		kind:       q.kind,
+		db:         q.db,
+		wheres:     q.wheres,
+		predicates: q.predicates,
+	}
+
+	in, _ := matched.snapshot()
+	for s := range q.filtered(in) {
+		fn(s)
+	}
+
+	return nil
+}
+
+// numbers retrieves the numeric values of the field of the records
+// the query matches
+func (q *memQuery) numbers(field string) ([]float64, error) {
+	var vs []float64
+
+	err := q.aggregate("aggregate", func(s *stored) {
+		if v, ok := s.attrs[field].(float64); ok {
+			vs = append(vs, v)
+		}
+	})
+
+	return vs, err
+}
+
+// none is the error of an aggregate of no values, if err is nil
+func (q *memQuery) none(err error) error {
+	if err != nil {
+		return err
+	}
+
+	return data.WrapError(data.ErrNotFound, "aggregate", q.kind, "")
+}
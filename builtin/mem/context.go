@@ -0,0 +1,128 @@
+package mem
+
+import (
+	"github.com/elos/data"
+	"golang.org/x/net/context"
+)
+
+// The operations of a MemDB do not block, except to acquire its
+// lock, so the context is only checked before they begin.
+
+func (db *MemDB) SaveContext(ctx context.Context, r data.Record) error {
+	if err := ctx.Err(); err != nil {
+		return data.WrapError(err, "save", r.Kind(), r.ID())
+	}
+
+	return db.Save(r)
+}
+
+func (db *MemDB) DeleteContext(ctx context.Context, r data.Record) error {
+	if err := ctx.Err(); err != nil {
+		return data.WrapError(err, "delete", r.Kind(), r.ID())
+	}
+
+	return db.Delete(r)
+}
+
+func (db *MemDB) PopulateByIDContext(ctx context.Context, r data.Record) error {
+	if err := ctx.Err(); err != nil {
+		return data.WrapError(err, "populate", r.Kind(), r.ID())
+	}
+
+	return db.PopulateByID(r)
+}
+
+func (db *MemDB) PopulateByFieldContext(ctx context.Context, field string, v interface{}, r data.Record) error {
+	if err := ctx.Err(); err != nil {
+		return data.WrapError(err, "populate", r.Kind(), "")
+	}
+
+	return db.PopulateByField(field, v, r)
+}
+
+func (q *memQuery) ExecuteContext(ctx context.Context) (data.Iterator, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, data.WrapError(err, "query", q.kind, "")
+	}
+
+	iter, err := q.Execute()
+	if err != nil {
+		return nil, err
+	}
+
+	return data.IterContext(ctx, iter), nil
+}
+
+// the transaction's view must not be written once it is done
+
+func (tx *memTx) SaveContext(ctx context.Context, r data.Record) error {
+	if err := ctx.Err(); err != nil {
+		return data.WrapError(err, "save", r.Kind(), r.ID())
+	}
+
+	return tx.Save(r)
+}
+
+func (tx *memTx) DeleteContext(ctx context.Context, r data.Record) error {
+	if err := ctx.Err(); err != nil {
+		return data.WrapError(err, "delete", r.Kind(), r.ID())
+	}
+
+	return tx.Delete(r)
+}
+
+func (q *memQuery) CountContext(ctx context.Context) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, data.WrapError(err, "count", q.kind, "")
+	}
+
+	return q.Count()
+}
+
+func (q *memQuery) ExistsContext(ctx context.Context) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, data.WrapError(err, "count", q.kind, "")
+	}
+
+	return q.Exists()
+}
+
+func (q *memQuery) SumContext(ctx context.Context, field string) (float64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, data.WrapError(err, "aggregate", q.kind, "")
+	}
+
+	return q.Sum(field)
+}
+
+func (q *memQuery) MinContext(ctx context.Context, field string) (float64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, data.WrapError(err, "aggregate", q.kind, "")
+	}
+
+	return q.Min(field)
+}
+
+func (q *memQuery) MaxContext(ctx context.Context, field string) (float64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, data.WrapError(err, "aggregate", q.kind, "")
+	}
+
+	return q.Max(field)
+}
+
+func (q *memQuery) AvgContext(ctx context.Context, field string) (float64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, data.WrapError(err, "aggregate", q.kind, "")
+	}
+
+	return q.Avg(field)
+}
+
+func (q *memQuery) GroupByContext(ctx context.Context, field string) ([]data.Group, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, data.WrapError(err, "aggregate", q.kind, "")
+	}
+
+	return q.GroupBy(field)
+}
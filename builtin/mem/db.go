@@ -6,8 +6,8 @@ import (
 	"fmt"
 	"sort"
 	"strconv"
+	"strings"
 	"sync"
-	"time"
 
 	"github.com/elos/data"
 	"github.com/elos/data/transfer"
@@ -16,24 +16,40 @@ import (
 
 func NewDB() data.DB {
 	return &MemDB{
-		ChangeHub: data.NewChangeHub(context.TODO()),
+		ChangeHub: newChangeHub(),
 		currentID: 0,
-		tables:    make(map[data.Kind]map[data.ID]data.Record),
+		tables:    make(map[data.Kind]map[data.ID]*stored),
+	}
+}
+
+// WithSchema constructs a MemDB which only stores the kinds
+// registered with the schema, rejecting others with
+// data.ErrUnregisteredKind, like the other builtins.
+func WithSchema(s *data.Schema) data.DB {
+	return &MemDB{
+		ChangeHub: newChangeHub(),
+		currentID: 0,
+		tables:    make(map[data.Kind]map[data.ID]*stored),
+		schema:    s,
 	}
 }
 
 func WithData(seed map[data.Kind][]data.Record) data.DB {
-	tables := make(map[data.Kind]map[data.ID]data.Record)
+	tables := make(map[data.Kind]map[data.ID]*stored)
 
 	var maxID int64 = 0
 
 	for kind := range seed {
-		tables[kind] = make(map[data.ID]data.Record)
+		tables[kind] = make(map[data.ID]*stored)
 	}
 
 	for kind, records := range seed {
 		for _, record := range records {
-			tables[kind][record.ID()] = record
+			s, err := store(record)
+			if err != nil {
+				panic(fmt.Sprintf("copying record: %v", err))
+			}
+			tables[kind][record.ID()] = s
 
 			id, err := strconv.ParseInt(record.ID().String(), 10, 64)
 			if err != nil {
@@ -47,31 +63,219 @@ func WithData(seed map[data.Kind][]data.Record) data.DB {
 	}
 
 	return &MemDB{
-		ChangeHub: data.NewChangeHub(context.TODO()),
+		ChangeHub: newChangeHub(),
 		currentID: int(maxID),
 		tables:    tables,
 	}
 }
 
+// newChangeHub constructs the hub of a MemDB, which journals
+// the last DefaultJournalSize changes
+func newChangeHub() *data.ChangeHub {
+	return data.NewJournaledChangeHub(context.TODO(), NewJournal(DefaultJournalSize))
+}
+
+// MemDB is safe for concurrent use. Writers (Save, Delete, NewID)
+// take the write lock, while lookups and query execution share the
+// read lock. A query snapshots the matching table when it is executed,
+// so iterators are unaffected by writes made after Execute returns.
+// Writers notify subscribers once they release the write lock, in the
+// order of the writes.
+//
+// MemDB never aliases a caller's record. Save stores a copy, and
+// PopulateByID, PopulateByField and Iterator.Next populate fresh copies,
+// so changes made to a record after a Save are not persisted. The copy
+// is stored encoded, and decoded into its attributes, once, by the Save,
+// so that queries filter and order by the attributes, and populate from
+// the encoding, without encoding the record again.
+//
+// MemDB journals the last DefaultJournalSize changes, so a subscriber
+// may resume its subscription with ChangesSince.
+//
+// A MemDB is durable if it is opened with Open, see Opts.
 type MemDB struct {
 	*data.ChangeHub
 
+	m         sync.RWMutex
 	currentID int
-	tables    map[data.Kind]map[data.ID]data.Record
+
+	// writes counts the writes which notify, so that they are
+	// notified in order, see unlock
+	writes int
+
+	// notifying guards notified, the count of writes notified,
+	// whose change turn signals
+	notifying sync.Mutex
+	notified  int
+	turn      *sync.Cond
+
+	tables map[data.Kind]map[data.ID]*stored
+
+	// indexes are those declared with EnsureIndex, by kind and field
+	indexes map[data.Kind]map[string]*index
+
+	// schema, if not nil, restricts the kinds which may be stored
+	schema *data.Schema
+
+	// parent, if not nil, is the DB on which the transaction, of which
+	// this is the view, began. The tables of the view then hold only
+	// the records the transaction wrote, or nil for those it deleted,
+	// and overlay those of the parent.
+	parent *MemDB
+
+	// pending, if not nil, collects the changes of a transaction
+	pending *[]*data.Change
+
+	// wal, if not nil, is the write-ahead log of a durable DB
+	wal *wal
+
+	// closed is whether the wal is closed
+	closed bool
+}
+
+// Schema retrieves the schema of the DB, which is nil unless
+// the DB was constructed WithSchema
+func (db *MemDB) Schema() *data.Schema {
+	return db.schema
+}
+
+// registered checks that the kind k may be stored in the DB
+func (db *MemDB) registered(k data.Kind) error {
+	if db.schema != nil && !db.schema.Registered(k) {
+		return data.ErrUnregisteredKind
+	}
+
+	return nil
+}
+
+// lookup retrieves the stored record of the kind k with the id.
+// The caller must hold the lock.
+func (db *MemDB) lookup(k data.Kind, id data.ID) (*stored, bool) {
+	s, ok := db.tables[k][id]
+	if ok || db.parent == nil {
+		return s, s != nil
+	}
+
+	db.parent.m.RLock()
+	defer db.parent.m.RUnlock()
+
+	s, ok = db.parent.tables[k][id]
+	return s, ok
+}
+
+// records retrieves the stored records of the kind k, by id. Those of
+// the view of a transaction are a copy, which overlays the records of
+// the parent with those the transaction wrote. The caller must hold the
+// lock, and must not modify them.
+func (db *MemDB) records(k data.Kind) map[data.ID]*stored {
+	if db.parent == nil {
+		return db.tables[k]
+	}
+
+	db.parent.m.RLock()
+	defer db.parent.m.RUnlock()
+
+	written := db.tables[k]
+	records := make(map[data.ID]*stored, len(db.parent.tables[k])+len(written))
+	for id, s := range db.parent.tables[k] {
+		records[id] = s
+	}
+
+	for id, s := range written {
+		if s == nil {
+			delete(records, id)
+		} else {
+			records[id] = s
+		}
+	}
+
+	return records
+}
+
+// kinds retrieves the kinds of which the DB has a table, in order.
+// The caller must hold the lock.
+func (db *MemDB) kinds() []data.Kind {
+	seen := make(map[data.Kind]bool, len(db.tables))
+	for k := range db.tables {
+		seen[k] = true
+	}
+
+	if db.parent != nil {
+		db.parent.m.RLock()
+		for k := range db.parent.tables {
+			seen[k] = true
+		}
+		db.parent.m.RUnlock()
+	}
+
+	kinds := make([]data.Kind, 0, len(seen))
+	for k := range seen {
+		kinds = append(kinds, k)
+	}
+	sort.Slice(kinds, func(i, j int) bool { return kinds[i] < kinds[j] })
+
+	return kinds
+}
+
+// unlock releases the write lock, and then notifies the changes of the
+// writes made while it was held, see notify. The changes are notified
+// without the lock, so that a subscriber which makes Notify wait stalls
+// neither lookups nor writes, but in the order of the writes, as the
+// write takes its turn to notify while it holds the lock.
+func (db *MemDB) unlock(cs ...*data.Change) {
+	db.writes++
+	turn := db.writes
+
+	db.m.Unlock()
+
+	db.notifying.Lock()
+	defer db.notifying.Unlock()
+
+	if db.turn == nil {
+		db.turn = sync.NewCond(&db.notifying)
+	}
+
+	for db.notified != turn-1 {
+		db.turn.Wait()
+	}
+
+	for _, c := range cs {
+		db.notify(c)
+	}
+
+	db.notified = turn
+	db.turn.Broadcast()
+}
+
+// notify publishes the change, or if the DB is the view of a
+// transaction, defers it until the transaction commits
+func (db *MemDB) notify(c *data.Change) {
+	if db.pending != nil {
+		*db.pending = append(*db.pending, c)
+		return
+	}
+
+	db.ChangeHub.Notify(c)
 }
 
 func (db *MemDB) String() string {
+	db.m.RLock()
+	defer db.m.RUnlock()
+
 	b := new(bytes.Buffer)
-	for k, table := range db.tables {
+	for _, k := range db.kinds() {
 		fmt.Fprintf(b, "%s:\n", k)
-		for _, rec := range table {
-			fmt.Fprintf(b, "\t%v\n", rec)
+		for _, s := range db.records(k) {
+			fmt.Fprintf(b, "\t%v\n", s.record)
 		}
 	}
 	return b.String()
 }
 
 func (db *MemDB) NewID() data.ID {
+	db.m.Lock()
+	defer db.m.Unlock()
+
 	db.currentID += 1
 	return data.ID(fmt.Sprintf("%d", db.currentID))
 }
@@ -80,86 +284,214 @@ func (db *MemDB) ParseID(id string) (data.ID, error) {
 	return data.ID(id), nil
 }
 
-func (db *MemDB) Save(r data.Record) error {
-	table, ok := db.tables[r.Kind()]
-	if !ok {
-		table = make(map[data.ID]data.Record)
-		db.tables[r.Kind()] = table
+func (db *MemDB) Save(r data.Record) (err error) {
+	defer func() { err = data.WrapError(err, "save", r.Kind(), r.ID()) }()
+
+	if err := db.registered(r.Kind()); err != nil {
+		return err
 	}
-	/*
-		var created bool
 
-		if string(r.ID()) == "" {
-			r.SetID(db.NewID())
-			created = true
-		}
+	db.m.Lock()
 
-		table[r.ID()] = r
+	c, err := db.save(r)
+	if err != nil {
+		db.m.Unlock()
+		return err
+	}
 
-		if created {
-			db.ChangeHub.Notify(data.NewCreate(r))
-		} else {
-			db.ChangeHub.Notify(data.NewUpdate(r))
-		}
-	*/
+	db.unlock(c)
+	return nil
+}
 
+// save stores the record, and returns the change to notify.
+// The caller must hold the write lock.
+func (db *MemDB) save(r data.Record) (c *data.Change, err error) {
 	if string(r.ID()) == "" {
-		return data.ErrInvalidID
+		return nil, data.ErrInvalidID
+	}
+
+	old, existed := db.lookup(r.Kind(), r.ID())
+
+	if v, ok := r.(data.Versioned); ok {
+		var current data.Record
+		if existed {
+			current = old.record
+		}
+
+		version, verr := advance(v, current)
+		if verr != nil {
+			return nil, verr
+		}
+
+		// should the save fail, r is left at the version it was
+		defer func() {
+			if err != nil {
+				v.SetVersion(version)
+			}
+		}()
+	}
+
+	// store a copy, so that later changes to r are not persisted
+	s, err := store(r)
+	if err != nil {
+		return nil, err
 	}
 
-	_, existed := table[r.ID()]
+	if err := db.duplicate(s, nil); err != nil {
+		return nil, err
+	}
 
-	table[r.ID()] = r
+	// and notify with another, so subscribers can't change the store
+	notified, err := s.decode()
+	if err != nil {
+		return nil, err
+	}
 
+	c = data.NewCreate(notified)
 	if existed {
-		db.ChangeHub.Notify(data.NewUpdate(r))
-	} else {
-		db.ChangeHub.Notify(data.NewCreate(r))
+		if c, err = update(old, s, notified); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := db.log(saveWrite(s)); err != nil {
+		return nil, err
 	}
 
+	db.put(s)
+	db.logged()
+
+	return c, nil
+}
+
+func (db *MemDB) Delete(r data.Record) (err error) {
+	defer func() { err = data.WrapError(err, "delete", r.Kind(), r.ID()) }()
+
+	if err := db.registered(r.Kind()); err != nil {
+		return err
+	}
+
+	db.m.Lock()
+
+	c, err := db.delete(r)
+	if err != nil {
+		db.m.Unlock()
+		return err
+	}
+
+	db.unlock(c)
 	return nil
 }
 
-func (db *MemDB) Delete(r data.Record) error {
-	table, ok := db.tables[r.Kind()]
+// delete removes the record, and returns the change to notify.
+// The caller must hold the write lock.
+func (db *MemDB) delete(r data.Record) (*data.Change, error) {
+	s, ok := db.lookup(r.Kind(), r.ID())
 	if !ok {
-		return nil
+		return nil, data.ErrNotFound
 	}
 
-	_, ok = table[r.ID()]
-	if !ok {
-		return nil
+	// notify with a copy, so subscribers can't change the store
+	notified, err := s.decode()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.log(deleteWrite(r.Kind(), r.ID())); err != nil {
+		return nil, err
 	}
 
-	delete(table, r.ID())
-	db.ChangeHub.Notify(data.NewDelete(r))
+	db.remove(r.Kind(), r.ID())
+	db.logged()
 
-	return nil
+	return data.NewDelete(notified), nil
 }
 
-func (db *MemDB) PopulateByID(r data.Record) error {
-	table, ok := db.tables[r.Kind()]
-	if !ok {
-		return data.ErrNotFound
+// advance checks that the Versioned record is at the version of
+// the stored record, old, which is nil if there is none, and then
+// increments its version. It returns the version it was at, or
+// data.ErrConflict if it is stale.
+func advance(v data.Versioned, old data.Record) (int, error) {
+	current := 0
+	if old != nil {
+		current, _ = data.VersionOf(old)
 	}
 
-	storedRecord, ok := table[r.ID()]
+	version := v.Version()
+	if version != current {
+		return version, data.ErrConflict
+	}
+
+	v.SetVersion(version + 1)
+	return version, nil
+}
+
+// update constructs the change of an update, from the stored record
+// old to s, which carries the previous record, and the diff. The record
+// r is that of the change, a copy of the record of s.
+func update(old, s *stored, r data.Record) (*data.Change, error) {
+	previous, err := old.decode()
+	if err != nil {
+		return nil, err
+	}
+
+	d := data.DiffAttrs(old.attrs, s.attrs)
+
+	// the attributes are shared by the stored records, so
+	// subscribers are given copies of the values they diff
+	for i := range d {
+		d[i].Old, d[i].New = copyValue(d[i].Old), copyValue(d[i].New)
+	}
+
+	c := data.NewUpdate(r)
+	c.Previous = previous
+	c.Diff = d
+	return c, nil
+}
+
+func (db *MemDB) PopulateByID(r data.Record) (err error) {
+	defer func() { err = data.WrapError(err, "populate", r.Kind(), r.ID()) }()
+
+	if err := db.registered(r.Kind()); err != nil {
+		return err
+	}
+
+	db.m.RLock()
+	defer db.m.RUnlock()
+
+	s, ok := db.lookup(r.Kind(), r.ID())
 	if !ok {
 		return data.ErrNotFound
 	}
 
-	return transfer.TransferAttrs(storedRecord, r)
+	return s.populate(r)
 }
 
-func (db *MemDB) PopulateByField(field string, v interface{}, r data.Record) error {
-	table, ok := db.tables[r.Kind()]
-	if !ok {
+func (db *MemDB) PopulateByField(field string, v interface{}, r data.Record) (err error) {
+	defer func() { err = data.WrapError(err, "populate", r.Kind(), "") }()
+
+	if err := db.registered(r.Kind()); err != nil {
+		return err
+	}
+
+	db.m.RLock()
+	defer db.m.RUnlock()
+
+	v = normalize(v)
+
+	if ids, ok := db.plan(r.Kind(), nil, []*data.Predicate{{Op: data.OpEq, Field: field, Value: v}}); ok {
+		for _, id := range ids {
+			if s, ok := db.lookup(r.Kind(), id); ok && contains(s, field, v) {
+				return s.populate(r)
+			}
+		}
+
 		return data.ErrNotFound
 	}
 
-	for _, stored := range table {
-		if contains(stored, field, v) {
-			return transfer.TransferAttrs(stored, r)
+	for _, s := range db.records(r.Kind()) {
+		if contains(s, field, v) {
+			return s.populate(r)
 		}
 	}
 
@@ -182,165 +514,266 @@ type memQuery struct {
 	kind               data.Kind
 	db                 *MemDB
 	wheres             map[string]interface{}
+	predicates         []*data.Predicate
 	limit, skip, batch int
 	order              []string
+	projection         data.Projection
+	m                  sync.Mutex
 }
 
 func (q *memQuery) Execute() (data.Iterator, error) {
-	return q.exec()
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	iter, err := q.exec()
+	return iter, data.WrapError(err, "query", q.kind, "")
 }
 
 func (q *memQuery) exec() (data.Iterator, error) {
-	table, ok := q.db.tables[q.kind]
-	if !ok {
-		out := make(chan data.Record)
-		defer close(out)
-		return Iter(out), nil
+	if err := q.db.registered(q.kind); err != nil {
+		return nil, err
 	}
 
-	in := make(chan data.Record, len(table))
-
-	for _, r := range table {
-		in <- r
-	}
-	defer close(in)
+	in, ordered := q.snapshot()
 
-	var out <-chan data.Record = in
+	out := q.filtered(in)
 
-	for s, v := range q.wheres {
-		out = filter(out, s, v)
+	// the records are ordered before they are skipped or limited
+	if !ordered {
+		out = sorted(out, q.order...)
 	}
 
-	buffer := make(chan data.Record)
+	buffer := make(chan *stored)
 
 	// buffer and simulate skipping/limitting
 
+	skip, limit := q.skip, q.limit
 	go func() {
+		defer close(buffer)
+
 		index := -1 // so that it starts at 0 on the first receive
 		for r := range out {
 			index++
 
-			if q.skip != 0 && index < q.skip {
+			if skip != 0 && index < skip {
 				continue // don't forward
 			}
 
-			if q.limit != 0 && index >= q.limit {
-				close(buffer)
+			// the limit counts the records forwarded, not those skipped
+			if limit != 0 && index-skip >= limit {
+				// drain, so that the stages before this one finish
+				for range out {
+				}
 				return
 			}
 
 			buffer <- r
 		}
-		close(buffer)
 	}()
 
-	return Iter(sorted(buffer, q.order...)), nil
+	return iter(buffer, q.projection), nil
 }
 
-type rMap struct {
-	m map[string]interface{}
-	data.Record
-}
+// filtered forwards the records which satisfy the selection and predicates
+func (q *memQuery) filtered(in <-chan *stored) <-chan *stored {
+	out := in
+
+	for field, v := range q.wheres {
+		out = filter(out, field, normalize(v))
+	}
+
+	for _, p := range q.predicates {
+		out = where(out, p)
+	}
 
-type byFields struct {
-	records []*rMap
-	fields  []string
+	return out
 }
 
-func (b *byFields) Len() int { return len(b.records) }
+// snapshot copies the records of the query's kind into a closed,
+// buffered channel, holding the read lock only for the copy. Only
+// the records which may match are copied, if an index serves the
+// query. If the query orders by a single field with an OrderedIndex,
+// the records are copied in order, and ordered is true.
+func (q *memQuery) snapshot() (in <-chan *stored, ordered bool) {
+	q.db.m.RLock()
+	defer q.db.m.RUnlock()
 
-func (b *byFields) Less(i, j int) bool {
-	for _, f := range b.fields {
-		if lessThan(b.records[i].m[f], b.records[j].m[f]) {
-			return true
+	ids, planned := q.db.plan(q.kind, q.wheres, q.predicates)
+
+	var (
+		records []*stored
+
+		// copied is only needed if records are indexed by several values
+		copied map[data.ID]bool
+	)
+
+	add := func(id data.ID) {
+		r, ok := q.db.lookup(q.kind, id)
+		if !ok || copied[id] {
+			return
 		}
 
-		if greaterThan(b.records[i].m[f], b.records[j].m[f]) {
-			return false
+		if copied != nil {
+			copied[id] = true
 		}
+		records = append(records, r)
 	}
 
-	return true
+	var (
+		ix *index
+		o  ordering
+	)
+
+	if len(q.order) == 1 {
+		o = orderings(q.order)[0]
+		if i, ok := q.db.indexes[q.kind][o.field]; ok && i.typ == OrderedIndex {
+			ix = i
+		}
+	}
+
+	switch {
+	case ix != nil:
+		var candidates map[data.ID]bool
+		if planned {
+			candidates = make(map[data.ID]bool, len(ids))
+			for _, id := range ids {
+				candidates[id] = true
+			}
+		}
+
+		if len(ix.ordered) > len(ix.values) {
+			copied = make(map[data.ID]bool)
+		}
+
+		// without filters, only the records skipped and those
+		// within the limit are needed
+		needed := len(ix.ordered)
+		if len(q.wheres) == 0 && len(q.predicates) == 0 && q.limit != 0 {
+			needed = q.skip + q.limit
+		}
+
+		// a record is ordered by its least value, or its greatest if
+		// descending, which is the first of its entries encountered
+		ix.walk(o.descending, func(e entry) bool {
+			if !planned || candidates[e.id] {
+				add(e.id)
+			}
+			return len(records) < needed
+		})
+	case planned:
+		copied = make(map[data.ID]bool, len(ids))
+		for _, id := range ids {
+			add(id)
+		}
+	default:
+		table := q.db.records(q.kind)
+		records = make([]*stored, 0, len(table))
+		for _, r := range table {
+			records = append(records, r)
+		}
+	}
+
+	c := make(chan *stored, len(records))
+	for _, r := range records {
+		c <- r
+	}
+	close(c)
+
+	return c, ix != nil
 }
 
-func (b *byFields) Swap(i, j int) {
-	b.records[i], b.records[j] = b.records[j], b.records[i]
+// ordering is a field by which records are ordered
+type ordering struct {
+	field      string
+	descending bool
 }
 
-func lessThan(i, j interface{}) bool {
-	switch i.(type) {
-	case bool:
-		return false
-	case int:
-		return i.(int) < j.(int)
-	case float64:
-		return i.(float64) < j.(float64)
-	case string:
-		return i.(string) < j.(string)
-	case time.Time:
-		return i.(time.Time).Before(j.(time.Time))
-	default:
-		return false
+// orderings parses the fields of Order, a field prefixed
+// with a '-' is in descending order, as in mongo's Sort
+func orderings(fields []string) []ordering {
+	os := make([]ordering, len(fields))
+	for i, f := range fields {
+		if strings.HasPrefix(f, "-") {
+			os[i] = ordering{field: f[1:], descending: true}
+		} else {
+			os[i] = ordering{field: f}
+		}
 	}
+	return os
 }
 
-func greaterThan(i, j interface{}) bool {
-	switch i.(type) {
-	case bool:
-		return false
-	case int:
-		return i.(int) > j.(int)
-	case float64:
-		return i.(float64) > j.(float64)
-	case string:
-		return i.(string) > j.(string)
-	case time.Time:
-		return i.(time.Time).After(j.(time.Time))
-	default:
-		return false
+// sortKey determines the value by which the field orders the record
+// with the attributes attrs. As in mongo, a record is ordered by the
+// least of the elements of an array in ascending order, and by the
+// greatest in descending order. A missing field, or one whose value
+// isn't a scalar, is ordered as nil.
+func sortKey(attrs data.AttrMap, o ordering) interface{} {
+	vs := orderedValues(attrs[o.field])
+
+	key := vs[0]
+	for _, v := range vs[1:] {
+		c := compareOrdered(v, key)
+		if (c < 0 && !o.descending) || (c > 0 && o.descending) {
+			key = v
+		}
 	}
+	return key
 }
 
-func sorted(in <-chan data.Record, fields ...string) <-chan data.Record {
+// sorted orders the records by the fields. Values are ordered first by
+// type: nil, then numbers, then strings, then booleans, and then by value.
+// Records which order equally by every field are ordered by id.
+func sorted(in <-chan *stored, fields ...string) <-chan *stored {
 	if len(fields) == 0 {
 		return in
 	}
 
-	out := make(chan data.Record)
+	os := orderings(fields)
+	out := make(chan *stored)
 
 	go func() {
-		records := make([]*rMap, 0)
+		type keyed struct {
+			*stored
+			keys []interface{}
+		}
 
-		for r := range in {
-			m := &rMap{
-				m:      make(map[string]interface{}),
-				Record: r,
+		records := make([]keyed, 0)
+
+		for s := range in {
+			keys := make([]interface{}, len(os))
+			for i, o := range os {
+				keys[i] = sortKey(s.attrs, o)
 			}
-			transfer.TransferAttrs(r, &m.m)
-			records = append(records, m)
+			records = append(records, keyed{s, keys})
 		}
 
-		b := &byFields{
-			records: records,
-			fields:  fields,
-		}
+		sort.SliceStable(records, func(i, j int) bool {
+			for f, o := range os {
+				c := compareOrdered(records[i].keys[f], records[j].keys[f])
+				if o.descending {
+					c = -c
+				}
 
-		sort.Sort(b)
+				if c != 0 {
+					return c < 0
+				}
+			}
+
+			return records[i].record.ID() < records[j].record.ID()
+		})
 
 		for _, r := range records {
-			out <- r.Record
+			out <- r.stored
 		}
 		close(out)
 	}()
 	return out
 }
 
-func contains(r data.Record, field string, v interface{}) bool {
-	m := make(map[string]interface{})
-	if err := transfer.TransferAttrs(r, &m); err != nil {
-		panic(fmt.Sprintf("trying to transfer from %+v of type %T error: %v", r, r, err))
-	}
-	return equals(m[field], v)
+// contains reports whether the field of the stored record
+// has the value v, which must be normalized
+func contains(s *stored, field string, v interface{}) bool {
+	return equals(s.attrs[field], v)
 }
 
 func equals(v interface{}, w interface{}) bool {
@@ -370,14 +803,15 @@ func equals(v interface{}, w interface{}) bool {
 	}
 }
 
-// this is slow af
-func filter(in <-chan data.Record, field string, v interface{}) <-chan data.Record {
-	out := make(chan data.Record)
+// filter forwards the records whose field has
+// the value v, which must be normalized
+func filter(in <-chan *stored, field string, v interface{}) <-chan *stored {
+	out := make(chan *stored)
 
 	go func() {
-		for r := range in {
-			if contains(r, field, v) {
-				out <- r
+		for s := range in {
+			if contains(s, field, v) {
+				out <- s
 			}
 		}
 
@@ -388,32 +822,78 @@ func filter(in <-chan data.Record, field string, v interface{}) <-chan data.Reco
 }
 
 func (q *memQuery) Skip(i int) data.Query {
+	q.m.Lock()
+	defer q.m.Unlock()
+
 	q.skip = i
 	return q
 }
 
 func (q *memQuery) Limit(i int) data.Query {
+	q.m.Lock()
+	defer q.m.Unlock()
+
 	q.limit = i
 	return q
 }
 
 func (q *memQuery) Batch(i int) data.Query {
+	q.m.Lock()
+	defer q.m.Unlock()
+
 	q.batch = i
 	return q
 }
 
 func (q *memQuery) Select(m data.AttrMap) data.Query {
+	q.m.Lock()
+	defer q.m.Unlock()
+
 	for k, v := range m {
 		q.wheres[k] = v
 	}
 	return q
 }
 
+func (q *memQuery) Where(p *data.Predicate) data.Query {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	q.predicates = append(q.predicates, normalizePredicate(p))
+	return q
+}
+
+// Order sorts by the fields, in ascending order unless the field
+// is prefixed with a '-', see sorted. The records are ordered before
+// they are skipped or limited.
 func (q *memQuery) Order(fields ...string) data.Query {
+	q.m.Lock()
+	defer q.m.Unlock()
+
 	q.order = fields
 	return q
 }
 
+// Include fetches only the fields, see data.Query. The
+// records are partially transferred from their attributes.
+func (q *memQuery) Include(fields ...string) data.Query {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	q.projection.Include = append([]string(nil), fields...)
+	return q
+}
+
+func (q *memQuery) Exclude(fields ...string) data.Query {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	q.projection.Exclude = append([]string(nil), fields...)
+	return q
+}
+
+// Iter iterates the records received from the channel, transferring
+// their attributes. Closing the iterator doesn't drain the channel.
 func Iter(c <-chan data.Record) data.Iterator {
 	return &memIter{
 		inbound: c,
@@ -422,23 +902,119 @@ func Iter(c <-chan data.Record) data.Iterator {
 
 type memIter struct {
 	inbound <-chan data.Record
+	err     error
+	closed  bool
 	sync.Mutex
 }
 
 func (i *memIter) Next(r data.Record) bool {
+	i.Lock()
+	defer i.Unlock()
+
+	if i.err != nil || i.closed {
+		return false
+	}
+
 	in, ok := <-i.inbound
+	if !ok {
+		return false
+	}
 
-	if ok {
-		transfer.TransferAttrs(in, r)
+	if err := transfer.TransferAttrs(in, r); err != nil {
+		i.err = data.WrapError(err, "iterate", in.Kind(), in.ID())
+		return false
 	}
 
-	return ok
+	return true
+}
+
+func (i *memIter) Err() error {
+	i.Lock()
+	defer i.Unlock()
+
+	return i.err
 }
 
 func (i *memIter) Close() error {
-	return nil
+	i.Lock()
+	defer i.Unlock()
+
+	i.closed = true
+	return i.err
+}
+
+// iter iterates the stored records, populating from their
+// encodings, or only the attributes the projection fetches
+func iter(c <-chan *stored, p data.Projection) data.Iterator {
+	return &storedIter{
+		inbound:    c,
+		projection: p,
+	}
+}
+
+type storedIter struct {
+	inbound    <-chan *stored
+	projection data.Projection
+	err        error
+	closed     bool
+	sync.Mutex
+}
+
+func (i *storedIter) Next(r data.Record) bool {
+	i.Lock()
+	defer i.Unlock()
+
+	if i.err != nil || i.closed {
+		return false
+	}
+
+	s, ok := <-i.inbound
+	if !ok {
+		return false
+	}
+
+	var err error
+	if i.projection.Restricts() {
+		err = s.project(r, i.projection)
+	} else {
+		err = s.populate(r)
+	}
+
+	if err != nil {
+		i.err = data.WrapError(err, "iterate", s.record.Kind(), s.record.ID())
+		return false
+	}
+
+	return true
+}
+
+func (i *storedIter) Err() error {
+	i.Lock()
+	defer i.Unlock()
+
+	return i.err
+}
+
+// Close drains the records which remain, so that the
+// stages of the query which produce them finish
+func (i *storedIter) Close() error {
+	i.Lock()
+	defer i.Unlock()
+
+	if !i.closed {
+		i.closed = true
+		go func() {
+			for range i.inbound {
+			}
+		}()
+	}
+
+	return i.err
 }
 
+// Slice collects the records of the iterator, each constructed by the
+// constructor. It drops the error of the iterator, data.All retrieves
+// records of their concrete type, and returns the error.
 func Slice(i data.Iterator, constructor func() data.Record) []data.Record {
 	results := make([]data.Record, 0)
 
@@ -1,11 +1,17 @@
 package mem_test
 
 import (
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/elos/data"
 	"github.com/elos/data/builtin/mem"
+	"github.com/elos/data/dbtest"
 )
 
 const TestRecordKind data.Kind = "test"
@@ -168,6 +174,82 @@ func TestQueryOrder(t *testing.T) {
 	}
 }
 
+type orderRecord struct {
+	Id    string      `json:"id"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+func (r *orderRecord) Kind() data.Kind  { return "order_record" }
+func (r *orderRecord) ID() data.ID      { return data.ID(r.Id) }
+func (r *orderRecord) SetID(id data.ID) { r.Id = id.String() }
+
+func TestQueryOrderValues(t *testing.T) {
+	records := []*orderRecord{
+		{Id: "a", Value: "b"},
+		{Id: "b", Value: 10},
+		{Id: "c"},
+		{Id: "d", Value: true},
+		{Id: "e", Value: 1.5},
+		{Id: "f", Value: []interface{}{3, "z"}},
+		{Id: "g", Value: "a"},
+		{Id: "h", Value: map[string]interface{}{"x": 1}},
+		{Id: "i", Value: false},
+		{Id: "j", Value: 2},
+		{Id: "k"},
+	}
+
+	cases := []struct {
+		name  string
+		order []string
+		want  string
+	}{
+		// missing values and objects order as nil, before numbers,
+		// strings and booleans, and arrays by their least element
+		{"ascending", []string{"value"}, "c h k e j f b g a i d"},
+
+		// arrays order by their greatest element, and records
+		// which order equally are ordered by id, either way
+		{"descending", []string{"-value"}, "d i f a g b j e c h k"},
+
+		{"by id", []string{"-id"}, "k j i h g f e d c b a"},
+		{"then by id", []string{"value", "-id"}, "k h c e j f b g a i d"},
+	}
+
+	for _, indexed := range []bool{false, true} {
+		db := mem.NewDB().(*mem.MemDB)
+
+		if indexed {
+			if err := db.EnsureIndex("order_record", "value", mem.OrderedIndex); err != nil {
+				t.Fatalf("db.EnsureIndex error: %v", err)
+			}
+		}
+
+		for _, r := range records {
+			if err := db.Save(r); err != nil {
+				t.Fatalf("db.Save error: %v", err)
+			}
+		}
+
+		for _, c := range cases {
+			iter, err := db.Query("order_record").Order(c.order...).Execute()
+			if err != nil {
+				t.Fatalf("%s: q.Execute error: %v", c.name, err)
+			}
+
+			ids := make([]string, 0)
+			r := new(orderRecord)
+			for iter.Next(r) {
+				ids = append(ids, r.Id)
+				r = new(orderRecord)
+			}
+
+			if got := strings.Join(ids, " "); got != c.want {
+				t.Errorf("%s (indexed: %t): got %s, want %s", c.name, indexed, got, c.want)
+			}
+		}
+	}
+}
+
 func TestQueryPtr(t *testing.T) {
 	db := mem.WithData(map[data.Kind][]data.Record{
 		TestRecordKind: []data.Record{
@@ -258,3 +340,786 @@ func TestQueryPtr(t *testing.T) {
 		t.Fatalf("iter.Close error: %v", err)
 	}
 }
+
+func TestConcurrentAccess(t *testing.T) {
+	db := mem.NewDB()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			for j := 0; j < 50; j++ {
+				tr := &TestRecord{Name: "concurrent", Count: j}
+				tr.SetID(db.NewID())
+
+				if err := db.Save(tr); err != nil {
+					t.Errorf("db.Save error: %v", err)
+					return
+				}
+
+				if err := db.PopulateByID(&TestRecord{Id: tr.Id}); err != nil {
+					t.Errorf("db.PopulateByID error: %v", err)
+					return
+				}
+
+				iter, err := db.Query(TestRecordKind).Select(data.AttrMap{"Count": j}).Order("Name").Execute()
+				if err != nil {
+					t.Errorf("db.Query error: %v", err)
+					return
+				}
+
+				for iter.Next(new(TestRecord)) {
+				}
+
+				if err := iter.Close(); err != nil {
+					t.Errorf("iter.Close error: %v", err)
+					return
+				}
+
+				if j%2 == 0 {
+					if err := db.Delete(tr); err != nil {
+						t.Errorf("db.Delete error: %v", err)
+						return
+					}
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	iter, err := db.Query(TestRecordKind).Execute()
+	if err != nil {
+		t.Fatalf("db.Query error: %v", err)
+	}
+
+	if got, want := len(mem.Slice(iter, func() data.Record { return new(TestRecord) })), 10*25; got != want {
+		t.Errorf("len(records): got %d, want %d", got, want)
+	}
+}
+
+func TestQuerySnapshot(t *testing.T) {
+	db := mem.NewDB()
+
+	for i := 0; i < 3; i++ {
+		tr := &TestRecord{Name: "before"}
+		tr.SetID(db.NewID())
+		if err := db.Save(tr); err != nil {
+			t.Fatalf("db.Save error: %v", err)
+		}
+	}
+
+	iter, err := db.Query(TestRecordKind).Execute()
+	if err != nil {
+		t.Fatalf("db.Query error: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		tr := &TestRecord{Name: "after"}
+		tr.SetID(db.NewID())
+		if err := db.Save(tr); err != nil {
+			t.Fatalf("db.Save error: %v", err)
+		}
+	}
+
+	records := mem.Slice(iter, func() data.Record { return new(TestRecord) })
+
+	if got, want := len(records), 3; got != want {
+		t.Fatalf("len(records): got %d, want %d", got, want)
+	}
+
+	for _, r := range records {
+		if got, want := r.(*TestRecord).Name, "before"; got != want {
+			t.Errorf("r.Name: got %q, want %q", got, want)
+		}
+	}
+}
+
+func TestSaveCopies(t *testing.T) {
+	db := mem.NewDB()
+
+	tr := &TestRecord{Name: "saved", Ptr: &s{Foo: "saved"}}
+	tr.SetID(db.NewID())
+
+	if err := db.Save(tr); err != nil {
+		t.Fatalf("db.Save error: %v", err)
+	}
+
+	tr.Name = "mutated"
+	tr.Ptr.Foo = "mutated"
+
+	populated := &TestRecord{Id: tr.Id}
+	if err := db.PopulateByID(populated); err != nil {
+		t.Fatalf("db.PopulateByID error: %v", err)
+	}
+
+	if got, want := populated.Name, "saved"; got != want {
+		t.Errorf("populated.Name: got %q, want %q", got, want)
+	}
+
+	if got, want := populated.Ptr.Foo, "saved"; got != want {
+		t.Errorf("populated.Ptr.Foo: got %q, want %q", got, want)
+	}
+
+	populated.Ptr.Foo = "mutated"
+
+	byField := new(TestRecord)
+	if err := db.PopulateByField("Name", "saved", byField); err != nil {
+		t.Fatalf("db.PopulateByField error: %v", err)
+	}
+
+	if got, want := byField.Ptr.Foo, "saved"; got != want {
+		t.Errorf("byField.Ptr.Foo: got %q, want %q", got, want)
+	}
+
+	iter, err := db.Query(TestRecordKind).Execute()
+	if err != nil {
+		t.Fatalf("db.Query error: %v", err)
+	}
+
+	iterated := new(TestRecord)
+	if got, want := iter.Next(iterated), true; got != want {
+		t.Fatalf("iter.Next: got %t, want %t", got, want)
+	}
+
+	if got, want := iterated.Ptr.Foo, "saved"; got != want {
+		t.Errorf("iterated.Ptr.Foo: got %q, want %q", got, want)
+	}
+
+	if iterated.Ptr == byField.Ptr || iterated.Ptr == populated.Ptr {
+		t.Error("iterated.Ptr: expected a fresh copy")
+	}
+}
+
+func TestDiffCopies(t *testing.T) {
+	db := mem.NewDB()
+
+	r := &dbtest.Record{Name: "diffed", Tags: []string{"before"}}
+	r.SetID(db.NewID())
+	if err := db.Save(r); err != nil {
+		t.Fatalf("db.Save error: %v", err)
+	}
+
+	changes := db.Changes()
+
+	r.Tags = []string{"after"}
+	if err := db.Save(r); err != nil {
+		t.Fatalf("db.Save error: %v", err)
+	}
+
+	c := <-*changes
+	if got, want := len(c.Diff), 1; got != want {
+		t.Fatalf("len(c.Diff): got %d, want %d", got, want)
+	}
+
+	// the diff's values must not be those the DB filters by
+	c.Diff[0].New.([]interface{})[0] = "mutated"
+
+	if got, want := len(names(t, db.Query(dbtest.RecordKind).Where(data.Eq("tags", "after")))), 1; got != want {
+		t.Errorf("records tagged after: got %d, want %d", got, want)
+	}
+}
+
+func TestDeleteCopies(t *testing.T) {
+	db := mem.NewDB()
+
+	r := &dbtest.Record{Name: "deleted"}
+	r.SetID(db.NewID())
+	if err := db.Save(r); err != nil {
+		t.Fatalf("db.Save error: %v", err)
+	}
+
+	// the transaction began before the delete
+	tx, err := db.(data.Transactor).Begin()
+	if err != nil {
+		t.Fatalf("Begin error: %v", err)
+	}
+	defer tx.Rollback()
+
+	changes := db.Changes()
+
+	if err := db.Delete(r); err != nil {
+		t.Fatalf("db.Delete error: %v", err)
+	}
+
+	// the record notified must not be the one the DB stored
+	c := <-*changes
+	c.Record.(*dbtest.Record).Name = "mutated"
+
+	if s := tx.(fmt.Stringer).String(); strings.Contains(s, "mutated") {
+		t.Errorf("tx.String: got %q, want the record unchanged", s)
+	}
+}
+
+func TestBlockedSubscriber(t *testing.T) {
+	db := mem.NewDB().(*mem.MemDB)
+
+	r := &dbtest.Record{Name: "blocked"}
+	r.SetID(db.NewID())
+	if err := db.Save(r); err != nil {
+		t.Fatalf("db.Save error: %v", err)
+	}
+
+	// a subscriber which makes Notify wait, and never receives
+	c := db.Subscribe(data.SubscribeOpts{Buffer: 0, Overflow: data.Block})
+	defer db.Unsubscribe(c)
+
+	// populated reports whether a record with the name is found,
+	// within a second, the lookups waiting for no subscriber
+	populated := func(name string) bool {
+		found := make(chan bool, 1)
+		go func() {
+			for {
+				if err := db.PopulateByField("name", name, new(dbtest.Record)); err == nil {
+					found <- true
+					return
+				}
+				time.Sleep(time.Millisecond)
+			}
+		}()
+
+		select {
+		case <-found:
+			return true
+		case <-time.After(time.Second):
+			return false
+		}
+	}
+
+	saved := make(chan error, 2)
+
+	r.Name = "first"
+	go func() { saved <- db.Save(r) }()
+
+	// the save waits to notify, without the lock, so lookups proceed
+	if !populated("first") {
+		t.Fatal("db.PopulateByField blocked on a subscriber")
+	}
+
+	// as do other writes
+	second := &dbtest.Record{Name: "second"}
+	second.SetID(db.NewID())
+	go func() { saved <- db.Save(second) }()
+
+	if !populated("second") {
+		t.Fatal("db.Save blocked on a subscriber")
+	}
+
+	// the saves complete once the subscriber receives, in order
+	for _, name := range []string{"first", "second"} {
+		change := <-*c
+		if got, want := change.Record.(*dbtest.Record).Name, name; got != want {
+			t.Errorf("change: got %q, want %q", got, want)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := <-saved; err != nil {
+			t.Errorf("db.Save error: %v", err)
+		}
+	}
+}
+
+// unserializedRecord doesn't serialize its id
+type unserializedRecord struct {
+	Id   string `json:"-"`
+	Name string `json:"name"`
+}
+
+func (r *unserializedRecord) Kind() data.Kind  { return "unserialized" }
+func (r *unserializedRecord) ID() data.ID      { return data.ID(r.Id) }
+func (r *unserializedRecord) SetID(id data.ID) { r.Id = id.String() }
+
+func TestUnserializedID(t *testing.T) {
+	db := mem.NewDB()
+
+	r := &unserializedRecord{Name: "unserialized"}
+	r.SetID(db.NewID())
+	if err := db.Save(r); err != nil {
+		t.Fatalf("db.Save error: %v", err)
+	}
+
+	// a stale id must be replaced
+	byField := &unserializedRecord{Id: "stale"}
+	if err := db.PopulateByField("name", "unserialized", byField); err != nil {
+		t.Fatalf("db.PopulateByField error: %v", err)
+	}
+
+	if got, want := byField.Id, r.Id; got != want {
+		t.Errorf("byField.Id: got %q, want %q", got, want)
+	}
+
+	iter, err := db.Query("unserialized").Execute()
+	if err != nil {
+		t.Fatalf("q.Execute error: %v", err)
+	}
+	defer iter.Close()
+
+	iterated := new(unserializedRecord)
+	if !iter.Next(iterated) {
+		t.Fatal("iter.Next: got false, want true")
+	}
+
+	if got, want := iterated.Id, r.Id; got != want {
+		t.Errorf("iterated.Id: got %q, want %q", got, want)
+	}
+}
+
+func TestSeedCopies(t *testing.T) {
+	tr := &TestRecord{Id: "1", Name: "seeded"}
+
+	db := mem.WithData(map[data.Kind][]data.Record{
+		TestRecordKind: []data.Record{tr},
+	})
+
+	tr.Name = "mutated"
+
+	populated := &TestRecord{Id: "1"}
+	if err := db.PopulateByID(populated); err != nil {
+		t.Fatalf("db.PopulateByID error: %v", err)
+	}
+
+	if got, want := populated.Name, "seeded"; got != want {
+		t.Errorf("populated.Name: got %q, want %q", got, want)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	dbtest.TestDB(t, func() (data.DB, error) {
+		return mem.NewDB(), nil
+	})
+}
+
+func TestWithSchema(t *testing.T) {
+	s := data.NewSchema()
+	s.Register(&data.Model{Kind: dbtest.RecordKind})
+	s.Register(dbtest.UniqueModel(""))
+
+	dbtest.TestDB(t, func() (data.DB, error) {
+		return mem.WithSchema(s), nil
+	})
+
+	db := mem.WithSchema(s)
+
+	tr := &TestRecord{}
+	tr.SetID(db.NewID())
+
+	if got, want := db.Save(tr), data.ErrUnregisteredKind; !errors.Is(got, want) {
+		t.Errorf("db.Save: got %v, want %v", got, want)
+	}
+
+	if got, want := db.PopulateByID(tr), data.ErrUnregisteredKind; !errors.Is(got, want) {
+		t.Errorf("db.PopulateByID: got %v, want %v", got, want)
+	}
+
+	if _, err := db.Query(TestRecordKind).Execute(); !errors.Is(err, data.ErrUnregisteredKind) {
+		t.Errorf("db.Query: got %v, want %v", err, data.ErrUnregisteredKind)
+	}
+}
+
+// legacy hides the context aware methods of the DB
+type legacy struct {
+	data.DB
+}
+
+func TestLegacyContext(t *testing.T) {
+	dbtest.TestContext(t, func() (data.DB, error) {
+		return legacy{mem.NewDB()}, nil
+	})
+}
+
+func TestJournalTruncated(t *testing.T) {
+	j := mem.NewJournal(2)
+
+	for i := 0; i < 3; i++ {
+		if err := j.Append(data.NewUpdate(&dbtest.Record{})); err != nil {
+			t.Fatalf("j.Append error: %v", err)
+		}
+	}
+
+	if _, err := j.Since(0); err != data.ErrJournalTruncated {
+		t.Errorf("j.Since(0): got %v, want %v", err, data.ErrJournalTruncated)
+	}
+
+	changes, err := j.Since(1)
+	if err != nil {
+		t.Fatalf("j.Since(1) error: %v", err)
+	}
+
+	if got, want := len(changes), 2; got != want {
+		t.Fatalf("len(changes): got %d, want %d", got, want)
+	}
+
+	if got, want := changes[0].Seq, uint64(2); got != want {
+		t.Errorf("changes[0].Seq: got %d, want %d", got, want)
+	}
+
+	// a subscriber which is caught up has no changes to replay
+	if changes, err := j.Since(3); err != nil || len(changes) != 0 {
+		t.Errorf("j.Since(3): got %v, %v, want no changes", changes, err)
+	}
+
+	// while one ahead of the journal saw the changes of another
+	if _, err := j.Since(4); err != data.ErrJournalTruncated {
+		t.Errorf("j.Since(4): got %v, want %v", err, data.ErrJournalTruncated)
+	}
+}
+
+// misfit decodes the records of the dbtest.RecordKind, but can't
+// decode their names
+type misfit struct {
+	Id   string `json:"id"`
+	Name int    `json:"name"`
+}
+
+func (m *misfit) Kind() data.Kind  { return dbtest.RecordKind }
+func (m *misfit) ID() data.ID      { return data.ID(m.Id) }
+func (m *misfit) SetID(id data.ID) { m.Id = id.String() }
+
+func TestIterDecodeError(t *testing.T) {
+	db := mem.NewDB()
+
+	if err := db.Save(&dbtest.Record{Id: "1", Name: "one"}); err != nil {
+		t.Fatalf("db.Save error: %v", err)
+	}
+
+	iter, err := db.Query(dbtest.RecordKind).Execute()
+	if err != nil {
+		t.Fatalf("q.Execute error: %v", err)
+	}
+
+	if iter.Next(new(misfit)) {
+		t.Error("iter.Next: got true, want false")
+	}
+
+	var e *data.Error
+	if err := iter.(data.ErrIterator).Err(); !errors.As(err, &e) || e.Kind != dbtest.RecordKind || e.ID != "1" {
+		t.Errorf("iter.Err: got %v, want error of %s 1", err, dbtest.RecordKind)
+	}
+
+	if err := iter.Close(); err == nil {
+		t.Error("iter.Close: got nil error")
+	}
+}
+
+func TestIterClose(t *testing.T) {
+	db := mem.NewDB()
+
+	for i := 0; i < 100; i++ {
+		r := &dbtest.Record{Name: "closed", Count: i}
+		r.SetID(db.NewID())
+		if err := db.Save(r); err != nil {
+			t.Fatalf("db.Save error: %v", err)
+		}
+	}
+
+	before := runtime.NumGoroutine()
+
+	iter, err := db.Query(dbtest.RecordKind).Select(data.AttrMap{"name": "closed"}).Order("-count").Execute()
+	if err != nil {
+		t.Fatalf("q.Execute error: %v", err)
+	}
+
+	if !iter.Next(new(dbtest.Record)) {
+		t.Fatal("iter.Next: got false, want true")
+	}
+
+	if err := iter.Close(); err != nil {
+		t.Fatalf("iter.Close error: %v", err)
+	}
+
+	// the stages of the query finish, once the iterator is closed
+	for deadline := time.Now().Add(time.Second); runtime.NumGoroutine() > before; {
+		if time.Now().After(deadline) {
+			t.Fatalf("goroutines: got %d, want %d", runtime.NumGoroutine(), before)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestTxConflict(t *testing.T) {
+	db := mem.NewDB()
+
+	r := &dbtest.VersionedRecord{Name: "read"}
+	r.SetID(db.NewID())
+	if err := db.Save(r); err != nil {
+		t.Fatalf("db.Save error: %v", err)
+	}
+
+	tx, err := db.(data.Transactor).Begin()
+	if err != nil {
+		t.Fatalf("db.Begin error: %v", err)
+	}
+
+	inTx := *r
+	inTx.Name = "in tx"
+	if err := tx.Save(&inTx); err != nil {
+		t.Fatalf("tx.Save error: %v", err)
+	}
+
+	r.Name = "meanwhile"
+	if err := db.Save(r); err != nil {
+		t.Fatalf("db.Save error: %v", err)
+	}
+
+	if err := tx.Commit(); !errors.Is(err, data.ErrConflict) {
+		t.Errorf("tx.Commit: got %v, want data.ErrConflict", err)
+	}
+
+	// the record is left at the version it was read
+	if got, want := inTx.Ver, 1; got != want {
+		t.Errorf("inTx.Version: got %d, want %d", got, want)
+	}
+
+	stored := &dbtest.VersionedRecord{Id: r.Id}
+	if err := db.PopulateByID(stored); err != nil {
+		t.Fatalf("db.PopulateByID error: %v", err)
+	}
+
+	if got, want := stored.Name, "meanwhile"; got != want {
+		t.Errorf("stored.Name: got %q, want %q", got, want)
+	}
+}
+
+func TestTxOverlay(t *testing.T) {
+	db := mem.NewDB().(*mem.MemDB)
+
+	if err := db.EnsureIndex(dbtest.RecordKind, "name", mem.HashIndex); err != nil {
+		t.Fatalf("db.EnsureIndex error: %v", err)
+	}
+
+	kept, rewritten, deleted := &dbtest.Record{Name: "kept"}, &dbtest.Record{Name: "rewritten"}, &dbtest.Record{Name: "deleted"}
+	for _, r := range []*dbtest.Record{kept, rewritten, deleted} {
+		r.SetID(db.NewID())
+		if err := db.Save(r); err != nil {
+			t.Fatalf("db.Save error: %v", err)
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("db.Begin error: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := tx.Save(&dbtest.Record{Id: rewritten.Id, Name: "written"}); err != nil {
+		t.Fatalf("tx.Save error: %v", err)
+	}
+
+	if err := tx.Delete(deleted); err != nil {
+		t.Fatalf("tx.Delete error: %v", err)
+	}
+
+	// the transaction sees the records the DB commits meanwhile
+	committed := &dbtest.Record{Name: "committed"}
+	committed.SetID(db.NewID())
+	if err := db.Save(committed); err != nil {
+		t.Fatalf("db.Save error: %v", err)
+	}
+
+	for _, c := range []struct {
+		name  string
+		found bool
+	}{
+		{"kept", true},
+		{"rewritten", false},
+		{"written", true},
+		{"deleted", false},
+		{"committed", true},
+	} {
+		err := tx.PopulateByField("name", c.name, new(dbtest.Record))
+		if got, want := err == nil, c.found; got != want {
+			t.Errorf("tx.PopulateByField(%q): got %v, want found %t", c.name, err, want)
+		}
+	}
+
+	iter, err := tx.Query(dbtest.RecordKind).Select(data.AttrMap{"name": "written"}).Execute()
+	if err != nil {
+		t.Fatalf("tx.Query error: %v", err)
+	}
+
+	r := new(dbtest.Record)
+	if !iter.Next(r) || r.Id != rewritten.Id {
+		t.Errorf("tx.Query: got %+v, want the record written", r)
+	}
+	iter.Close()
+
+	// and the DB none of those the transaction wrote
+	if err := db.PopulateByField("name", "written", new(dbtest.Record)); !errors.Is(err, data.ErrNotFound) {
+		t.Errorf("db.PopulateByField: got %v, want data.ErrNotFound", err)
+	}
+
+	if err := db.PopulateByID(&dbtest.Record{Id: deleted.Id}); err != nil {
+		t.Errorf("db.PopulateByID error: %v", err)
+	}
+}
+
+func TestTxDuplicate(t *testing.T) {
+	s := data.NewSchema()
+	s.Register(dbtest.UniqueModel(""))
+
+	for _, typ := range []mem.IndexType{mem.HashIndex, mem.OrderedIndex} {
+		db := mem.WithSchema(s).(*mem.MemDB)
+
+		// the constraint holds whichever index the field has
+		if err := db.EnsureIndex(dbtest.UniqueRecordKind, "key", typ); err != nil {
+			t.Fatalf("db.EnsureIndex error: %v", err)
+		}
+
+		var txs []data.Tx
+		for _, name := range []string{"first", "second"} {
+			tx, err := db.Begin()
+			if err != nil {
+				t.Fatalf("db.Begin error: %v", err)
+			}
+
+			r := &dbtest.UniqueRecord{Key: "same", Name: name}
+			r.SetID(tx.NewID())
+			if err := tx.Save(r); err != nil {
+				t.Fatalf("tx.Save error: %v", err)
+			}
+
+			txs = append(txs, tx)
+		}
+
+		if err := txs[0].Commit(); err != nil {
+			t.Fatalf("tx.Commit error: %v", err)
+		}
+
+		err := txs[1].Commit()
+		if !errors.Is(err, data.ErrDuplicateKey) {
+			t.Fatalf("tx.Commit: got %v, want data.ErrDuplicateKey", err)
+		}
+
+		var e *data.Error
+		if !errors.As(err, &e) || e.Field != "key" || e.ID != "2" {
+			t.Errorf("tx.Commit: got %v, want duplicate key of field key of 2", err)
+		}
+
+		// nor may a transaction duplicate its own records
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatalf("db.Begin error: %v", err)
+		}
+
+		if err := tx.Save(&dbtest.UniqueRecord{Id: "3", Key: "same"}); !errors.Is(err, data.ErrDuplicateKey) {
+			t.Errorf("tx.Save: got %v, want data.ErrDuplicateKey", err)
+		}
+
+		if err := tx.Save(&dbtest.UniqueRecord{Id: "3", Key: "other"}); err != nil {
+			t.Fatalf("tx.Save error: %v", err)
+		}
+
+		if err := tx.Commit(); err != nil {
+			t.Fatalf("tx.Commit error: %v", err)
+		}
+
+		// but it may swap the keys of records
+		tx, err = db.Begin()
+		if err != nil {
+			t.Fatalf("db.Begin error: %v", err)
+		}
+
+		for _, r := range []*dbtest.UniqueRecord{{Id: "1", Key: "swapping"}, {Id: "3", Key: "same"}, {Id: "1", Key: "other"}} {
+			if err := tx.Save(r); err != nil {
+				t.Fatalf("tx.Save error: %v", err)
+			}
+		}
+
+		if err := tx.Commit(); err != nil {
+			t.Errorf("tx.Commit error: %v", err)
+		}
+	}
+}
+
+// benchmarks {{{
+
+func selectFields(db data.DB) data.Query {
+	return db.Query(dbtest.RecordKind).Select(data.AttrMap{"id": "50001", "name": "050000", "count": 0})
+}
+
+func BenchmarkSelectFields(b *testing.B) { benchmarkQuery(b, false, selectFields, 1) }
+
+func all(db data.DB) data.Query {
+	return db.Query(dbtest.RecordKind)
+}
+
+func BenchmarkIterate(b *testing.B) { benchmarkQuery(b, false, all, benchmarkRecords) }
+
+func BenchmarkPopulateByID(b *testing.B) {
+	db := benchmarkDB(b, false)
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		if err := db.PopulateByID(&dbtest.Record{Id: "50000"}); err != nil {
+			b.Fatalf("db.PopulateByID error: %v", err)
+		}
+	}
+}
+
+func BenchmarkSave(b *testing.B) {
+	db := benchmarkDB(b, false)
+	b.ReportAllocs()
+
+	r := &dbtest.Record{Name: "saved"}
+	r.SetID(db.NewID())
+	for i := 0; i < b.N; i++ {
+		r.Count = i
+		if err := db.Save(r); err != nil {
+			b.Fatalf("db.Save error: %v", err)
+		}
+	}
+
+	b.StopTimer()
+	if err := db.Delete(r); err != nil {
+		b.Fatalf("db.Delete error: %v", err)
+	}
+}
+
+// }}}
+
+func TestImportDuplicate(t *testing.T) {
+	s := data.NewSchema()
+	s.Register(dbtest.UniqueModel(""))
+
+	line := func(id, key string) string {
+		return fmt.Sprintf(`{"kind":"dbtest_unique_record","id":"%s","record":{"id":"%s","key":"%s"}}`+"\n", id, id, key)
+	}
+
+	cases := []struct {
+		name     string
+		snapshot string
+		want     error
+	}{
+		{"duplicate of another imported", line("2", "x") + line("3", "x"), data.ErrDuplicateKey},
+		{"duplicate of existing", line("2", "one"), data.ErrDuplicateKey},
+		{"key of replaced", line("1", "moved") + line("2", "one"), nil},
+		{"repeated id", line("2", "x") + line("2", "x"), nil},
+	}
+
+	for _, c := range cases {
+		db := mem.WithSchema(s).(*mem.MemDB)
+
+		if err := db.Save(&dbtest.UniqueRecord{Id: "1", Key: "one"}); err != nil {
+			t.Fatalf("db.Save error: %v", err)
+		}
+
+		err := db.Import(strings.NewReader(c.snapshot), nil)
+		if c.want == nil {
+			if err != nil {
+				t.Errorf("%s: db.Import error: %v", c.name, err)
+			}
+			continue
+		}
+
+		if !errors.Is(err, c.want) {
+			t.Errorf("%s: db.Import: got %v, want %v", c.name, err, c.want)
+		}
+
+		// none of the records are imported
+		if err := db.PopulateByID(&dbtest.UniqueRecord{Id: "2"}); !errors.Is(err, data.ErrNotFound) {
+			t.Errorf("%s: db.PopulateByID: got %v, want %v", c.name, err, data.ErrNotFound)
+		}
+	}
+}
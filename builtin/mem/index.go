@@ -0,0 +1,527 @@
+package mem
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/elos/data"
+)
+
+// IndexType is the structure of an index
+type IndexType int
+
+const (
+	// HashIndex indexes a field for equality. It serves Select,
+	// PopulateByField, and the Eq and In predicates.
+	HashIndex IndexType = iota
+
+	// OrderedIndex indexes a field for order. It serves all a
+	// HashIndex does, save the Select of an array, as well as the
+	// Gt, Gte, Lt and Lte predicates, and the Order of the field.
+	OrderedIndex
+)
+
+// EnsureIndex declares an index of the field of the records of the
+// kind k, building it from those already stored. A field has at most
+// one index, so an index of another type replaces it.
+//
+// The indexes are maintained by Save and Delete, and consulted by
+// PopulateByField and queries, so that they consider only the records
+// which may match, rather than every record of the kind. An index
+// never changes which records match, only how quickly they are found.
+func (db *MemDB) EnsureIndex(k data.Kind, field string, t IndexType) (err error) {
+	defer func() { err = data.WrapError(err, "index", k, "") }()
+
+	if err := db.registered(k); err != nil {
+		return err
+	}
+
+	// a transaction uses the indexes of the DB it began on
+	if db.parent != nil {
+		return nil
+	}
+
+	db.m.Lock()
+	defer db.m.Unlock()
+
+	db.ensureIndex(k, field, t)
+	return nil
+}
+
+// ensureIndex declares the index, if the field has none of the
+// type t, and returns it. The caller must hold the write lock.
+func (db *MemDB) ensureIndex(k data.Kind, field string, t IndexType) *index {
+	if ix, ok := db.indexes[k][field]; ok && ix.typ == t {
+		return ix
+	}
+
+	ix := newIndex(field, t)
+	for id, s := range db.tables[k] {
+		ix.add(id, s.attrs)
+	}
+
+	if db.indexes == nil {
+		db.indexes = make(map[data.Kind]map[string]*index)
+	}
+
+	if db.indexes[k] == nil {
+		db.indexes[k] = make(map[string]*index)
+	}
+
+	db.indexes[k][field] = ix
+	return ix
+}
+
+// put puts the stored record s in its table, and updates
+// the indexes of its kind. The caller must hold the write lock.
+func (db *MemDB) put(s *stored) {
+	k, id := s.record.Kind(), s.record.ID()
+
+	table, ok := db.tables[k]
+	if !ok {
+		table = make(map[data.ID]*stored)
+		db.tables[k] = table
+	}
+
+	table[id] = s
+
+	for _, ix := range db.indexes[k] {
+		ix.remove(id)
+		ix.add(id, s.attrs)
+	}
+}
+
+// remove removes the record of kind k with the id from its table,
+// and from the indexes of its kind. The view of a transaction instead
+// records that it was deleted. The caller must hold the write lock.
+func (db *MemDB) remove(k data.Kind, id data.ID) {
+	if db.parent != nil {
+		if db.tables[k] == nil {
+			db.tables[k] = make(map[data.ID]*stored)
+		}
+		db.tables[k][id] = nil
+		return
+	}
+
+	delete(db.tables[k], id)
+
+	for _, ix := range db.indexes[k] {
+		ix.remove(id)
+	}
+}
+
+// plan retrieves the ids of the records of the kind k which may satisfy
+// the selection and predicates, using the most selective index. It
+// reports false if no index serves them. The view of a transaction
+// uses the indexes of the parent, which don't include the records the
+// transaction wrote, so their ids are added. The caller must hold the
+// lock.
+func (db *MemDB) plan(k data.Kind, wheres map[string]interface{}, predicates []*data.Predicate) ([]data.ID, bool) {
+	if db.parent != nil {
+		db.parent.m.RLock()
+		ids, planned := db.parent.plan(k, wheres, predicates)
+		db.parent.m.RUnlock()
+
+		if planned {
+			for id := range db.tables[k] {
+				ids = append(ids, id)
+			}
+		}
+
+		return ids, planned
+	}
+
+	var (
+		best    []data.ID
+		planned bool
+	)
+
+	consider := func(p *data.Predicate) {
+		ix, ok := db.indexes[k][p.Field]
+		if !ok {
+			return
+		}
+
+		if ids, ok := ix.lookup(p); ok && (!planned || len(ids) < len(best)) {
+			best, planned = ids, true
+		}
+	}
+
+	for field, v := range wheres {
+		consider(&data.Predicate{Op: data.OpEq, Field: field, Value: normalize(v)})
+	}
+
+	for _, p := range predicates {
+		for _, c := range conjuncts(p) {
+			consider(c)
+		}
+	}
+
+	return best, planned
+}
+
+// conjuncts flattens the conjunctions of the predicate p
+func conjuncts(p *data.Predicate) []*data.Predicate {
+	if p.Op != data.OpAnd {
+		return []*data.Predicate{p}
+	}
+
+	var cs []*data.Predicate
+	for _, o := range p.Operands {
+		cs = append(cs, conjuncts(o)...)
+	}
+	return cs
+}
+
+// index {{{
+
+type (
+	// index indexes the values of a field of the records of a kind.
+	// A record is indexed by the value of its field, nil if it is
+	// missing, and, like mongo's multikey indexes, by the elements
+	// of the value, if it is an array.
+	index struct {
+		field string
+		typ   IndexType
+
+		// values are those by which each record is indexed
+		values map[data.ID][]interface{}
+
+		// hash maps the key of a value to the records
+		// with it, if the index is a HashIndex
+		hash map[interface{}]map[data.ID]struct{}
+
+		// ordered are the entries, ordered by value and
+		// then id, if the index is an OrderedIndex
+		ordered []entry
+	}
+
+	entry struct {
+		v  interface{}
+		id data.ID
+	}
+
+	// encoded is the key of a value which can't be a map key
+	encoded string
+)
+
+func newIndex(field string, t IndexType) *index {
+	ix := &index{
+		field:  field,
+		typ:    t,
+		values: make(map[data.ID][]interface{}),
+	}
+
+	if t == HashIndex {
+		ix.hash = make(map[interface{}]map[data.ID]struct{})
+	}
+
+	return ix
+}
+
+// valuesOf determines the values by which the record
+// with the attributes attrs is indexed
+func (ix *index) valuesOf(attrs data.AttrMap) []interface{} {
+	v := attrs[ix.field]
+	if ix.typ == OrderedIndex {
+		return orderedValues(v)
+	}
+
+	vs := []interface{}{v}
+	if es, ok := v.([]interface{}); ok {
+		vs = append(vs, es...)
+	}
+	return vs
+}
+
+// orderedValues determines the values by which the attribute v is
+// ordered, v itself, or its elements if it is an array. Only scalars
+// are ordered, and an attribute with no scalar value is ordered as
+// though it were missing, as nil.
+func orderedValues(v interface{}) []interface{} {
+	vs := []interface{}{v}
+	if es, ok := v.([]interface{}); ok {
+		vs = append(vs, es...)
+	}
+
+	scalars := vs[:0]
+	for _, v := range vs {
+		if orderable(v) {
+			scalars = append(scalars, v)
+		}
+	}
+
+	if len(scalars) == 0 {
+		scalars = append(scalars, nil)
+	}
+
+	return scalars
+}
+
+func (ix *index) add(id data.ID, attrs data.AttrMap) {
+	vs := ix.valuesOf(attrs)
+	ix.values[id] = vs
+
+	for _, v := range vs {
+		switch ix.typ {
+		case HashIndex:
+			key := hashKey(v)
+			ids, ok := ix.hash[key]
+			if !ok {
+				ids = make(map[data.ID]struct{})
+				ix.hash[key] = ids
+			}
+			ids[id] = struct{}{}
+		case OrderedIndex:
+			e := entry{v, id}
+			i := sort.Search(len(ix.ordered), func(i int) bool {
+				return compareEntries(ix.ordered[i], e) >= 0
+			})
+
+			ix.ordered = append(ix.ordered, entry{})
+			copy(ix.ordered[i+1:], ix.ordered[i:])
+			ix.ordered[i] = e
+		}
+	}
+}
+
+func (ix *index) remove(id data.ID) {
+	vs, ok := ix.values[id]
+	if !ok {
+		return
+	}
+	delete(ix.values, id)
+
+	for _, v := range vs {
+		switch ix.typ {
+		case HashIndex:
+			key := hashKey(v)
+			delete(ix.hash[key], id)
+			if len(ix.hash[key]) == 0 {
+				delete(ix.hash, key)
+			}
+		case OrderedIndex:
+			e := entry{v, id}
+			i := sort.Search(len(ix.ordered), func(i int) bool {
+				return compareEntries(ix.ordered[i], e) >= 0
+			})
+
+			if i < len(ix.ordered) && compareEntries(ix.ordered[i], e) == 0 {
+				ix.ordered = append(ix.ordered[:i], ix.ordered[i+1:]...)
+			}
+		}
+	}
+}
+
+// lookup retrieves the ids of the records which may satisfy the
+// predicate p, on the field of the index. It reports false if the
+// index can't serve the predicate. The ids may repeat.
+func (ix *index) lookup(p *data.Predicate) ([]data.ID, bool) {
+	switch p.Op {
+	case data.OpEq:
+		return ix.equal(p.Value)
+	case data.OpIn:
+		ws, ok := p.Value.([]interface{})
+		if !ok {
+			return nil, false
+		}
+
+		var ids []data.ID
+		for _, w := range ws {
+			matches, ok := ix.equal(w)
+			if !ok {
+				return nil, false
+			}
+			ids = append(ids, matches...)
+		}
+		return ids, true
+	case data.OpGt, data.OpGte, data.OpLt, data.OpLte:
+		if ix.typ != OrderedIndex {
+			return nil, false
+		}
+
+		// values are only ordered against those of the same type
+		if !orderable(p.Value) || p.Value == nil {
+			return nil, true
+		}
+
+		lo, hi := ix.bound(p.Value, -1, true), ix.bound(p.Value, 1, true)
+		switch p.Op {
+		case data.OpGt:
+			lo = ix.bound(p.Value, 0, false)
+		case data.OpGte:
+			lo = ix.bound(p.Value, 0, true)
+		case data.OpLt:
+			hi = ix.bound(p.Value, 0, true)
+		case data.OpLte:
+			hi = ix.bound(p.Value, 0, false)
+		}
+
+		return ix.ids(lo, hi), true
+	default:
+		return nil, false
+	}
+}
+
+// equal retrieves the ids of the records with the value v
+func (ix *index) equal(v interface{}) ([]data.ID, bool) {
+	switch ix.typ {
+	case HashIndex:
+		matches := ix.hash[hashKey(v)]
+		ids := make([]data.ID, 0, len(matches))
+		for id := range matches {
+			ids = append(ids, id)
+		}
+		return ids, true
+	case OrderedIndex:
+		if !orderable(v) {
+			return nil, false
+		}
+		return ix.ids(ix.bound(v, 0, true), ix.bound(v, 0, false)), true
+	default:
+		return nil, false
+	}
+}
+
+// bound finds the first of the ordered entries which is not before v.
+// If inclusive is false, it finds the first which is after v. If the
+// block is -1 or 1, it finds the first entry of the block of values of
+// the same type as v, or the first after the block, respectively.
+func (ix *index) bound(v interface{}, block int, inclusive bool) int {
+	return sort.Search(len(ix.ordered), func(i int) bool {
+		var c int
+		switch block {
+		case -1:
+			c = compareInts(rank(ix.ordered[i].v), rank(v))
+		case 1:
+			c = compareInts(rank(ix.ordered[i].v), rank(v)+1)
+		default:
+			c = compareOrdered(ix.ordered[i].v, v)
+		}
+
+		if inclusive {
+			return c >= 0
+		}
+		return c > 0
+	})
+}
+
+// walk calls fn with the ordered entries, in ascending order of value,
+// or descending if descending, until fn returns false. Entries of equal
+// value are walked in ascending order of id, either way.
+func (ix *index) walk(descending bool, fn func(entry) bool) {
+	if !descending {
+		for _, e := range ix.ordered {
+			if !fn(e) {
+				return
+			}
+		}
+		return
+	}
+
+	for hi := len(ix.ordered); hi > 0; {
+		lo := hi - 1
+		for lo > 0 && compareOrdered(ix.ordered[lo-1].v, ix.ordered[hi-1].v) == 0 {
+			lo--
+		}
+
+		for _, e := range ix.ordered[lo:hi] {
+			if !fn(e) {
+				return
+			}
+		}
+
+		hi = lo
+	}
+}
+
+func (ix *index) ids(lo, hi int) []data.ID {
+	if lo >= hi {
+		return nil
+	}
+
+	ids := make([]data.ID, 0, hi-lo)
+	for _, e := range ix.ordered[lo:hi] {
+		ids = append(ids, e.id)
+	}
+	return ids
+}
+
+// hashKey is the key of the hash index of the value v
+func hashKey(v interface{}) interface{} {
+	if orderable(v) {
+		return v
+	}
+
+	// arrays and objects aren't comparable, so they are keyed by
+	// their encoding, which is canonical for normalized values
+	bytes, err := json.Marshal(v)
+	if err != nil {
+		return encoded("")
+	}
+	return encoded(bytes)
+}
+
+// orderable reports whether the normalized value v is a scalar
+func orderable(v interface{}) bool {
+	switch v.(type) {
+	case nil, float64, string, bool:
+		return true
+	default:
+		return false
+	}
+}
+
+// rank orders the types of scalars, as does mongo
+func rank(v interface{}) int {
+	switch v.(type) {
+	case nil:
+		return 0
+	case float64:
+		return 1
+	case string:
+		return 2
+	case bool:
+		return 3
+	default:
+		return 4
+	}
+}
+
+// compareOrdered orders the scalars v and w, by type and then by value
+func compareOrdered(v, w interface{}) int {
+	if c := compareInts(rank(v), rank(w)); c != 0 {
+		return c
+	}
+
+	c, _ := compareValues(v, w)
+	return c
+}
+
+func compareEntries(e, f entry) int {
+	if c := compareOrdered(e.v, f.v); c != 0 {
+		return c
+	}
+
+	switch {
+	case e.id < f.id:
+		return -1
+	case e.id > f.id:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func compareInts(i, j int) int {
+	switch {
+	case i < j:
+		return -1
+	case i > j:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// }}}
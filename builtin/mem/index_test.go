@@ -0,0 +1,239 @@
+package mem_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/elos/data"
+	"github.com/elos/data/builtin/mem"
+	"github.com/elos/data/dbtest"
+)
+
+func TestIndexedConformance(t *testing.T) {
+	dbtest.TestDB(t, func() (data.DB, error) {
+		db := mem.NewDB().(*mem.MemDB)
+
+		for field, typ := range map[string]mem.IndexType{
+			"name":  mem.HashIndex,
+			"tags":  mem.HashIndex,
+			"count": mem.OrderedIndex,
+			"note":  mem.OrderedIndex,
+		} {
+			if err := db.EnsureIndex(dbtest.RecordKind, field, typ); err != nil {
+				return nil, err
+			}
+		}
+
+		return db, nil
+	})
+}
+
+// names executes the query, and returns the names of the results, in order
+func names(t *testing.T, q data.Query) []string {
+	iter, err := q.Execute()
+	if err != nil {
+		t.Fatalf("q.Execute error: %v", err)
+	}
+
+	ns := make([]string, 0)
+	r := new(dbtest.Record)
+	for iter.Next(r) {
+		ns = append(ns, r.Name)
+	}
+
+	return ns
+}
+
+func TestIndexMaintained(t *testing.T) {
+	db := mem.NewDB().(*mem.MemDB)
+
+	a := &dbtest.Record{Id: "1", Name: "a", Count: 1}
+	if err := db.Save(a); err != nil {
+		t.Fatalf("db.Save error: %v", err)
+	}
+
+	// built from the records already stored
+	if err := db.EnsureIndex(dbtest.RecordKind, "count", mem.OrderedIndex); err != nil {
+		t.Fatalf("db.EnsureIndex error: %v", err)
+	}
+
+	b := &dbtest.Record{Id: "2", Name: "b", Count: 2}
+	if err := db.Save(b); err != nil {
+		t.Fatalf("db.Save error: %v", err)
+	}
+
+	if got, want := fmt.Sprint(names(t, db.Query(dbtest.RecordKind).Order("count"))), "[a b]"; got != want {
+		t.Errorf("ordered by count: got %s, want %s", got, want)
+	}
+
+	a.Count = 3
+	if err := db.Save(a); err != nil {
+		t.Fatalf("db.Save error: %v", err)
+	}
+
+	if got, want := fmt.Sprint(names(t, db.Query(dbtest.RecordKind).Order("count"))), "[b a]"; got != want {
+		t.Errorf("ordered by count: got %s, want %s", got, want)
+	}
+
+	if got, want := fmt.Sprint(names(t, db.Query(dbtest.RecordKind).Select(data.AttrMap{"count": 1}))), "[]"; got != want {
+		t.Errorf("count of 1: got %s, want %s", got, want)
+	}
+
+	if err := db.Delete(b); err != nil {
+		t.Fatalf("db.Delete error: %v", err)
+	}
+
+	if got, want := fmt.Sprint(names(t, db.Query(dbtest.RecordKind).Where(data.Gte("count", 2)))), "[a]"; got != want {
+		t.Errorf("count of at least 2: got %s, want %s", got, want)
+	}
+}
+
+func TestIndexTx(t *testing.T) {
+	db := mem.NewDB().(*mem.MemDB)
+
+	if err := db.EnsureIndex(dbtest.RecordKind, "name", mem.HashIndex); err != nil {
+		t.Fatalf("db.EnsureIndex error: %v", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("db.Begin error: %v", err)
+	}
+
+	if err := tx.Save(&dbtest.Record{Id: "1", Name: "committed"}); err != nil {
+		t.Fatalf("tx.Save error: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("tx.Commit error: %v", err)
+	}
+
+	r := new(dbtest.Record)
+	if err := db.PopulateByField("name", "committed", r); err != nil {
+		t.Fatalf("db.PopulateByField error: %v", err)
+	}
+
+	if got, want := r.Id, "1"; got != want {
+		t.Errorf("r.Id: got %q, want %q", got, want)
+	}
+}
+
+// benchmarks {{{
+
+const benchmarkRecords = 100000
+
+var (
+	benchmarkDBs  = make(map[bool]*mem.MemDB)
+	benchmarkOnce = make(map[bool]*sync.Once)
+)
+
+func init() {
+	benchmarkOnce[false] = new(sync.Once)
+	benchmarkOnce[true] = new(sync.Once)
+}
+
+// benchmarkDB constructs, once, a DB of benchmarkRecords records,
+// whose names are unique, and whose counts repeat every 100
+func benchmarkDB(b *testing.B, indexed bool) *mem.MemDB {
+	benchmarkOnce[indexed].Do(func() {
+		db := mem.NewDB().(*mem.MemDB)
+
+		if indexed {
+			db.EnsureIndex(dbtest.RecordKind, "name", mem.HashIndex)
+			db.EnsureIndex(dbtest.RecordKind, "count", mem.OrderedIndex)
+		}
+
+		for i := 0; i < benchmarkRecords; i++ {
+			r := &dbtest.Record{Name: fmt.Sprintf("%06d", i), Count: i % 100}
+			r.SetID(db.NewID())
+			if err := db.Save(r); err != nil {
+				b.Fatalf("db.Save error: %v", err)
+			}
+		}
+
+		benchmarkDBs[indexed] = db
+	})
+
+	b.ResetTimer()
+	return benchmarkDBs[indexed]
+}
+
+func benchmarkQuery(b *testing.B, indexed bool, query func(data.DB) data.Query, want int) {
+	db := benchmarkDB(b, indexed)
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		iter, err := query(db).Execute()
+		if err != nil {
+			b.Fatalf("q.Execute error: %v", err)
+		}
+
+		n := 0
+		r := new(dbtest.Record)
+		for iter.Next(r) {
+			n++
+		}
+
+		if n != want {
+			b.Fatalf("results: got %d, want %d", n, want)
+		}
+	}
+}
+
+func selectName(db data.DB) data.Query {
+	return db.Query(dbtest.RecordKind).Select(data.AttrMap{"name": "050000"})
+}
+
+func BenchmarkSelect(b *testing.B)        { benchmarkQuery(b, false, selectName, 1) }
+func BenchmarkSelectIndexed(b *testing.B) { benchmarkQuery(b, true, selectName, 1) }
+
+func countRange(db data.DB) data.Query {
+	return db.Query(dbtest.RecordKind).Where(data.And(data.Gte("count", 10), data.Lt("count", 12)))
+}
+
+func BenchmarkRange(b *testing.B)        { benchmarkQuery(b, false, countRange, 2000) }
+func BenchmarkRangeIndexed(b *testing.B) { benchmarkQuery(b, true, countRange, 2000) }
+
+func orderCount(db data.DB) data.Query {
+	return db.Query(dbtest.RecordKind).Order("count").Limit(10)
+}
+
+func BenchmarkOrder(b *testing.B)        { benchmarkQuery(b, false, orderCount, 10) }
+func BenchmarkOrderIndexed(b *testing.B) { benchmarkQuery(b, true, orderCount, 10) }
+
+func benchmarkPopulate(b *testing.B, indexed bool) {
+	db := benchmarkDB(b, indexed)
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		if err := db.PopulateByField("name", "050000", new(dbtest.Record)); err != nil {
+			b.Fatalf("db.PopulateByField error: %v", err)
+		}
+	}
+}
+
+func BenchmarkPopulateByField(b *testing.B)        { benchmarkPopulate(b, false) }
+func BenchmarkPopulateByFieldIndexed(b *testing.B) { benchmarkPopulate(b, true) }
+
+func BenchmarkSaveIndexed(b *testing.B) {
+	db := benchmarkDB(b, true)
+	b.ReportAllocs()
+
+	r := &dbtest.Record{Name: "saved"}
+	r.SetID(db.NewID())
+	for i := 0; i < b.N; i++ {
+		r.Count = i % 100
+		if err := db.Save(r); err != nil {
+			b.Fatalf("db.Save error: %v", err)
+		}
+	}
+
+	// leave the records as the other benchmarks expect
+	b.StopTimer()
+	if err := db.Delete(r); err != nil {
+		b.Fatalf("db.Delete error: %v", err)
+	}
+}
+
+// }}}
@@ -0,0 +1,76 @@
+package mem
+
+import (
+	"sync"
+
+	"github.com/elos/data"
+)
+
+// DefaultJournalSize is the number of changes the journal of a MemDB retains
+var DefaultJournalSize = 1024
+
+// Journal is a data.Journal which retains the most recent changes in
+// a ring. It is not durable, but lets a subscriber which reconnects
+// catch up on the changes it missed, provided it wasn't away too long.
+//
+// Journal is safe for concurrent use.
+type Journal struct {
+	m    sync.Mutex
+	ring []*data.Change
+	seq  uint64
+}
+
+// NewJournal constructs a journal which retains the last size changes
+func NewJournal(size int) *Journal {
+	if size < 1 {
+		size = 1
+	}
+
+	return &Journal{
+		ring: make([]*data.Change, size),
+	}
+}
+
+// data.Journal implementation
+func (j *Journal) Append(c *data.Change) error {
+	j.m.Lock()
+	defer j.m.Unlock()
+
+	j.seq++
+	c.Seq = j.seq
+	j.ring[j.index(j.seq)] = c
+	return nil
+}
+
+// data.Journal implementation. A seq the journal has yet to assign
+// was assigned by another, say that of the DB before it restarted,
+// so the changes since are unknown, and Since returns
+// data.ErrJournalTruncated.
+func (j *Journal) Since(seq uint64) ([]*data.Change, error) {
+	j.m.Lock()
+	defer j.m.Unlock()
+
+	if seq > j.seq {
+		return nil, data.ErrJournalTruncated
+	}
+
+	if seq == j.seq {
+		return nil, nil
+	}
+
+	size := uint64(len(j.ring))
+	if j.seq > size && seq < j.seq-size {
+		return nil, data.ErrJournalTruncated
+	}
+
+	changes := make([]*data.Change, 0, j.seq-seq)
+	for s := seq + 1; s <= j.seq; s++ {
+		changes = append(changes, j.ring[j.index(s)])
+	}
+
+	return changes, nil
+}
+
+func (j *Journal) index(seq uint64) int {
+	return int((seq - 1) % uint64(len(j.ring)))
+}
@@ -0,0 +1,185 @@
+package mem
+
+import (
+	"encoding/json"
+	"reflect"
+
+	"github.com/elos/data"
+)
+
+// normalize converts v to the form it would take were it decoded
+// from json, so that it may be compared to the attributes of a
+// stored record, which are transferred through json. Notably all
+// numbers become float64s, and times become strings.
+func normalize(v interface{}) interface{} {
+	bytes, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+
+	var n interface{}
+	if err := json.Unmarshal(bytes, &n); err != nil {
+		return v
+	}
+
+	return n
+}
+
+// normalizePredicate copies the predicate tree p, normalizing
+// the value of every comparison.
+func normalizePredicate(p *data.Predicate) *data.Predicate {
+	n := &data.Predicate{
+		Op:    p.Op,
+		Field: p.Field,
+		Value: normalize(p.Value),
+	}
+
+	for _, o := range p.Operands {
+		n.Operands = append(n.Operands, normalizePredicate(o))
+	}
+
+	return n
+}
+
+// compareValues orders two normalized values, returning false
+// if they are not of a comparable type. Like mongo, values are
+// only ordered against values of the same type.
+func compareValues(v, w interface{}) (int, bool) {
+	switch v := v.(type) {
+	case float64:
+		w, ok := w.(float64)
+		switch {
+		case !ok:
+			return 0, false
+		case v < w:
+			return -1, true
+		case v > w:
+			return 1, true
+		default:
+			return 0, true
+		}
+	case string:
+		w, ok := w.(string)
+		switch {
+		case !ok:
+			return 0, false
+		case v < w:
+			return -1, true
+		case v > w:
+			return 1, true
+		default:
+			return 0, true
+		}
+	case bool:
+		w, ok := w.(bool)
+		switch {
+		case !ok:
+			return 0, false
+		case v == w:
+			return 0, true
+		case w:
+			return -1, true
+		default:
+			return 1, true
+		}
+	default:
+		return 0, false
+	}
+}
+
+// anyOf reports whether the attribute v, or any element of v if it
+// is an array, satisfies fn. This mimics mongo's matching of arrays.
+func anyOf(v interface{}, fn func(interface{}) bool) bool {
+	if fn(v) {
+		return true
+	}
+
+	if vs, ok := v.([]interface{}); ok {
+		for _, e := range vs {
+			if fn(e) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func satisfies(attrs map[string]interface{}, p *data.Predicate) bool {
+	v := attrs[p.Field]
+
+	ordered := func(accept func(int) bool) bool {
+		return anyOf(v, func(e interface{}) bool {
+			c, ok := compareValues(e, p.Value)
+			return ok && accept(c)
+		})
+	}
+
+	in := func() bool {
+		ws, _ := p.Value.([]interface{})
+		return anyOf(v, func(e interface{}) bool {
+			for _, w := range ws {
+				if reflect.DeepEqual(e, w) {
+					return true
+				}
+			}
+			return false
+		})
+	}
+
+	switch p.Op {
+	case data.OpEq:
+		return anyOf(v, func(e interface{}) bool { return reflect.DeepEqual(e, p.Value) })
+	case data.OpNe:
+		return !anyOf(v, func(e interface{}) bool { return reflect.DeepEqual(e, p.Value) })
+	case data.OpGt:
+		return ordered(func(c int) bool { return c > 0 })
+	case data.OpGte:
+		return ordered(func(c int) bool { return c >= 0 })
+	case data.OpLt:
+		return ordered(func(c int) bool { return c < 0 })
+	case data.OpLte:
+		return ordered(func(c int) bool { return c <= 0 })
+	case data.OpIn:
+		return in()
+	case data.OpNin:
+		return !in()
+	case data.OpExists:
+		_, ok := attrs[p.Field]
+		return ok == (p.Value == true)
+	case data.OpAnd:
+		for _, o := range p.Operands {
+			if !satisfies(attrs, o) {
+				return false
+			}
+		}
+		return true
+	case data.OpOr:
+		for _, o := range p.Operands {
+			if satisfies(attrs, o) {
+				return true
+			}
+		}
+		return false
+	case data.OpNot:
+		return len(p.Operands) == 1 && !satisfies(attrs, p.Operands[0])
+	default:
+		return false
+	}
+}
+
+func where(in <-chan *stored, p *data.Predicate) <-chan *stored {
+	out := make(chan *stored)
+
+	go func() {
+		for s := range in {
+			if satisfies(s.attrs, p) {
+				out <- s
+			}
+		}
+
+		close(out)
+	}()
+
+	return out
+}
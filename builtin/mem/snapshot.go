@@ -0,0 +1,179 @@
+package mem
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+
+	"github.com/elos/data"
+)
+
+// A snapshot is a JSON Lines document, with a line for each record:
+//
+//	{"kind":"user","id":"1","record":{"id":"1","name":"Nick"}}
+//
+// Records are written in order of kind, and then id, so that the
+// snapshots of equal DBs are identical, and may be golden files.
+
+// snapshotEntry is a line of a snapshot
+type snapshotEntry struct {
+	Kind   data.Kind       `json:"kind"`
+	ID     data.ID         `json:"id"`
+	Record json.RawMessage `json:"record"`
+}
+
+// Export writes a snapshot of every record of the DB to w
+func (db *MemDB) Export(w io.Writer) (err error) {
+	defer func() { err = data.WrapError(err, "export", "", "") }()
+
+	db.m.RLock()
+	defer db.m.RUnlock()
+
+	return db.export(w)
+}
+
+// export writes a snapshot to w. The caller must hold the lock.
+func (db *MemDB) export(w io.Writer) error {
+	b := bufio.NewWriter(w)
+	enc := json.NewEncoder(b)
+
+	for _, k := range db.kinds() {
+		table := db.records(k)
+
+		ids := make([]string, 0, len(table))
+		for id := range table {
+			ids = append(ids, string(id))
+		}
+		sort.Strings(ids)
+
+		for _, id := range ids {
+			e := snapshotEntry{
+				Kind:   k,
+				ID:     data.ID(id),
+				Record: table[data.ID(id)].raw,
+			}
+
+			if err := enc.Encode(e); err != nil {
+				return err
+			}
+		}
+	}
+
+	return b.Flush()
+}
+
+// Import reads a snapshot from r into the DB, replacing the records
+// with the same kinds and ids. The records are constructed by the
+// schema s, or the DB's own if s is nil, so every kind of the snapshot
+// must be registered with a constructor.
+//
+// Import applies either every record of the snapshot, or none, should
+// any be invalid, or violate a unique constraint of the schema, with
+// data.ErrDuplicateKey. It doesn't notify subscribers, as it is intended
+// to restore a DB before it is used, but a durable DB logs the records.
+func (db *MemDB) Import(r io.Reader, s *data.Schema) (err error) {
+	defer func() { err = data.WrapError(err, "import", "", "") }()
+
+	if s == nil {
+		s = db.schema
+	}
+
+	if s == nil {
+		return errors.New("data/builtin/mem: importing a snapshot requires a schema")
+	}
+
+	records, err := db.decodeSnapshot(r, s)
+	if err != nil {
+		return err
+	}
+
+	db.m.Lock()
+	defer db.m.Unlock()
+
+	if err := db.duplicates(records); err != nil {
+		return err
+	}
+
+	writes := make([]walWrite, len(records))
+	for i, s := range records {
+		writes[i] = saveWrite(s)
+	}
+
+	if err := db.log(writes...); err != nil {
+		return err
+	}
+
+	db.restore(records)
+	db.logged()
+	return nil
+}
+
+// decodeSnapshot reads the records of the snapshot from r,
+// constructing them by the schema s
+func (db *MemDB) decodeSnapshot(r io.Reader, s *data.Schema) ([]*stored, error) {
+	var records []*stored
+
+	dec := json.NewDecoder(r)
+	for line := 1; ; line++ {
+		var e snapshotEntry
+		if err := dec.Decode(&e); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("data/builtin/mem: snapshot record %d: %v", line, err)
+		}
+
+		stored, err := db.decode(s, e.Kind, e.ID, e.Record)
+		if err != nil {
+			return nil, data.WrapError(err, "", e.Kind, e.ID)
+		}
+
+		records = append(records, stored)
+	}
+
+	return records, nil
+}
+
+// decode constructs, by the schema s, the stored record of
+// the kind k with the id, from its encoding raw
+func (db *MemDB) decode(s *data.Schema, k data.Kind, id data.ID, raw json.RawMessage) (*stored, error) {
+	if id == "" {
+		return nil, data.ErrInvalidID
+	}
+
+	if err := db.registered(k); err != nil {
+		return nil, err
+	}
+
+	r, err := s.New(k)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(raw, r); err != nil {
+		return nil, err
+	}
+	r.SetID(id)
+
+	return store(r)
+}
+
+// restore puts the records, without notifying subscribers.
+// The caller must hold the write lock.
+func (db *MemDB) restore(records []*stored) {
+	for _, s := range records {
+		db.put(s)
+		db.advanceID(s.record.ID())
+	}
+}
+
+// advanceID advances the current id past the id, if it is numeric, so
+// that new ids don't collide with it. The caller must hold the write lock.
+func (db *MemDB) advanceID(id data.ID) {
+	if n, err := strconv.Atoi(id.String()); err == nil && n > db.currentID {
+		db.currentID = n
+	}
+}
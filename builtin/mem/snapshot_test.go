@@ -0,0 +1,119 @@
+package mem_test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/elos/data"
+	"github.com/elos/data/builtin/mem"
+	"github.com/elos/data/dbtest"
+)
+
+const snapshot = `{"kind":"dbtest_record","id":"1","record":{"id":"1","name":"first","count":1}}
+{"kind":"dbtest_record","id":"2","record":{"id":"2","name":"second","count":2,"tags":["a","b"]}}
+{"kind":"dbtest_versioned_record","id":"3","record":{"id":"3","name":"versioned","version":4}}
+`
+
+func snapshotSchema() *data.Schema {
+	s := data.NewSchema()
+	s.Register(dbtest.Model(""))
+	s.Register(dbtest.VersionedModel(""))
+	return s
+}
+
+func TestExport(t *testing.T) {
+	db := mem.WithData(map[data.Kind][]data.Record{
+		dbtest.VersionedRecordKind: {
+			&dbtest.VersionedRecord{Id: "3", Name: "versioned", Ver: 4},
+		},
+		dbtest.RecordKind: {
+			&dbtest.Record{Id: "2", Name: "second", Count: 2, Tags: []string{"a", "b"}},
+			&dbtest.Record{Id: "1", Name: "first", Count: 1},
+		},
+	}).(*mem.MemDB)
+
+	b := new(bytes.Buffer)
+	if err := db.Export(b); err != nil {
+		t.Fatalf("db.Export error: %v", err)
+	}
+
+	if got, want := b.String(), snapshot; got != want {
+		t.Errorf("db.Export: got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestImport(t *testing.T) {
+	db := mem.NewDB().(*mem.MemDB)
+
+	if err := db.Import(strings.NewReader(snapshot), snapshotSchema()); err != nil {
+		t.Fatalf("db.Import error: %v", err)
+	}
+
+	r := &dbtest.Record{Id: "2"}
+	if err := db.PopulateByID(r); err != nil {
+		t.Fatalf("db.PopulateByID error: %v", err)
+	}
+
+	if got, want := r.Name, "second"; got != want {
+		t.Errorf("r.Name: got %q, want %q", got, want)
+	}
+
+	if got, want := len(r.Tags), 2; got != want {
+		t.Errorf("len(r.Tags): got %d, want %d", got, want)
+	}
+
+	v := &dbtest.VersionedRecord{Id: "3"}
+	if err := db.PopulateByID(v); err != nil {
+		t.Fatalf("db.PopulateByID error: %v", err)
+	}
+
+	if got, want := v.Ver, 4; got != want {
+		t.Errorf("v.Ver: got %d, want %d", got, want)
+	}
+
+	if got, want := db.NewID(), data.ID("4"); got != want {
+		t.Errorf("db.NewID: got %q, want %q", got, want)
+	}
+
+	// and back again
+	b := new(bytes.Buffer)
+	if err := db.Export(b); err != nil {
+		t.Fatalf("db.Export error: %v", err)
+	}
+
+	if got, want := b.String(), snapshot; got != want {
+		t.Errorf("db.Export: got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestImportInvalid(t *testing.T) {
+	cases := []struct {
+		name     string
+		snapshot string
+		want     error
+	}{
+		{"unregistered kind", `{"kind":"unknown","id":"1","record":{}}`, data.ErrUnregisteredKind},
+		{"missing id", `{"kind":"dbtest_record","record":{}}`, data.ErrInvalidID},
+		{"malformed", `{"kind":"dbtest_record","id":"1","record":`, nil},
+	}
+
+	for _, c := range cases {
+		db := mem.NewDB().(*mem.MemDB)
+
+		// the valid records which precede the invalid are not imported
+		err := db.Import(strings.NewReader(snapshot+c.snapshot), snapshotSchema())
+		if err == nil {
+			t.Fatalf("%s: db.Import: got no error", c.name)
+		}
+
+		if c.want != nil && !errors.Is(err, c.want) {
+			t.Errorf("%s: db.Import: got %v, want %v", c.name, err, c.want)
+		}
+
+		if err := db.PopulateByID(&dbtest.Record{Id: "1"}); !errors.Is(err, data.ErrNotFound) {
+			t.Errorf("%s: db.PopulateByID: got %v, want %v", c.name, err, data.ErrNotFound)
+		}
+	}
+}
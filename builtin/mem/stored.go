@@ -0,0 +1,145 @@
+package mem
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/elos/data"
+)
+
+// stored is a record as the DB stores it. The record is encoded, and
+// decoded into its attributes, once, when it is saved, so that those
+// who filter or order records by their attributes, or populate records
+// from them, need not encode the record again.
+//
+// A stored record is never modified, so it may be shared, by the
+// tables of a DB and its transactions, and by query snapshots.
+type stored struct {
+	// record is a copy of the record, of its concrete type
+	record data.Record
+
+	// raw is the json encoding of the record
+	raw []byte
+
+	// attrs are the decoded attributes of the record,
+	// as they would be transferred through json
+	attrs data.AttrMap
+}
+
+// store makes the stored copy of the record r, which must be a
+// pointer, as is conventional for a data.Record
+func store(r data.Record) (*stored, error) {
+	c, err := newOf(r)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &stored{record: c}
+
+	if s.raw, err = json.Marshal(r); err != nil {
+		return nil, err
+	}
+
+	if err := s.populate(c); err != nil {
+		return nil, err
+	}
+
+	// the id may not be serialized, but it is how the record is stored
+	c.SetID(r.ID())
+
+	if err := json.Unmarshal(s.raw, &s.attrs); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// decode makes a fresh copy of the stored record
+func (s *stored) decode() (data.Record, error) {
+	c, err := newOf(s.record)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.populate(c); err != nil {
+		return nil, err
+	}
+
+	c.SetID(s.record.ID())
+	return c, nil
+}
+
+// newOf allocates a record of the concrete type of r
+func newOf(r data.Record) (data.Record, error) {
+	t := reflect.TypeOf(r)
+	if t == nil || t.Kind() != reflect.Ptr {
+		return nil, fmt.Errorf("data/builtin/mem: can not copy record of type %T, must be a pointer", r)
+	}
+
+	c, ok := reflect.New(t.Elem()).Interface().(data.Record)
+	if !ok {
+		return nil, fmt.Errorf("data/builtin/mem: can not copy record of type %T", r)
+	}
+
+	return c, nil
+}
+
+// populate transfers the attributes, and the id, of the stored record
+// to r, as the id may not be serialized
+func (s *stored) populate(r data.Record) error {
+	if err := json.Unmarshal(s.raw, r); err != nil {
+		return err
+	}
+
+	r.SetID(s.record.ID())
+	return nil
+}
+
+// project transfers the attributes of the stored record which the
+// projection fetches to r, which is first zeroed, so that none of
+// its previous attributes remain
+func (s *stored) project(r data.Record, p data.Projection) error {
+	if v := reflect.ValueOf(r); v.Kind() == reflect.Ptr && !v.IsNil() {
+		v.Elem().Set(reflect.Zero(v.Elem().Type()))
+	}
+
+	attrs := make(data.AttrMap, len(s.attrs))
+	for field, v := range s.attrs {
+		if p.Fetches(field) {
+			attrs[field] = v
+		}
+	}
+
+	raw, err := json.Marshal(attrs)
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(raw, r); err != nil {
+		return err
+	}
+
+	r.SetID(s.record.ID())
+	return nil
+}
+
+// copyValue makes a deep copy of the decoded attribute v
+func copyValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case []interface{}:
+		c := make([]interface{}, len(v))
+		for i, e := range v {
+			c[i] = copyValue(e)
+		}
+		return c
+	case map[string]interface{}:
+		c := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			c[k] = copyValue(e)
+		}
+		return c
+	default:
+		return v
+	}
+}
@@ -0,0 +1,305 @@
+package mem
+
+import (
+	"sync"
+
+	"github.com/elos/data"
+)
+
+// Begin starts a transaction. The transaction's view overlays the
+// tables of the DB with the records the transaction writes, so it
+// doesn't expose its writes until it commits, while it sees those the
+// DB commits meanwhile. The records it doesn't write are found by the
+// indexes of the DB.
+//
+// Transactions are not checked for conflicts, when two transactions
+// write the same record the last to commit wins, save for Versioned
+// records, see Commit.
+func (db *MemDB) Begin() (data.Tx, error) {
+	tx := new(memTx)
+	tx.MemDB = &MemDB{
+		ChangeHub: db.ChangeHub,
+		tables:    make(map[data.Kind]map[data.ID]*stored),
+		schema:    db.schema,
+		parent:    db,
+		pending:   &tx.pending,
+	}
+
+	return tx, nil
+}
+
+type memTx struct {
+	// MemDB is the transaction's view, whose changes are pending,
+	// and whose parent is the DB on which the transaction began
+	*MemDB
+
+	pending []*data.Change
+
+	// versions are those of the Versioned records saved, before
+	// the saves advanced them, in order to restore them should
+	// the transaction not commit
+	versions []savedVersion
+
+	m    sync.Mutex
+	done bool
+}
+
+// NewID generates IDs from the parent, so that they
+// are unique across transactions
+func (tx *memTx) NewID() data.ID {
+	return tx.parent.NewID()
+}
+
+func (tx *memTx) Save(r data.Record) error {
+	tx.m.Lock()
+	defer tx.m.Unlock()
+
+	if tx.done {
+		return data.WrapError(data.ErrTxDone, "save", r.Kind(), r.ID())
+	}
+
+	v, versioned := r.(data.Versioned)
+	if !versioned {
+		return tx.MemDB.Save(r)
+	}
+
+	version := v.Version()
+	if err := tx.MemDB.Save(r); err != nil {
+		return err
+	}
+
+	tx.versions = append(tx.versions, savedVersion{v, version})
+	return nil
+}
+
+func (tx *memTx) Delete(r data.Record) error {
+	tx.m.Lock()
+	defer tx.m.Unlock()
+
+	if tx.done {
+		return data.WrapError(data.ErrTxDone, "delete", r.Kind(), r.ID())
+	}
+
+	return tx.MemDB.Delete(r)
+}
+
+// Commit applies the pending changes to the parent, and then notifies
+// its subscribers. Whether a save creates or updates is determined
+// against the parent at the time of the commit. If another wrote a
+// Versioned record the transaction saves since the transaction read
+// it, Commit applies none of the changes, and returns data.ErrConflict.
+// Likewise, if the records it saves would violate a unique constraint,
+// it returns data.ErrDuplicateKey. Should Commit fail, the transaction is
+// done, and the Versioned records it saved are restored to the versions
+// they were at before, as they are by Rollback.
+func (tx *memTx) Commit() (err error) {
+	defer func() { err = data.WrapError(err, "commit", "", "") }()
+
+	tx.m.Lock()
+	defer tx.m.Unlock()
+
+	if tx.done {
+		return data.ErrTxDone
+	}
+
+	tx.done = true
+	defer func() {
+		if err != nil {
+			tx.restore()
+		}
+	}()
+
+	// copy the records before applying any, so that
+	// a failure leaves the parent unchanged
+	copies := make([]*stored, len(tx.pending))
+	for i, c := range tx.pending {
+		if c.ChangeKind == data.Delete {
+			continue
+		}
+
+		var err error
+		if copies[i], err = store(c.Record); err != nil {
+			return err
+		}
+	}
+
+	p := tx.parent
+	p.m.Lock()
+
+	notified, err := tx.apply(copies)
+	if err != nil {
+		p.m.Unlock()
+		return err
+	}
+
+	p.unlock(notified...)
+	return nil
+}
+
+// apply applies the pending changes, whose saved records are copied
+// as copies, to the parent, and returns the changes to notify. The
+// caller must hold the parent's write lock.
+func (tx *memTx) apply(copies []*stored) ([]*data.Change, error) {
+	p := tx.parent
+
+	if err := tx.conflicts(); err != nil {
+		return nil, err
+	}
+
+	if err := tx.duplicates(copies); err != nil {
+		return nil, err
+	}
+
+	writes := make([]walWrite, len(tx.pending))
+	for i, c := range tx.pending {
+		if c.ChangeKind == data.Delete {
+			writes[i] = deleteWrite(c.Record.Kind(), c.Record.ID())
+		} else {
+			writes[i] = saveWrite(copies[i])
+		}
+	}
+
+	// the writes of the transaction are logged together
+	if err := p.log(writes...); err != nil {
+		return nil, err
+	}
+
+	notified := make([]*data.Change, 0, len(tx.pending))
+	for i, c := range tx.pending {
+		k, id := c.Record.Kind(), c.Record.ID()
+
+		old, existed := p.tables[k][id]
+
+		switch {
+		case c.ChangeKind == data.Delete && existed:
+			p.remove(k, id)
+			notified = append(notified, c)
+		case c.ChangeKind == data.Delete:
+			// deleted by another since the transaction began
+		case existed:
+			u, err := update(old, copies[i], c.Record)
+			if err != nil {
+				// the records were copied, so this can't fail,
+				// but if it did, the update must still be notified
+				u = data.NewUpdate(c.Record)
+			}
+
+			p.put(copies[i])
+			notified = append(notified, u)
+		default:
+			p.put(copies[i])
+			notified = append(notified, data.NewCreate(c.Record))
+		}
+	}
+
+	p.logged()
+	return notified, nil
+}
+
+// conflicts checks that none of the Versioned records the transaction
+// saves were written by another since the transaction read them. It
+// returns data.ErrConflict if any were. The caller must hold the
+// parent's write lock.
+func (tx *memTx) conflicts() error {
+	type key struct {
+		k  data.Kind
+		id data.ID
+	}
+
+	seen := make(map[key]bool)
+
+	for _, c := range tx.pending {
+		k, id := c.Record.Kind(), c.Record.ID()
+
+		// only the first write of a record was made against
+		// the version the transaction read
+		if seen[key{k, id}] {
+			continue
+		}
+		seen[key{k, id}] = true
+
+		version, ok := data.VersionOf(c.Record)
+		if !ok || c.ChangeKind == data.Delete {
+			continue
+		}
+
+		current := 0
+		if old, ok := tx.parent.tables[k][id]; ok {
+			current, _ = data.VersionOf(old.record)
+		}
+
+		// the save advanced the version the transaction read
+		if current != version-1 {
+			return data.ErrConflict
+		}
+	}
+
+	return nil
+}
+
+// duplicates checks that none of the records the transaction saves
+// would, once it commits, share the value of a unique field with
+// another of the parent. It returns data.ErrDuplicateKey if any would.
+// They don't duplicate one another, as the saves to the transaction's
+// view are checked. The caller must hold the parent's write lock.
+func (tx *memTx) duplicates(copies []*stored) error {
+	// the last write of each record, nil if it is a delete
+	written := make(map[data.Kind]map[data.ID]*stored)
+	for i, c := range tx.pending {
+		k := c.Record.Kind()
+		if written[k] == nil {
+			written[k] = make(map[data.ID]*stored)
+		}
+		written[k][c.Record.ID()] = copies[i]
+	}
+
+	for k, records := range written {
+		// the records written replace those of the parent
+		replaced := make(map[data.ID]bool, len(records))
+		for id := range records {
+			replaced[id] = true
+		}
+
+		for id, s := range records {
+			if s == nil {
+				continue
+			}
+
+			if err := tx.parent.duplicate(s, replaced); err != nil {
+				return data.WrapError(err, "", k, id)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (tx *memTx) Rollback() error {
+	tx.m.Lock()
+	defer tx.m.Unlock()
+
+	if tx.done {
+		return data.WrapError(data.ErrTxDone, "rollback", "", "")
+	}
+
+	tx.done = true
+	tx.pending = nil
+	tx.restore()
+
+	return nil
+}
+
+// savedVersion is the version of a Versioned record before it was saved
+type savedVersion struct {
+	v       data.Versioned
+	version int
+}
+
+// restore sets the Versioned records the transaction saved back to the
+// versions they were at before, the earliest of a record's saves last
+func (tx *memTx) restore() {
+	for i := len(tx.versions) - 1; i >= 0; i-- {
+		tx.versions[i].v.SetVersion(tx.versions[i].version)
+	}
+	tx.versions = nil
+}
@@ -0,0 +1,115 @@
+package mem
+
+import "github.com/elos/data"
+
+// uniques retrieves the unique fields of the kind k, declared by the
+// model of the schema, if the DB has one
+func (db *MemDB) uniques(k data.Kind) []string {
+	if db.schema == nil {
+		return nil
+	}
+
+	m, err := db.schema.Model(k)
+	if err != nil {
+		return nil
+	}
+
+	return m.Unique
+}
+
+// duplicate checks that the stored record s shares the value of none
+// of its kind's unique fields with another record, save those of the
+// replaced ids, and returns a data.Error of data.ErrDuplicateKey if
+// it does. A null value is not constrained.
+//
+// The records are found by the index of the field, which the DB
+// declares, as a HashIndex, should the field have none. The caller
+// must hold the write lock.
+func (db *MemDB) duplicate(s *stored, replaced map[data.ID]bool) error {
+	k, id := s.record.Kind(), s.record.ID()
+
+	for _, field := range db.uniques(k) {
+		v := s.attrs[field]
+		if v == nil {
+			continue
+		}
+
+		// a transaction can't declare the indexes of the DB it began on
+		if _, ok := db.indexes[k][field]; !ok && db.parent == nil {
+			db.ensureIndex(k, field, HashIndex)
+		}
+
+		// an OrderedIndex can't find arrays, so they are searched for
+		candidates, ok := db.plan(k, nil, []*data.Predicate{{Op: data.OpEq, Field: field, Value: v}})
+		if !ok {
+			for other := range db.records(k) {
+				candidates = append(candidates, other)
+			}
+		}
+
+		key := hashKey(v)
+		for _, other := range candidates {
+			if other == id || replaced[other] {
+				continue
+			}
+
+			if o, ok := db.lookup(k, other); ok && hashKey(o.attrs[field]) == key {
+				return &data.Error{Err: data.ErrDuplicateKey, Field: field}
+			}
+		}
+	}
+
+	return nil
+}
+
+// duplicates checks that the records, which replace those of the DB
+// with the same kinds and ids, share the value of no unique field with
+// one another, nor with another record of the DB. Should the records
+// repeat an id, the last is checked. It returns a data.Error of
+// data.ErrDuplicateKey if they do. The caller must hold the write lock.
+func (db *MemDB) duplicates(records []*stored) error {
+	// the last of the records with each id
+	last := make(map[data.Kind]map[data.ID]*stored)
+	for _, s := range records {
+		k := s.record.Kind()
+		if last[k] == nil {
+			last[k] = make(map[data.ID]*stored)
+		}
+		last[k][s.record.ID()] = s
+	}
+
+	for k, byID := range last {
+		replaced := make(map[data.ID]bool, len(byID))
+		for id := range byID {
+			replaced[id] = true
+		}
+
+		// the values of each unique field, as hashKeys, taken
+		seen := make(map[string]map[interface{}]bool)
+
+		for id, s := range byID {
+			if err := db.duplicate(s, replaced); err != nil {
+				return data.WrapError(err, "", k, id)
+			}
+
+			for _, field := range db.uniques(k) {
+				v := s.attrs[field]
+				if v == nil {
+					continue
+				}
+
+				if seen[field] == nil {
+					seen[field] = make(map[interface{}]bool)
+				}
+
+				key := hashKey(v)
+				if seen[field][key] {
+					return data.WrapError(&data.Error{Err: data.ErrDuplicateKey, Field: field}, "", k, id)
+				}
+				seen[field][key] = true
+			}
+		}
+	}
+
+	return nil
+}
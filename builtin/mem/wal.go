@@ -0,0 +1,528 @@
+package mem
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/elos/data"
+)
+
+// Durability is the policy by which a durable MemDB syncs its
+// write-ahead log to stable storage
+type Durability int
+
+const (
+	// SyncAlways syncs the log before every write returns,
+	// so that no write which returns is lost
+	SyncAlways Durability = iota
+
+	// SyncInterval syncs the log every SyncInterval, so that
+	// the writes of at most the last interval may be lost
+	SyncInterval
+
+	// SyncNever leaves the log to be synced by the operating system,
+	// so that writes may be lost if it crashes, but not if the
+	// process does
+	SyncNever
+)
+
+const (
+	// DefaultSyncInterval is the SyncInterval of Opts which declare none
+	DefaultSyncInterval = time.Second
+
+	// DefaultCompactEvery is the CompactEvery of Opts which declare none
+	DefaultCompactEvery = 10000
+
+	// LogFile and SnapshotFile are the files of the directory of a durable MemDB
+	LogFile      = "wal.log"
+	SnapshotFile = "snapshot.jsonl"
+)
+
+// Opts configure a durable MemDB
+type Opts struct {
+	// Dir is the directory of the log and snapshot, it
+	// is created if it doesn't exist
+	Dir string
+
+	// Schema constructs the records when they are replayed, so
+	// every kind must be registered, with a constructor. As for
+	// WithSchema, the DB only stores the registered kinds.
+	Schema *data.Schema
+
+	Durability Durability
+
+	// SyncInterval defaults to DefaultSyncInterval
+	SyncInterval time.Duration
+
+	// CompactEvery is the number of writes after which the log is
+	// compacted into the snapshot. It defaults to DefaultCompactEvery,
+	// and compaction is disabled if it is negative.
+	CompactEvery int
+}
+
+// Open opens the durable MemDB of the directory of the opts, replaying
+// its snapshot and log. Every Save and Delete, and every committed
+// transaction, is appended to the log before it is applied, and the log
+// is compacted into the snapshot every CompactEvery writes.
+//
+// Should the process crash while appending to the log, the incomplete
+// write is discarded when the log is next replayed.
+//
+// The DB must be closed, to release the log.
+func Open(o *Opts) (*MemDB, error) {
+	if o.Schema == nil {
+		return nil, errors.New("data/builtin/mem: a durable db requires a schema")
+	}
+
+	if err := os.MkdirAll(o.Dir, 0755); err != nil {
+		return nil, err
+	}
+
+	db := WithSchema(o.Schema).(*MemDB)
+
+	if err := db.replaySnapshot(filepath.Join(o.Dir, SnapshotFile)); err != nil {
+		return nil, err
+	}
+
+	w, err := openWAL(db, o)
+	if err != nil {
+		return nil, err
+	}
+
+	db.wal = w
+	return db, nil
+}
+
+// Close syncs and closes the log of a durable MemDB. Once it is
+// closed, its writes fail with data.ErrClosed, as they can't be
+// logged, while its records may still be read. It is a no-op for
+// one which isn't durable.
+func (db *MemDB) Close() error {
+	db.m.Lock()
+	defer db.m.Unlock()
+
+	if db.wal == nil || db.closed {
+		return nil
+	}
+
+	db.closed = true
+	return db.wal.close()
+}
+
+// Compact writes the snapshot of a durable MemDB, and then truncates
+// its log. It is a no-op for one which isn't durable.
+func (db *MemDB) Compact() (err error) {
+	defer func() { err = data.WrapError(err, "compact", "", "") }()
+
+	db.m.Lock()
+	defer db.m.Unlock()
+
+	if db.wal == nil {
+		return nil
+	}
+
+	if db.closed {
+		return data.ErrClosed
+	}
+
+	return db.compact()
+}
+
+// compact writes the snapshot, and then truncates the log. Should
+// it crash in between, the log is replayed over the snapshot, which
+// is harmless, as saves and deletes are idempotent. The caller must
+// hold the write lock.
+func (db *MemDB) compact() error {
+	path := filepath.Join(db.wal.dir, SnapshotFile)
+
+	f, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+
+	if err := db.export(f); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+
+	// the rename must be durable before the log is truncated,
+	// lest a crash lose both the snapshot and the log
+	if err := syncDir(db.wal.dir); err != nil {
+		return err
+	}
+
+	return db.wal.truncate()
+}
+
+// syncDir syncs the directory, so that the entries
+// renamed or created in it are durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+
+	if err := d.Sync(); err != nil {
+		d.Close()
+		return err
+	}
+
+	return d.Close()
+}
+
+// replaySnapshot restores the records of the snapshot at the path, if any
+func (db *MemDB) replaySnapshot(path string) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	records, err := db.decodeSnapshot(f, db.schema)
+	if err != nil {
+		return fmt.Errorf("data/builtin/mem: replaying %s: %v", path, err)
+	}
+
+	db.restore(records)
+	return nil
+}
+
+// log appends the writes to the log, if the DB is durable, before
+// they are applied. The caller must hold the write lock.
+func (db *MemDB) log(writes ...walWrite) error {
+	if db.wal == nil {
+		return nil
+	}
+
+	if db.closed {
+		return data.ErrClosed
+	}
+
+	return db.wal.append(writes)
+}
+
+// logged is called once logged writes are applied, to compact
+// the log when it is due. The caller must hold the write lock.
+func (db *MemDB) logged() {
+	if db.wal == nil || !db.wal.due() {
+		return
+	}
+
+	// the writes are durable, so a failure is not theirs
+	if err := db.compact(); err != nil {
+		log.Printf("data/builtin/mem: compacting the log: %v", err)
+	}
+}
+
+// walWrite is a write of the log
+type walWrite struct {
+	// Op is "save" or "delete"
+	Op     string          `json:"op"`
+	Kind   data.Kind       `json:"kind"`
+	ID     data.ID         `json:"id"`
+	Record json.RawMessage `json:"record,omitempty"`
+}
+
+func saveWrite(s *stored) walWrite {
+	return walWrite{Op: "save", Kind: s.record.Kind(), ID: s.record.ID(), Record: s.raw}
+}
+
+func deleteWrite(k data.Kind, id data.ID) walWrite {
+	return walWrite{Op: "delete", Kind: k, ID: id}
+}
+
+// wal {{{
+
+// wal is the write-ahead log of a durable MemDB. Each line of the log
+// is the writes of a Save, Delete or transaction, encoded as json,
+// and prefixed by its checksum, so that the writes of a transaction
+// are replayed together, or not at all.
+type wal struct {
+	dir          string
+	durability   Durability
+	compactEvery int
+
+	m      sync.Mutex
+	f      *os.File
+	size   int64
+	writes int
+	dirty  bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// openWAL replays the log of the directory of the opts into the
+// db, discarding an incomplete write at its end, and opens it for
+// appending
+func openWAL(db *MemDB, o *Opts) (*wal, error) {
+	w := &wal{
+		dir:          o.Dir,
+		durability:   o.Durability,
+		compactEvery: o.CompactEvery,
+	}
+
+	if w.compactEvery == 0 {
+		w.compactEvery = DefaultCompactEvery
+	}
+
+	f, err := os.OpenFile(filepath.Join(o.Dir, LogFile), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	size, writes, err := replay(db, f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("data/builtin/mem: replaying %s: %v", f.Name(), err)
+	}
+
+	// discard the incomplete write, if any
+	if err := f.Truncate(size); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	if _, err := f.Seek(size, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	w.f, w.size, w.writes = f, size, writes
+
+	if w.durability == SyncInterval {
+		interval := o.SyncInterval
+		if interval <= 0 {
+			interval = DefaultSyncInterval
+		}
+
+		w.stop, w.done = make(chan struct{}), make(chan struct{})
+		go w.syncEvery(interval)
+	}
+
+	return w, nil
+}
+
+// replay applies the lines of the log to the db. It returns the size
+// of the complete lines, and their number. Only the last line may be
+// incomplete, or fail its checksum, a torn write; any other is corrupt.
+func replay(db *MemDB, f *os.File) (size int64, writes int, err error) {
+	r := bufio.NewReader(f)
+
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			// an incomplete line, if any, is discarded
+			return size, writes, nil
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+
+		ws, ok := decodeLine(line)
+		if !ok {
+			if _, err := r.Peek(1); err == io.EOF {
+				return size, writes, nil
+			}
+
+			return 0, 0, fmt.Errorf("corrupt write at offset %d", size)
+		}
+
+		if err := db.apply(ws); err != nil {
+			return 0, 0, fmt.Errorf("write at offset %d: %v", size, err)
+		}
+
+		size += int64(len(line))
+		writes++
+	}
+}
+
+// apply applies the writes, replayed from the log
+func (db *MemDB) apply(ws []walWrite) error {
+	records := make([]*stored, len(ws))
+	for i, w := range ws {
+		if w.Op != "save" {
+			continue
+		}
+
+		var err error
+		if records[i], err = db.decode(db.schema, w.Kind, w.ID, w.Record); err != nil {
+			return err
+		}
+	}
+
+	for i, w := range ws {
+		switch w.Op {
+		case "save":
+			db.put(records[i])
+			db.advanceID(w.ID)
+		case "delete":
+			db.remove(w.Kind, w.ID)
+		}
+	}
+
+	return nil
+}
+
+func encodeLine(ws []walWrite) ([]byte, error) {
+	body, err := json.Marshal(ws)
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(fmt.Sprintf("%08x %s\n", crc32.ChecksumIEEE(body), body)), nil
+}
+
+func decodeLine(line []byte) ([]walWrite, bool) {
+	line = bytes.TrimSuffix(line, []byte("\n"))
+	if len(line) < 9 || line[8] != ' ' {
+		return nil, false
+	}
+
+	var sum uint32
+	if _, err := fmt.Sscanf(string(line[:8]), "%08x", &sum); err != nil {
+		return nil, false
+	}
+
+	body := line[9:]
+	if crc32.ChecksumIEEE(body) != sum {
+		return nil, false
+	}
+
+	var ws []walWrite
+	if err := json.Unmarshal(body, &ws); err != nil {
+		return nil, false
+	}
+
+	return ws, true
+}
+
+// append writes the writes to the log, as a line, and syncs it if the
+// durability requires. Should it fail, the log is left as it was.
+func (w *wal) append(ws []walWrite) error {
+	line, err := encodeLine(ws)
+	if err != nil {
+		return err
+	}
+
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	if _, err := w.f.Write(line); err != nil {
+		w.rewind()
+		return err
+	}
+
+	if w.durability == SyncAlways {
+		if err := w.f.Sync(); err != nil {
+			w.rewind()
+			return err
+		}
+	}
+
+	w.size += int64(len(line))
+	w.writes++
+	w.dirty = true
+	return nil
+}
+
+// rewind discards a partial line. The caller must hold the lock.
+func (w *wal) rewind() {
+	w.f.Truncate(w.size)
+	w.f.Seek(w.size, io.SeekStart)
+}
+
+// due reports whether the log is due to be compacted
+func (w *wal) due() bool {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	return w.compactEvery > 0 && w.writes >= w.compactEvery
+}
+
+// truncate empties the log, once it is compacted
+func (w *wal) truncate() error {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	if err := w.f.Truncate(0); err != nil {
+		return err
+	}
+
+	if _, err := w.f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	w.size, w.writes, w.dirty = 0, 0, false
+	return w.f.Sync()
+}
+
+func (w *wal) syncEvery(interval time.Duration) {
+	defer close(w.done)
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-t.C:
+			w.sync()
+		}
+	}
+}
+
+func (w *wal) sync() error {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	if !w.dirty {
+		return nil
+	}
+
+	w.dirty = false
+	return w.f.Sync()
+}
+
+func (w *wal) close() error {
+	if w.stop != nil {
+		close(w.stop)
+		<-w.done
+	}
+
+	if err := w.sync(); err != nil {
+		w.f.Close()
+		return err
+	}
+
+	return w.f.Close()
+}
+
+// }}}
@@ -0,0 +1,314 @@
+package mem_test
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elos/data"
+	"github.com/elos/data/builtin/mem"
+	"github.com/elos/data/dbtest"
+)
+
+// tempDir creates a directory, and returns a function which removes it
+func tempDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "mem_test")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir error: %v", err)
+	}
+
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+func open(t *testing.T, o *mem.Opts) *mem.MemDB {
+	if o.Schema == nil {
+		o.Schema = snapshotSchema()
+	}
+
+	db, err := mem.Open(o)
+	if err != nil {
+		t.Fatalf("mem.Open error: %v", err)
+	}
+
+	return db
+}
+
+// contents exports the records of the db
+func contents(t *testing.T, db *mem.MemDB) string {
+	b := new(bytes.Buffer)
+	if err := db.Export(b); err != nil {
+		t.Fatalf("db.Export error: %v", err)
+	}
+	return b.String()
+}
+
+func TestDurable(t *testing.T) {
+	for _, durability := range []mem.Durability{mem.SyncAlways, mem.SyncInterval, mem.SyncNever} {
+		dir, remove := tempDir(t)
+		defer remove()
+
+		o := &mem.Opts{Dir: dir, Durability: durability, SyncInterval: time.Millisecond}
+		db := open(t, o)
+
+		kept := &dbtest.Record{Name: "kept"}
+		deleted := &dbtest.Record{Name: "deleted"}
+		for _, r := range []*dbtest.Record{kept, deleted} {
+			r.SetID(db.NewID())
+			if err := db.Save(r); err != nil {
+				t.Fatalf("db.Save error: %v", err)
+			}
+		}
+
+		kept.Count = 2
+		if err := db.Save(kept); err != nil {
+			t.Fatalf("db.Save error: %v", err)
+		}
+
+		if err := db.Delete(deleted); err != nil {
+			t.Fatalf("db.Delete error: %v", err)
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatalf("db.Begin error: %v", err)
+		}
+
+		committed := &dbtest.Record{Name: "committed"}
+		committed.SetID(tx.NewID())
+		if err := tx.Save(committed); err != nil {
+			t.Fatalf("tx.Save error: %v", err)
+		}
+
+		if err := tx.Commit(); err != nil {
+			t.Fatalf("tx.Commit error: %v", err)
+		}
+
+		want := contents(t, db)
+
+		if err := db.Close(); err != nil {
+			t.Fatalf("db.Close error: %v", err)
+		}
+
+		db = open(t, o)
+		defer db.Close()
+
+		if got := contents(t, db); got != want {
+			t.Errorf("durability %d: replayed\n%s\nwant\n%s", durability, got, want)
+		}
+
+		if got, want := db.NewID(), data.ID("4"); got != want {
+			t.Errorf("durability %d: db.NewID: got %q, want %q", durability, got, want)
+		}
+	}
+}
+
+func TestDurableTornWrite(t *testing.T) {
+	dir, remove := tempDir(t)
+	defer remove()
+
+	o := &mem.Opts{Dir: dir}
+	db := open(t, o)
+
+	if err := db.Save(&dbtest.Record{Id: "1", Name: "first"}); err != nil {
+		t.Fatalf("db.Save error: %v", err)
+	}
+
+	want := contents(t, db)
+
+	if err := db.Save(&dbtest.Record{Id: "2", Name: "torn"}); err != nil {
+		t.Fatalf("db.Save error: %v", err)
+	}
+
+	db.Close()
+
+	path := filepath.Join(dir, mem.LogFile)
+	log, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ioutil.ReadFile error: %v", err)
+	}
+
+	torn := bytes.IndexByte(log, '\n') + 1
+
+	// a crash may interrupt the last write anywhere, save its end
+	for n := torn; n < len(log); n++ {
+		if err := ioutil.WriteFile(path, log[:n], 0644); err != nil {
+			t.Fatalf("ioutil.WriteFile error: %v", err)
+		}
+
+		db := open(t, o)
+
+		if got := contents(t, db); got != want {
+			t.Errorf("log truncated to %d bytes: replayed\n%s\nwant\n%s", n, got, want)
+		}
+
+		// the torn write is discarded, so that later writes are replayed
+		if err := db.Save(&dbtest.Record{Id: "3", Name: "later"}); err != nil {
+			t.Fatalf("db.Save error: %v", err)
+		}
+		later := contents(t, db)
+		db.Close()
+
+		db = open(t, o)
+		if got := contents(t, db); got != later {
+			t.Errorf("log truncated to %d bytes: replayed\n%s\nwant\n%s", n, got, later)
+		}
+		db.Close()
+	}
+}
+
+func TestDurableCorrupt(t *testing.T) {
+	dir, remove := tempDir(t)
+	defer remove()
+
+	o := &mem.Opts{Dir: dir}
+	db := open(t, o)
+
+	for _, id := range []string{"1", "2"} {
+		if err := db.Save(&dbtest.Record{Id: id, Name: "corrupt"}); err != nil {
+			t.Fatalf("db.Save error: %v", err)
+		}
+	}
+
+	db.Close()
+
+	path := filepath.Join(dir, mem.LogFile)
+	log, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ioutil.ReadFile error: %v", err)
+	}
+
+	// only the last write may be torn, a corrupt one before it is an error
+	corrupt := bytes.Replace(log, []byte(`"corrupt"`), []byte(`"CORRUPT"`), 1)
+	if err := ioutil.WriteFile(path, corrupt, 0644); err != nil {
+		t.Fatalf("ioutil.WriteFile error: %v", err)
+	}
+
+	if _, err := mem.Open(&mem.Opts{Dir: dir, Schema: snapshotSchema()}); err == nil || !strings.Contains(err.Error(), "corrupt") {
+		t.Errorf("mem.Open: got %v, want corrupt write error", err)
+	}
+}
+
+func TestDurableCompact(t *testing.T) {
+	dir, remove := tempDir(t)
+	defer remove()
+
+	o := &mem.Opts{Dir: dir, CompactEvery: 3}
+	db := open(t, o)
+
+	for _, id := range []string{"1", "2", "3", "4"} {
+		if err := db.Save(&dbtest.Record{Id: id, Name: "compacted"}); err != nil {
+			t.Fatalf("db.Save error: %v", err)
+		}
+	}
+
+	log, err := ioutil.ReadFile(filepath.Join(dir, mem.LogFile))
+	if err != nil {
+		t.Fatalf("ioutil.ReadFile error: %v", err)
+	}
+
+	if got, want := bytes.Count(log, []byte("\n")), 1; got != want {
+		t.Errorf("writes logged since compaction: got %d, want %d", got, want)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, mem.SnapshotFile)); err != nil {
+		t.Errorf("os.Stat of snapshot error: %v", err)
+	}
+
+	if err := db.Delete(&dbtest.Record{Id: "2"}); err != nil {
+		t.Fatalf("db.Delete error: %v", err)
+	}
+
+	// crash after the snapshot is written, but before the log is truncated
+	log, err = ioutil.ReadFile(filepath.Join(dir, mem.LogFile))
+	if err != nil {
+		t.Fatalf("ioutil.ReadFile error: %v", err)
+	}
+
+	if err := db.Compact(); err != nil {
+		t.Fatalf("db.Compact error: %v", err)
+	}
+
+	want := contents(t, db)
+	db.Close()
+
+	if err := ioutil.WriteFile(filepath.Join(dir, mem.LogFile), log, 0644); err != nil {
+		t.Fatalf("ioutil.WriteFile error: %v", err)
+	}
+
+	db = open(t, o)
+	defer db.Close()
+
+	if got := contents(t, db); got != want {
+		t.Errorf("replayed\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestDurableClosed(t *testing.T) {
+	dir, remove := tempDir(t)
+	defer remove()
+
+	o := &mem.Opts{Dir: dir}
+	db := open(t, o)
+
+	kept := &dbtest.Record{Name: "kept"}
+	kept.SetID(db.NewID())
+	if err := db.Save(kept); err != nil {
+		t.Fatalf("db.Save error: %v", err)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatalf("db.Close error: %v", err)
+	}
+
+	// the writes can't be logged, so they are rejected
+	lost := &dbtest.Record{Name: "lost"}
+	lost.SetID(db.NewID())
+	if got, want := db.Save(lost), data.ErrClosed; !errors.Is(got, want) {
+		t.Errorf("db.Save after close: got %v, want %v", got, want)
+	}
+
+	if got, want := db.Delete(kept), data.ErrClosed; !errors.Is(got, want) {
+		t.Errorf("db.Delete after close: got %v, want %v", got, want)
+	}
+
+	if got, want := db.Compact(), data.ErrClosed; !errors.Is(got, want) {
+		t.Errorf("db.Compact after close: got %v, want %v", got, want)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Errorf("db.Close again error: %v", err)
+	}
+
+	// while the records may still be read
+	if err := db.PopulateByID(&dbtest.Record{Id: kept.Id}); err != nil {
+		t.Errorf("db.PopulateByID after close error: %v", err)
+	}
+
+	if got, want := db.PopulateByID(&dbtest.Record{Id: lost.Id}), data.ErrNotFound; !errors.Is(got, want) {
+		t.Errorf("db.PopulateByID of rejected record: got %v, want %v", got, want)
+	}
+
+	reopened := open(t, o)
+	defer reopened.Close()
+
+	if got, want := contents(t, reopened), contents(t, db); got != want {
+		t.Errorf("reopened contents: got %q, want %q", got, want)
+	}
+}
+
+func TestDurableUnregistered(t *testing.T) {
+	dir, remove := tempDir(t)
+	defer remove()
+
+	db := open(t, &mem.Opts{Dir: dir})
+	defer db.Close()
+
+	if err := db.Save(&TestRecord{Id: "1"}); !errors.Is(err, data.ErrUnregisteredKind) {
+		t.Errorf("db.Save: got %v, want %v", err, data.ErrUnregisteredKind)
+	}
+}
@@ -0,0 +1,168 @@
+package mongo
+
+import (
+	"errors"
+
+	"github.com/elos/data"
+	"golang.org/x/net/context"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// The aggregates are evaluated by the server, with the aggregation
+// pipeline, over the documents the query matches, disregarding its
+// order, skip and limit.
+
+func (q *Query) Count() (int, error) {
+	return q.CountContext(context.Background())
+}
+
+func (q *Query) CountContext(ctx context.Context) (int, error) {
+	var n int
+	err := q.aggregate(ctx, "count", func(c *mgo.Collection, filter interface{}) (err error) {
+		n, err = c.Find(filter).Count()
+		return err
+	})
+	return n, err
+}
+
+func (q *Query) Exists() (bool, error) {
+	return q.ExistsContext(context.Background())
+}
+
+func (q *Query) ExistsContext(ctx context.Context) (bool, error) {
+	var n int
+	err := q.aggregate(ctx, "count", func(c *mgo.Collection, filter interface{}) (err error) {
+		n, err = c.Find(filter).Limit(1).Count()
+		return err
+	})
+	return n > 0, err
+}
+
+func (q *Query) Sum(field string) (float64, error) {
+	return q.SumContext(context.Background(), field)
+}
+
+func (q *Query) SumContext(ctx context.Context, field string) (float64, error) {
+	sum, err := q.accumulate(ctx, "$sum", field)
+	if errors.Is(err, data.ErrNotFound) {
+		// there were no documents to sum
+		return 0, nil
+	}
+	return sum, err
+}
+
+func (q *Query) Min(field string) (float64, error) {
+	return q.MinContext(context.Background(), field)
+}
+
+func (q *Query) MinContext(ctx context.Context, field string) (float64, error) {
+	return q.accumulate(ctx, "$min", field)
+}
+
+func (q *Query) Max(field string) (float64, error) {
+	return q.MaxContext(context.Background(), field)
+}
+
+func (q *Query) MaxContext(ctx context.Context, field string) (float64, error) {
+	return q.accumulate(ctx, "$max", field)
+}
+
+func (q *Query) Avg(field string) (float64, error) {
+	return q.AvgContext(context.Background(), field)
+}
+
+func (q *Query) AvgContext(ctx context.Context, field string) (float64, error) {
+	return q.accumulate(ctx, "$avg", field)
+}
+
+func (q *Query) GroupBy(field string) ([]data.Group, error) {
+	return q.GroupByContext(context.Background(), field)
+}
+
+func (q *Query) GroupByContext(ctx context.Context, field string) ([]data.Group, error) {
+	var results []struct {
+		Value interface{} `bson:"_id"`
+		Count int         `bson:"count"`
+	}
+
+	err := q.aggregate(ctx, "aggregate", func(c *mgo.Collection, filter interface{}) error {
+		return c.Pipe([]bson.M{
+			{"$match": filter},
+			{"$group": bson.M{"_id": "$" + field, "count": bson.M{"$sum": 1}}},
+		}).All(&results)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make([]data.Group, len(results))
+	for i, r := range results {
+		groups[i] = data.Group{Value: number(r.Value), Count: r.Count}
+	}
+
+	data.SortGroups(groups)
+	return groups, nil
+}
+
+// aggregate performs the operation fn on the collection of the
+// query's kind, with the filter of the query, and the context
+func (q *Query) aggregate(ctx context.Context, op string, fn func(c *mgo.Collection, filter interface{}) error) error {
+	q.m.Lock()
+	filter := q.filter()
+	q.m.Unlock()
+
+	return wrap(q.db.do(ctx, func(s *mgo.Session) error {
+		c, err := q.db.Collection(s, q.kind)
+		if err != nil {
+			return err
+		}
+
+		return fn(c, filter)
+	}), op, q.kind, "")
+}
+
+// accumulate groups every numeric value of the field by the
+// accumulator, returning data.ErrNotFound if there are none
+func (q *Query) accumulate(ctx context.Context, accumulator, field string) (float64, error) {
+	var result struct {
+		Value interface{} `bson:"value"`
+	}
+
+	err := q.aggregate(ctx, "aggregate", func(c *mgo.Collection, filter interface{}) error {
+		err := c.Pipe([]bson.M{
+			{"$match": filter},
+			{"$match": bson.M{field: bson.M{"$type": "number"}}},
+			{"$group": bson.M{"_id": nil, "value": bson.M{accumulator: "$" + field}}},
+		}).One(&result)
+		if err == mgo.ErrNotFound {
+			return data.ErrNotFound
+		}
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	v, ok := number(result.Value).(float64)
+	if !ok {
+		return 0, wrap(data.ErrNotFound, "aggregate", q.kind, "")
+	}
+
+	return v, nil
+}
+
+// number converts the integers bson decodes to float64,
+// as though the value were decoded from json
+func number(v interface{}) interface{} {
+	switch n := v.(type) {
+	case int:
+		return float64(n)
+	case int32:
+		return float64(n)
+	case int64:
+		return float64(n)
+	}
+
+	return v
+}
@@ -1,12 +1,12 @@
 package mongo
 
 import (
-	"fmt"
 	"sync"
 
 	"github.com/elos/data"
 	"golang.org/x/net/context"
 	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
 )
 
 const defaultName = "test"
@@ -16,6 +16,28 @@ type (
 	Opts struct {
 		Addr string
 		Name string
+
+		// Schema determines the collections of kinds,
+		// if nil the DB starts with an empty schema
+		Schema *data.Schema
+
+		// Journal, if not empty, is the capped collection, of
+		// JournalSize bytes, in which the changes are journaled,
+		// so that subscribers may resume with ChangesSince
+		Journal     string
+		JournalSize int
+
+		// Tail, if not nil, configures the tailing of the oplog,
+		// so that the changes made by other processes are
+		// notified to the DB's subscribers
+		Tail *TailOpts
+
+		// Previous, if true, includes the previous version of
+		// the record, and the Diff, in the Changes of Saves
+		// which update it.
+		// The previous version is retrieved atomically with the
+		// update, by findAndModify, which is slower than an upsert.
+		Previous bool
 	}
 
 	Conn struct {
@@ -23,11 +45,14 @@ type (
 	}
 
 	DB struct {
-		name        string
-		conn        *Conn
-		collections map[data.Kind]string
-		m           sync.Mutex
-		hub         *data.ChangeHub
+		name   string
+		conn   *Conn
+		schema *data.Schema
+		m      sync.Mutex
+		hub    *data.ChangeHub
+		tailer *tailer
+
+		previous bool
 	}
 )
 
@@ -65,12 +90,60 @@ func New(o *Opts) (*DB, error) {
 		return nil, err
 	}
 
-	return &DB{
-		conn:        c,
-		name:        name,
-		collections: make(map[data.Kind]string),
-		hub:         data.NewChangeHub(context.TODO()),
-	}, nil
+	schema := o.Schema
+	if schema == nil {
+		schema = data.NewSchema()
+	}
+
+	db := &DB{
+		conn:     c,
+		name:     name,
+		schema:   schema,
+		previous: o.Previous,
+	}
+
+	if o.Journal != "" {
+		j, err := NewJournal(db, o.Journal, o.JournalSize)
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		db.hub = data.NewJournaledChangeHub(context.TODO(), j)
+	} else {
+		db.hub = data.NewChangeHub(context.TODO())
+	}
+
+	if o.Tail != nil {
+		t, err := newTailer(db, *o.Tail)
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		db.tailer = t
+	}
+
+	return db, nil
+}
+
+// Close stops tailing the oplog, closes the channels
+// of the DB's subscribers, and closes its connection
+func (db *DB) Close() {
+	if db.tailer != nil {
+		db.tailer.close()
+	}
+
+	db.hub.Close()
+	db.conn.Close()
+}
+
+// ResumeToken retrieves the timestamp of the last operation
+// of the oplog which the DB tailed, zero if it doesn't tail
+func (db *DB) ResumeToken() bson.MongoTimestamp {
+	if db.tailer == nil {
+		return 0
+	}
+
+	return db.tailer.Token()
 }
 
 func (db *DB) Name() string {
@@ -83,8 +156,16 @@ func (db *DB) SetName(n string) {
 	db.name = n
 }
 
+// RegisterKind registers the kind with the DB's schema, to be stored in the collection
 func (db *DB) RegisterKind(k data.Kind, collectionName string) {
-	db.collections[k] = collectionName
+	db.schema.Register(&data.Model{
+		Kind:    k,
+		Storage: collectionName,
+	})
+}
+
+func (db *DB) Schema() *data.Schema {
+	return db.schema
 }
 
 func (db *DB) Fork() (*mgo.Session, error) {
@@ -96,9 +177,9 @@ func (db *DB) Fork() (*mgo.Session, error) {
 }
 
 func (db *DB) Collection(s *mgo.Session, k data.Kind) (*mgo.Collection, error) {
-	c, ok := db.collections[k]
-	if !ok {
-		panic(fmt.Sprintf("data/builtin/mongo: undefined collection for kind: %s", k))
+	c, err := db.schema.Storage(k)
+	if err != nil {
+		return nil, err
 	}
 	return s.DB(db.name).C(c), nil
 }
@@ -122,4 +203,15 @@ func (db *DB) Changes() *chan *data.Change {
 	return db.hub.Changes()
 }
 
+// data.Unsubscriber implementation
+func (db *DB) Unsubscribe(c *chan *data.Change) {
+	db.hub.Unsubscribe(c)
+}
+
+// data.Resumer implementation, it returns data.ErrNoJournal
+// unless the DB was constructed with a Journal collection
+func (db *DB) ChangesSince(seq uint64) (*chan *data.Change, error) {
+	return db.hub.ChangesSince(seq)
+}
+
 // }}}
@@ -0,0 +1,96 @@
+package mongo
+
+import (
+	"io"
+	"net"
+	"strings"
+
+	"github.com/elos/data"
+	"gopkg.in/mgo.v2"
+)
+
+// exceededTimeLimit is the code of the error of an
+// operation which exceeded its maxTimeMS
+const exceededTimeLimit = 50
+
+// classify maps the errors of mgo to the errors of the data
+// package, so that they may be inspected without knowledge of mgo
+func classify(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case err == mgo.ErrNotFound:
+		return data.ErrNotFound
+	case mgo.IsDup(err):
+		return &data.Error{Err: data.ErrDuplicateKey, Cause: err}
+	case err == io.EOF:
+		return &data.Error{Err: data.ErrNoConnection, Cause: err}
+	}
+
+	switch e := err.(type) {
+	case *data.Error:
+		return err
+	case *mgo.QueryError:
+		if e.Code == exceededTimeLimit {
+			return &data.Error{Err: data.ErrTimeout, Cause: err}
+		}
+	case *mgo.LastError:
+		if e.Code == exceededTimeLimit {
+			return &data.Error{Err: data.ErrTimeout, Cause: err}
+		}
+	case net.Error:
+		if e.Timeout() {
+			return &data.Error{Err: data.ErrTimeout, Cause: err}
+		}
+		return &data.Error{Err: data.ErrNoConnection, Cause: err}
+	}
+
+	if err.Error() == "no reachable servers" {
+		return &data.Error{Err: data.ErrNoConnection, Cause: err}
+	}
+
+	return err
+}
+
+// wrap classifies the error of the operation op, and wraps it in a data.Error
+func wrap(err error, op string, k data.Kind, id data.ID) error {
+	return data.WrapError(classify(err), op, k, id)
+}
+
+// violated determines the unique field of the model m whose index the
+// duplicate key error err violated, it returns "" if it is none of them.
+// The error names the index, which is named <field>_1 by EnsureIndex,
+// and qualified by its namespace before mongo 3.0:
+//
+//	E11000 duplicate key error collection: test.users index: key_1 dup key: ...
+//	E11000 duplicate key error index: test.users.$key_1 dup key: ...
+func violated(err error, m *data.Model) string {
+	if !mgo.IsDup(err) {
+		return ""
+	}
+
+	msg := err.Error()
+
+	i := strings.Index(msg, "index: ")
+	if i < 0 {
+		return ""
+	}
+
+	named := strings.Fields(msg[i+len("index: "):])
+	if len(named) == 0 {
+		return ""
+	}
+
+	name := named[0]
+	if j := strings.LastIndex(name, "$"); j >= 0 {
+		name = name[j+1:]
+	}
+
+	for _, f := range m.Unique {
+		if name == f+"_1" {
+			return f
+		}
+	}
+
+	return ""
+}
@@ -1,7 +1,6 @@
 package mongo
 
 import (
-	"errors"
 	"strings"
 	"sync"
 
@@ -23,7 +22,7 @@ func ParseObjectID(idS string) (id bson.ObjectId, err error) {
 	defer func() { // cause the bson pkg isn't idiomatic
 		if r := recover(); r != nil {
 			id = emptyID
-			err = errors.New("Could not parse id")
+			err = &data.Error{Op: "parse id", ID: data.ID(idS), Err: data.ErrInvalidID}
 		}
 	}()
 
@@ -112,6 +111,10 @@ func (s IDSet) IDs() []data.ID {
 	return ids
 }
 
+// IDIter iterates the records of a kind with the ids of an IDSet,
+// populating each from the DB. Should a record be missing, or fail to
+// populate, the iteration ends, and Err returns the error, with the
+// kind and id of the record.
 type IDIter struct {
 	data.DB
 	ids   IDSet
@@ -131,21 +134,37 @@ func NewIDIter(set IDSet, s data.DB) *IDIter {
 }
 
 func (i *IDIter) Next(r data.Record) bool {
-	if i.place >= len(i.ids) {
+	i.Lock()
+	defer i.Unlock()
+
+	if i.err != nil || i.place >= len(i.ids) {
 		return false
 	}
 
-	r.SetID(data.ID(i.ids[i.place].Hex()))
+	id := data.ID(i.ids[i.place].Hex())
+	r.SetID(id)
 
 	if err := i.DB.PopulateByID(r); err != nil {
-		i.err = err
+		i.err = data.WrapError(err, "iterate", r.Kind(), id)
 		return false
-	} else {
-		i.place += 1
-		return true
 	}
+
+	i.place += 1
+	return true
 }
 
+func (i *IDIter) Err() error {
+	i.Lock()
+	defer i.Unlock()
+
+	return i.err
+}
+
+// Close ends the iteration, Next returns false once it is closed
 func (i *IDIter) Close() error {
+	i.Lock()
+	defer i.Unlock()
+
+	i.place = len(i.ids)
 	return i.err
 }
@@ -1,10 +1,14 @@
 package mongo_test
 
 import (
+	"errors"
 	"math/rand"
 	"testing"
 
+	"github.com/elos/data"
+	"github.com/elos/data/builtin/mem"
 	"github.com/elos/data/builtin/mongo"
+	"github.com/elos/data/dbtest"
 	"github.com/elos/testing/expect"
 )
 
@@ -71,3 +75,49 @@ func TestIDSet(t *testing.T) {
 		t.Errorf("Should be able to drop a non-member id")
 	}
 }
+
+func TestIDIter(t *testing.T) {
+	db := mem.NewDB()
+
+	present, missing := mongo.NewObjectID(), mongo.NewObjectID()
+	if err := db.Save(&dbtest.Record{Id: present.Hex(), Name: "present"}); err != nil {
+		t.Fatalf("db.Save error: %v", err)
+	}
+
+	iter := mongo.NewIDIter(mongo.IDSet{present, missing, present}, db)
+
+	r := new(dbtest.Record)
+	if !iter.Next(r) || r.Name != "present" {
+		t.Fatalf("iter.Next: got %+v, want present record", r)
+	}
+
+	// a missing record ends the iteration
+	if iter.Next(new(dbtest.Record)) {
+		t.Error("iter.Next of missing record: got true, want false")
+	}
+
+	var e *data.Error
+	if err := iter.Err(); !errors.Is(err, data.ErrNotFound) || !errors.As(err, &e) || e.ID != data.ID(missing.Hex()) {
+		t.Errorf("iter.Err: got %v, want %v of %s", err, data.ErrNotFound, missing.Hex())
+	}
+
+	if iter.Next(new(dbtest.Record)) {
+		t.Error("iter.Next once ended: got true, want false")
+	}
+
+	if err := iter.Close(); !errors.Is(err, data.ErrNotFound) {
+		t.Errorf("iter.Close: got %v, want %v", err, data.ErrNotFound)
+	}
+
+	// closing ends the iteration early
+	iter = mongo.NewIDIter(mongo.IDSet{present, present}, db)
+	iter.Next(new(dbtest.Record))
+
+	if err := iter.Close(); err != nil {
+		t.Errorf("iter.Close error: %v", err)
+	}
+
+	if iter.Next(new(dbtest.Record)) {
+		t.Error("iter.Next once closed: got true, want false")
+	}
+}
@@ -0,0 +1,147 @@
+package mongo
+
+import (
+	"sync"
+
+	"github.com/elos/data"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// DefaultJournalSize is the size, in bytes, of a journal's capped collection
+const DefaultJournalSize = 1 << 20
+
+// Journal is a data.Journal which persists changes in a capped
+// collection, the oldest changes are discarded as the collection fills.
+//
+// The records of the changes are decoded into records constructed
+// by the schema, so the kinds must be registered with a Model whose
+// New is not nil to be replayed.
+type Journal struct {
+	db         *DB
+	collection string
+
+	m   sync.Mutex
+	seq uint64
+}
+
+type journaled struct {
+	Seq    int64    `bson:"_id"`
+	Kind   string   `bson:"kind"`
+	Change int      `bson:"change"`
+	Record bson.Raw `bson:"record"`
+}
+
+// NewJournal constructs a Journal, creating the capped collection, of
+// size bytes, unless it exists. The journal continues the sequence of
+// the changes already in the collection.
+func NewJournal(db *DB, collection string, size int) (*Journal, error) {
+	if size <= 0 {
+		size = DefaultJournalSize
+	}
+
+	s, err := db.Fork()
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+
+	c := s.DB(db.Name()).C(collection)
+
+	err = c.Create(&mgo.CollectionInfo{Capped: true, MaxBytes: size})
+	if err != nil && !isCollectionExists(err) {
+		return nil, err
+	}
+
+	var last journaled
+	switch err := c.Find(nil).Sort("-_id").One(&last); err {
+	case nil, mgo.ErrNotFound:
+	default:
+		return nil, err
+	}
+
+	return &Journal{
+		db:         db,
+		collection: collection,
+		seq:        uint64(last.Seq),
+	}, nil
+}
+
+// isCollectionExists reports whether the error
+// is mongo's refusal to create an existing collection
+func isCollectionExists(err error) bool {
+	qerr, ok := err.(*mgo.QueryError)
+	return ok && (qerr.Code == 48 || qerr.Message == "collection already exists")
+}
+
+// data.Journal implementation
+func (j *Journal) Append(c *data.Change) error {
+	s, err := j.db.Fork()
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	j.m.Lock()
+	defer j.m.Unlock()
+
+	err = s.DB(j.db.Name()).C(j.collection).Insert(bson.M{
+		"_id":    int64(j.seq + 1),
+		"kind":   string(c.Record.Kind()),
+		"change": int(c.ChangeKind),
+		"record": c.Record,
+	})
+	if err != nil {
+		return err
+	}
+
+	j.seq++
+	c.Seq = j.seq
+	return nil
+}
+
+// data.Journal implementation
+func (j *Journal) Since(seq uint64) ([]*data.Change, error) {
+	s, err := j.db.Fork()
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+
+	c := s.DB(j.db.Name()).C(j.collection)
+
+	// the changes since seq are retained if the change
+	// after seq is, or if there has been no change since
+	if seq > 0 {
+		var first journaled
+		switch err := c.Find(nil).Sort("_id").One(&first); {
+		case err == mgo.ErrNotFound:
+		case err != nil:
+			return nil, err
+		case uint64(first.Seq) > seq+1:
+			return nil, data.ErrJournalTruncated
+		}
+	}
+
+	var js []journaled
+	if err := c.Find(bson.M{"_id": bson.M{"$gt": int64(seq)}}).Sort("_id").All(&js); err != nil {
+		return nil, err
+	}
+
+	changes := make([]*data.Change, len(js))
+	for i, jd := range js {
+		r, err := j.db.schema.New(data.Kind(jd.Kind))
+		if err != nil {
+			return nil, err
+		}
+
+		if err := jd.Record.Unmarshal(r); err != nil {
+			return nil, err
+		}
+
+		changes[i] = data.NewChange(data.ChangeKind(jd.Change), r)
+		changes[i].Seq = uint64(jd.Seq)
+	}
+
+	return changes, nil
+}
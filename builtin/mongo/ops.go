@@ -1,109 +1,266 @@
 package mongo
 
 import (
+	"reflect"
+	"time"
+
 	"github.com/elos/data"
+	"github.com/elos/data/transfer"
+	"golang.org/x/net/context"
 	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 )
 
-func (db *DB) Save(r data.Record) error {
-	s, err := db.Fork()
-	if err != nil {
-		return err
-	}
-	defer s.Close()
-
-	collection, err := db.Collection(s, r.Kind())
-	if err != nil {
-		return err
+// forkContext forks a session whose socket timeout
+// is the time remaining until the context's deadline
+func (db *DB) forkContext(ctx context.Context) (*mgo.Session, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
 
-	id := r.ID()
-	bid, err := ParseObjectID(id.String())
+	s, err := db.Fork()
 	if err != nil {
-		return data.ErrInvalidID
+		return nil, err
 	}
 
-	_, err = collection.UpsertId(bid, r)
-
-	if err == nil {
-		db.hub.Notify(data.NewUpdate(r))
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout := deadline.Sub(time.Now())
+		if timeout <= 0 {
+			s.Close()
+			return nil, context.DeadlineExceeded
+		}
+		s.SetSocketTimeout(timeout)
 	}
 
-	return err
+	return s, nil
 }
 
-func (db *DB) Delete(r data.Record) error {
-	s, err := db.Fork()
+// do performs the operation fn with a forked session, and waits for
+// it, so that a write is never made after do reports it failed. mgo
+// has no notion of cancellation, so the operation is bounded only by
+// the context's deadline, as the socket timeout of the session. If it
+// fails once the context is done, do returns the context's error, but
+// as with any timeout, a write whose reply timed out may have been made.
+func (db *DB) do(ctx context.Context, fn func(*mgo.Session) error) error {
+	s, err := db.forkContext(ctx)
 	if err != nil {
 		return err
 	}
 	defer s.Close()
 
-	collection, err := db.Collection(s, r.Kind())
-	if err != nil {
+	if err := fn(s); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
 		return err
 	}
 
-	id := r.ID()
-	bid, err := ParseObjectID(id.String())
-	if err != nil {
-		return data.ErrInvalidID
+	return nil
+}
+
+func (db *DB) Save(r data.Record) error {
+	return db.SaveContext(context.Background(), r)
+}
+
+// SaveContext upserts the record. The save of a Versioned record is
+// conditional, it is selected by its id and version: a record at version 0
+// is upserted, so that it conflicts with a stored record of the same id as
+// a duplicate key, otherwise it is updated, so that it conflicts if no
+// record is at the version.
+//
+// The unique fields of the kind's model are indexed by unique indexes,
+// which SaveContext ensures.
+func (db *DB) SaveContext(ctx context.Context, r data.Record) (err error) {
+	v, versioned := r.(data.Versioned)
+	if versioned {
+		version := v.Version()
+		v.SetVersion(version + 1)
+
+		// should the save fail, r is left at the version it was
+		defer func() {
+			if err != nil {
+				v.SetVersion(version)
+			}
+		}()
 	}
 
-	err = collection.RemoveId(bid)
+	return wrap(db.do(ctx, func(s *mgo.Session) error {
+		collection, err := db.Collection(s, r.Kind())
+		if err != nil {
+			return err
+		}
+
+		id := r.ID()
+		bid, err := ParseObjectID(id.String())
+		if err != nil {
+			return data.ErrInvalidID
+		}
+
+		m, err := db.schema.Model(r.Kind())
+		if err != nil {
+			return err
+		}
+
+		if err := ensureUnique(collection, m); err != nil {
+			return err
+		}
+
+		selector := bson.M{"_id": bid}
+		upsert := true
+		if versioned {
+			version := v.Version() - 1
+			selector[m.VersionField] = version
+			upsert = version == 0
+		}
+
+		forget := db.own(r.Kind(), id, data.Update)
+
+		var (
+			info     *mgo.ChangeInfo
+			previous data.Record
+		)
+
+		switch {
+		case db.previous:
+			previous = reflect.New(reflect.TypeOf(r).Elem()).Interface().(data.Record)
+			info, err = collection.Find(selector).Apply(mgo.Change{Update: r, Upsert: upsert}, previous)
+		case upsert:
+			info, err = collection.Upsert(selector, r)
+		default:
+			err = collection.Update(selector, r)
+			info = &mgo.ChangeInfo{Updated: 1}
+		}
+
+		if err != nil {
+			forget()
+
+			if field := violated(err, m); field != "" {
+				return &data.Error{Err: data.ErrDuplicateKey, Field: field, Cause: err}
+			}
+
+			// a versioned save conflicts if no record is at its version,
+			// or, should it be inserted, if a record has its id
+			if versioned && (err == mgo.ErrNotFound || mgo.IsDup(err)) {
+				return data.ErrConflict
+			}
+
+			return err
+		}
+
+		// the upserted id is only set if the record was inserted
+		if info.UpsertedId != nil {
+			db.hub.Notify(data.NewCreate(r))
+			return nil
+		}
+
+		c := data.NewUpdate(r)
+		if previous != nil {
+			c.Previous = previous
+
+			// the update was made, so it is notified, if without a diff
+			if d, err := transfer.Diff(previous, r); err == nil {
+				c.Diff = d
+			}
+		}
 
-	if err == nil {
-		db.hub.Notify(data.NewDelete(r))
+		db.hub.Notify(c)
+		return nil
+	}), "save", r.Kind(), r.ID())
+}
+
+// ensureUnique ensures the unique indexes of the unique fields of the
+// model m. The indexes are sparse, so that records which lack the field
+// are not constrained. mgo caches the indexes it has ensured, so only
+// the first save of a kind creates them.
+func ensureUnique(c *mgo.Collection, m *data.Model) error {
+	for _, f := range m.Unique {
+		if err := c.EnsureIndex(mgo.Index{Key: []string{f}, Unique: true, Sparse: true}); err != nil {
+			return err
+		}
 	}
 
-	return err
+	return nil
+}
+
+func (db *DB) Delete(r data.Record) error {
+	return db.DeleteContext(context.Background(), r)
+}
+
+func (db *DB) DeleteContext(ctx context.Context, r data.Record) error {
+	return wrap(db.do(ctx, func(s *mgo.Session) error {
+		collection, err := db.Collection(s, r.Kind())
+		if err != nil {
+			return err
+		}
+
+		id := r.ID()
+		bid, err := ParseObjectID(id.String())
+		if err != nil {
+			return data.ErrInvalidID
+		}
+
+		forget := db.own(r.Kind(), id, data.Delete)
+
+		err = collection.RemoveId(bid)
+
+		switch err {
+		case nil:
+			db.hub.Notify(data.NewDelete(r))
+			return nil
+		case mgo.ErrNotFound:
+			forget()
+			return data.ErrNotFound
+		default:
+			forget()
+			return err
+		}
+	}), "delete", r.Kind(), r.ID())
 }
 
 func (db *DB) PopulateByID(r data.Record) error {
-	s, err := db.Fork()
-	if err != nil {
-		return err
-	}
-	defer s.Close()
+	return db.PopulateByIDContext(context.Background(), r)
+}
 
-	collection, err := db.Collection(s, r.Kind())
-	if err != nil {
-		return err
-	}
+func (db *DB) PopulateByIDContext(ctx context.Context, r data.Record) error {
+	return wrap(db.do(ctx, func(s *mgo.Session) error {
+		collection, err := db.Collection(s, r.Kind())
+		if err != nil {
+			return err
+		}
 
-	id := r.ID()
-	bid, err := ParseObjectID(id.String())
-	if err != nil {
-		return data.ErrInvalidID
-	}
+		id := r.ID()
+		bid, err := ParseObjectID(id.String())
+		if err != nil {
+			return data.ErrInvalidID
+		}
 
-	err = collection.FindId(bid).One(r)
-	if err == mgo.ErrNotFound {
-		return data.ErrNotFound
-	} else {
-		return err
-	}
+		err = collection.FindId(bid).One(r)
+		if err == mgo.ErrNotFound {
+			return data.ErrNotFound
+		} else {
+			return err
+		}
+	}), "populate", r.Kind(), r.ID())
 }
 
 func (db *DB) PopulateByField(field string, value interface{}, r data.Record) error {
-	s, err := db.Fork()
-	if err != nil {
-		return err
-	}
-	defer s.Close()
+	return db.PopulateByFieldContext(context.Background(), field, value, r)
+}
 
-	collection, err := db.Collection(s, r.Kind())
-	if err != nil {
-		return err
-	}
+func (db *DB) PopulateByFieldContext(ctx context.Context, field string, value interface{}, r data.Record) error {
+	return wrap(db.do(ctx, func(s *mgo.Session) error {
+		collection, err := db.Collection(s, r.Kind())
+		if err != nil {
+			return err
+		}
 
-	err = collection.Find(bson.M{field: value}).One(r)
-	if err == mgo.ErrNotFound {
-		return data.ErrNotFound
-	} else {
-		return err
-	}
+		err = collection.Find(bson.M{field: value}).One(r)
+		if err == mgo.ErrNotFound {
+			return data.ErrNotFound
+		} else {
+			return err
+		}
+	}), "populate", r.Kind(), "")
 }
 
 func (db *DB) Query(k data.Kind) data.Query {
@@ -1,12 +1,18 @@
 package mongo_test
 
 import (
+	"errors"
+	"fmt"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/elos/data"
 	"github.com/elos/data/builtin/mongo"
+	"github.com/elos/data/dbtest"
 	"github.com/elos/testing/expect"
+	"golang.org/x/net/context"
+	"gopkg.in/mgo.v2/bson"
 )
 
 func TestSave(t *testing.T) {
@@ -21,11 +27,11 @@ func TestSave(t *testing.T) {
 	u.Name = testString
 	u.CreatedAt = testTime
 
-	if err := db.Save(u); err != data.ErrInvalidID {
+	if err := db.Save(u); !errors.Is(err, data.ErrInvalidID) {
 		t.Errorf("Save errors: %s", err.Error())
 	}
 
-	if err := db.Save(u); err != data.ErrInvalidID {
+	if err := db.Save(u); !errors.Is(err, data.ErrInvalidID) {
 		t.Errorf("Mongo should not choke on bad ids")
 	}
 	defer db.Delete(u)
@@ -63,7 +69,7 @@ func TestDelete(t *testing.T) {
 	u := &User{}
 	u.Name = testString
 
-	if err := db.Delete(u); err != data.ErrInvalidID {
+	if err := db.Delete(u); !errors.Is(err, data.ErrInvalidID) {
 		t.Errorf("Delete should recognize a bad id")
 	}
 
@@ -80,7 +86,7 @@ func TestDelete(t *testing.T) {
 
 	r := &User{}
 	r.SetID(u.ID())
-	if err := db.PopulateByID(r); err != data.ErrNotFound {
+	if err := db.PopulateByID(r); !errors.Is(err, data.ErrNotFound) {
 		t.Errorf("the delete should have removed the model")
 	}
 }
@@ -103,7 +109,7 @@ func TestPopulate(t *testing.T) {
 
 	u = &User{}
 
-	if err := db.PopulateByID(u); err != data.ErrInvalidID {
+	if err := db.PopulateByID(u); !errors.Is(err, data.ErrInvalidID) {
 		t.Errorf("PopulateByID should reject a model with an invalid ID")
 	}
 
@@ -155,3 +161,224 @@ func TestChanges(t *testing.T) {
 		t.Fatal("Didn't recieve a change")
 	}
 }
+
+func TestPreviousChanges(t *testing.T) {
+	db, err := mongo.New(&mongo.Opts{Addr: "0.0.0.0", Previous: true})
+	expect.NoError("creating db", err, t)
+	db.RegisterKind(UserKind, "users")
+
+	changes := db.Changes()
+	defer db.Unsubscribe(changes)
+
+	u := &User{Name: "before"}
+	u.SetID(db.NewID())
+	expect.NoError("saving model", db.Save(u), t)
+	defer db.Delete(u)
+
+	u.Name = "after"
+	expect.NoError("saving model", db.Save(u), t)
+
+	for _, want := range []data.ChangeKind{data.Create, data.Update} {
+		select {
+		case c := <-*changes:
+			if got := c.ChangeKind; got != want {
+				t.Fatalf("c.ChangeKind: got %v, want %v", got, want)
+			}
+
+			if want == data.Create {
+				continue
+			}
+
+			previous, ok := c.Previous.(*User)
+			if !ok {
+				t.Fatalf("c.Previous: got %T, want *User", c.Previous)
+			}
+
+			if got, want := previous.Name, "before"; got != want {
+				t.Errorf("previous.Name: got %q, want %q", got, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Didn't recieve a change")
+		}
+	}
+}
+
+// TestSaveContextExpired tests that SaveContext leaves the record
+// alone once it returns, should the context expire. Run it with -race.
+func TestSaveContextExpired(t *testing.T) {
+	db, err := mongo.New(&mongo.Opts{Addr: "0.0.0.0"})
+	expect.NoError("creating db", err, t)
+	expect.NoError("registering kind", db.Schema().Register(dbtest.VersionedModel("dbtest_versioned_records")), t)
+
+	r := &dbtest.VersionedRecord{Name: "expiring"}
+	r.SetID(db.NewID())
+	defer db.Delete(r)
+
+	// already expired
+	expired, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+
+	if err := db.SaveContext(expired, r); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("db.SaveContext: got %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	if got, want := r.Ver, 0; got != want {
+		t.Errorf("r.Version: got %d, want %d", got, want)
+	}
+
+	// expiring while the save is made
+	for i := 0; i < 20; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(i)*100*time.Microsecond)
+
+		version := r.Ver
+		err := db.SaveContext(ctx, r)
+		cancel()
+
+		// the save is done with r, so it may be changed
+		r.Name = fmt.Sprintf("expiring %d", i)
+
+		if err != nil {
+			if got, want := r.Ver, version; got != want {
+				t.Errorf("r.Version after failed save: got %d, want %d", got, want)
+			}
+			continue
+		}
+
+		stored := &dbtest.VersionedRecord{Id: r.Id}
+		expect.NoError("populating record", db.PopulateByID(stored), t)
+
+		if got, want := stored.Ver, r.Ver; got != want {
+			t.Errorf("stored.Version: got %d, want %d", got, want)
+		}
+	}
+}
+
+func TestUnregisteredKind(t *testing.T) {
+	db, err := mongo.New(&mongo.Opts{Addr: "0.0.0.0"})
+	expect.NoError("creating db", err, t)
+
+	u := &User{}
+	u.SetID(db.NewID())
+
+	if err := db.Save(u); !errors.Is(err, data.ErrUnregisteredKind) {
+		t.Errorf("db.Save: got %v, want %v", err, data.ErrUnregisteredKind)
+	}
+
+	if err := db.PopulateByID(u); !errors.Is(err, data.ErrUnregisteredKind) {
+		t.Errorf("db.PopulateByID: got %v, want %v", err, data.ErrUnregisteredKind)
+	}
+
+	if _, err := db.Query(UserKind).Execute(); !errors.Is(err, data.ErrUnregisteredKind) {
+		t.Errorf("db.Query: got %v, want %v", err, data.ErrUnregisteredKind)
+	}
+}
+
+// TestTail tests that a DB which tails the oplog is notified of
+// the writes of another, and not twice of its own. It requires
+// the database be a member of a replica set.
+func TestTail(t *testing.T) {
+	tailing, err := mongo.New(&mongo.Opts{Addr: "0.0.0.0", Tail: &mongo.TailOpts{}})
+	if err != nil {
+		t.Skipf("tailing the oplog: %v", err)
+	}
+	defer tailing.Close()
+	tailing.Schema().Register(&data.Model{
+		Kind:    UserKind,
+		New:     func() data.Record { return new(User) },
+		Storage: "users",
+	})
+
+	other, err := mongo.New(&mongo.Opts{Addr: "0.0.0.0"})
+	expect.NoError("creating db", err, t)
+	defer other.Close()
+	other.RegisterKind(UserKind, "users")
+
+	changes := tailing.Changes()
+	defer tailing.Unsubscribe(changes)
+
+	theirs := &User{Name: "theirs"}
+	theirs.SetID(other.NewID())
+	expect.NoError("saving their user", other.Save(theirs), t)
+
+	ours := &User{Name: "ours"}
+	ours.SetID(tailing.NewID())
+	expect.NoError("saving our user", tailing.Save(ours), t)
+
+	received := make(map[data.ID]int)
+	timeout := time.After(5 * time.Second)
+
+Receive:
+	for {
+		select {
+		case c := <-*changes:
+			received[c.Record.ID()]++
+		case <-timeout:
+			break Receive
+		}
+	}
+
+	if got, want := received[theirs.ID()], 1; got != want {
+		t.Errorf("changes to their user: got %d, want %d", got, want)
+	}
+
+	if got, want := received[ours.ID()], 1; got != want {
+		t.Errorf("changes to our user: got %d, want %d", got, want)
+	}
+}
+
+// flakyUser fails to decode while flakes remain
+type flakyUser struct {
+	User
+}
+
+var flakes int32
+
+func (u *flakyUser) SetBSON(raw bson.Raw) error {
+	if atomic.AddInt32(&flakes, -1) >= 0 {
+		return errors.New("flaked")
+	}
+
+	return u.User.SetBSON(raw)
+}
+
+// TestTailRetry tests that an operation which can't be applied is
+// tailed again, rather than skipped. It requires the database be a
+// member of a replica set.
+func TestTailRetry(t *testing.T) {
+	tailing, err := mongo.New(&mongo.Opts{Addr: "0.0.0.0", Tail: &mongo.TailOpts{}})
+	if err != nil {
+		t.Skipf("tailing the oplog: %v", err)
+	}
+	defer tailing.Close()
+	tailing.Schema().Register(&data.Model{
+		Kind:    UserKind,
+		New:     func() data.Record { return new(flakyUser) },
+		Storage: "users",
+	})
+
+	other, err := mongo.New(&mongo.Opts{Addr: "0.0.0.0"})
+	expect.NoError("creating db", err, t)
+	defer other.Close()
+	other.RegisterKind(UserKind, "users")
+
+	changes := tailing.Changes()
+	defer tailing.Unsubscribe(changes)
+
+	atomic.StoreInt32(&flakes, 1)
+
+	theirs := &User{Name: "theirs"}
+	theirs.SetID(other.NewID())
+	expect.NoError("saving their user", other.Save(theirs), t)
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case c := <-*changes:
+			if c.Record.ID() == theirs.ID() {
+				return
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for the change which failed to apply")
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package mongo
+
+import (
+	"github.com/elos/data"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// predicate translates a data.Predicate to a mongo query document
+func predicate(p *data.Predicate) bson.M {
+	switch p.Op {
+	case data.OpEq:
+		return bson.M{p.Field: p.Value}
+	case data.OpAnd, data.OpOr:
+		operands := make([]bson.M, len(p.Operands))
+		for i, o := range p.Operands {
+			operands[i] = predicate(o)
+		}
+		return bson.M{string(p.Op): operands}
+	case data.OpNot:
+		// mongo's $not only applies to an operator expression on a
+		// single field, whereas $nor negates an arbitrary expression
+		operands := make([]bson.M, len(p.Operands))
+		for i, o := range p.Operands {
+			operands[i] = predicate(o)
+		}
+		return bson.M{"$nor": operands}
+	default:
+		return bson.M{p.Field: bson.M{string(p.Op): p.Value}}
+	}
+}
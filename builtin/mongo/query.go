@@ -4,6 +4,7 @@ import (
 	"sync"
 
 	"github.com/elos/data"
+	"golang.org/x/net/context"
 	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 )
@@ -12,8 +13,10 @@ type Query struct {
 	db                 *DB
 	kind               data.Kind
 	match              data.AttrMap
+	where              []*data.Predicate
 	limit, skip, batch int
 	order              []string
+	projection         data.Projection
 	m                  sync.Mutex
 }
 
@@ -26,20 +29,27 @@ func m(in map[string]interface{}) bson.M {
 }
 
 func (q *Query) Execute() (data.Iterator, error) {
+	return q.ExecuteContext(context.Background())
+}
+
+// ExecuteContext executes the query, the resulting Iterator is
+// bound to the context, and its session times out at the context's deadline
+func (q *Query) ExecuteContext(ctx context.Context) (data.Iterator, error) {
 	q.m.Lock()
 	defer q.m.Unlock()
 
-	s, err := q.db.Fork()
+	s, err := q.db.forkContext(ctx)
 	if err != nil {
-		return nil, err
+		return nil, wrap(err, "query", q.kind, "")
 	}
 
 	c, err := q.db.Collection(s, q.kind)
 	if err != nil {
-		return nil, err
+		s.Close()
+		return nil, wrap(err, "query", q.kind, "")
 	}
 
-	mgoQuery := c.Find(q.match)
+	mgoQuery := c.Find(q.filter())
 
 	if q.limit != 0 {
 		mgoQuery.Limit(q.limit)
@@ -57,7 +67,54 @@ func (q *Query) Execute() (data.Iterator, error) {
 		mgoQuery.Sort(q.order...)
 	}
 
-	return newIter(mgoQuery.Iter(), s), nil
+	if q.projection.Restricts() {
+		mgoQuery.Select(q.selector())
+	}
+
+	return data.IterContext(ctx, newIter(mgoQuery.Iter(), s)), nil
+}
+
+// filter conjoins the match of Select with the predicates of Where
+func (q *Query) filter() interface{} {
+	if len(q.where) == 0 {
+		return q.match
+	}
+
+	and := make([]bson.M, 0, len(q.where)+1)
+
+	if len(q.match) > 0 {
+		and = append(and, m(q.match))
+	}
+
+	for _, p := range q.where {
+		and = append(and, predicate(p))
+	}
+
+	return bson.M{"$and": and}
+}
+
+// selector is the projection of the query, as a mongo projection,
+// which may not mix inclusion with exclusion, save that of the _id
+func (q *Query) selector() bson.M {
+	selector := bson.M{}
+
+	if len(q.projection.Include) > 0 {
+		// the _id is included, even if no field is
+		selector["_id"] = 1
+		for _, f := range q.projection.Include {
+			if q.projection.Fetches(f) {
+				selector[f] = 1
+			}
+		}
+		return selector
+	}
+
+	for _, f := range q.projection.Exclude {
+		if f != "_id" {
+			selector[f] = 0
+		}
+	}
+	return selector
 }
 
 func (q *Query) Select(am data.AttrMap) data.Query {
@@ -68,6 +125,14 @@ func (q *Query) Select(am data.AttrMap) data.Query {
 	return q
 }
 
+func (q *Query) Where(p *data.Predicate) data.Query {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	q.where = append(q.where, p)
+	return q
+}
+
 func (q *Query) Limit(i int) data.Query {
 	q.m.Lock()
 	defer q.m.Unlock()
@@ -100,9 +165,28 @@ func (q *Query) Order(fields ...string) data.Query {
 	return q
 }
 
+// Include selects only the fields of the documents, see data.Query
+func (q *Query) Include(fields ...string) data.Query {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	q.projection.Include = append([]string(nil), fields...)
+	return q
+}
+
+func (q *Query) Exclude(fields ...string) data.Query {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	q.projection.Exclude = append([]string(nil), fields...)
+	return q
+}
+
 type iter struct {
 	iter    *mgo.Iter
 	session *mgo.Session
+	closed  bool
+	err     error
 	sync.Mutex
 }
 
@@ -111,10 +195,39 @@ func newIter(i *mgo.Iter, s *mgo.Session) data.Iterator {
 }
 
 func (i *iter) Next(r data.Record) bool {
+	i.Lock()
+	defer i.Unlock()
+
+	if i.closed {
+		return false
+	}
+
 	return i.iter.Next(r)
 }
 
+// Err returns the error of the cursor, or of decoding a record
+func (i *iter) Err() error {
+	i.Lock()
+	defer i.Unlock()
+
+	if i.closed {
+		return i.err
+	}
+
+	return wrap(i.iter.Err(), "iterate", "", "")
+}
+
+// Close kills the cursor, and closes the session of the query
 func (i *iter) Close() error {
-	defer i.session.Close()
-	return i.iter.Close()
+	i.Lock()
+	defer i.Unlock()
+
+	if i.closed {
+		return i.err
+	}
+
+	i.closed = true
+	i.err = wrap(i.iter.Close(), "iterate", "", "")
+	i.session.Close()
+	return i.err
 }
@@ -5,6 +5,7 @@ import (
 
 	"github.com/elos/data"
 	"github.com/elos/data/builtin/mongo"
+	"github.com/elos/data/dbtest"
 	"github.com/elos/testing/expect"
 )
 
@@ -51,3 +52,19 @@ func TestQuery(t *testing.T) {
 		t.Fatalf("u.Name: got %q, want %q", got, want)
 	}
 }
+
+func TestConformance(t *testing.T) {
+	dbtest.TestDB(t, func() (data.DB, error) {
+		db, err := mongo.New(&mongo.Opts{Addr: "0.0.0.0", Journal: "dbtest_changes"})
+		if err != nil {
+			return nil, err
+		}
+		if err := db.Schema().Register(dbtest.Model("dbtest_records")); err != nil {
+			return nil, err
+		}
+		if err := db.Schema().Register(dbtest.VersionedModel("dbtest_versioned_records")); err != nil {
+			return nil, err
+		}
+		return db, db.Schema().Register(dbtest.UniqueModel("dbtest_unique_records"))
+	})
+}
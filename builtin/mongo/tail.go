@@ -0,0 +1,433 @@
+package mongo
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/elos/data"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	// DefaultOplog is the collection, of the local database,
+	// which a replica set member records its operations in
+	DefaultOplog = "oplog.rs"
+
+	// DefaultTokenCollection is the collection in which tailers
+	// store their resume tokens
+	DefaultTokenCollection = "data_resume_tokens"
+
+	// DefaultDedupWindow is how long a write made by the DB is
+	// remembered, so as not to notify it again when it is tailed
+	DefaultDedupWindow = time.Minute
+)
+
+// TailOpts configure the tailing of the oplog, by which a DB
+// notifies subscribers of the writes made by other processes.
+//
+// Tailing requires the database be a member of a replica set.
+type TailOpts struct {
+	// Oplog is the oplog collection of the local
+	// database, it defaults to DefaultOplog
+	Oplog string
+
+	// ResumeID identifies the tailer's resume token. If given, the
+	// token, the timestamp of the last operation tailed, is stored
+	// in the TokenCollection, and tailing resumes from it when the
+	// DB is next constructed, so that restarts do not drop changes.
+	// Without it, tailing begins with the operations which follow
+	// the construction of the DB.
+	ResumeID string
+
+	// TokenCollection defaults to DefaultTokenCollection
+	TokenCollection string
+
+	// DedupWindow defaults to DefaultDedupWindow
+	DedupWindow time.Duration
+}
+
+type (
+	// tailer translates the operations of the
+	// oplog into the changes of its DB
+	tailer struct {
+		db   *DB
+		opts TailOpts
+
+		// own remembers the DB's own writes, so that they
+		// are not notified twice, once by the write and
+		// again when it is tailed
+		own *writes
+
+		m     sync.Mutex
+		token bson.MongoTimestamp
+
+		stop chan struct{}
+		done chan struct{}
+	}
+
+	// operation is an entry of the oplog
+	operation struct {
+		Timestamp bson.MongoTimestamp `bson:"ts"`
+		Op        string              `bson:"op"`
+		Namespace string              `bson:"ns"`
+		Object    bson.Raw            `bson:"o"`
+		Object2   bson.Raw            `bson:"o2"`
+	}
+
+	resumeToken struct {
+		ID        string              `bson:"_id"`
+		Timestamp bson.MongoTimestamp `bson:"ts"`
+	}
+)
+
+func newTailer(db *DB, opts TailOpts) (*tailer, error) {
+	if opts.Oplog == "" {
+		opts.Oplog = DefaultOplog
+	}
+
+	if opts.TokenCollection == "" {
+		opts.TokenCollection = DefaultTokenCollection
+	}
+
+	if opts.DedupWindow <= 0 {
+		opts.DedupWindow = DefaultDedupWindow
+	}
+
+	t := &tailer{
+		db:   db,
+		opts: opts,
+		own:  newWrites(opts.DedupWindow),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+
+	s, err := db.Fork()
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+
+	if t.token, err = t.resume(s); err != nil {
+		return nil, err
+	}
+
+	go t.run()
+	return t, nil
+}
+
+// resume determines the timestamp from which to tail, the stored
+// resume token if there is one, otherwise that of the last operation
+func (t *tailer) resume(s *mgo.Session) (bson.MongoTimestamp, error) {
+	if t.opts.ResumeID != "" {
+		var token resumeToken
+		switch err := s.DB(t.db.Name()).C(t.opts.TokenCollection).FindId(t.opts.ResumeID).One(&token); err {
+		case nil:
+			return token.Timestamp, nil
+		case mgo.ErrNotFound:
+		default:
+			return 0, err
+		}
+	}
+
+	var last operation
+	switch err := s.DB("local").C(t.opts.Oplog).Find(nil).Sort("-$natural").One(&last); err {
+	case nil:
+		return last.Timestamp, nil
+	case mgo.ErrNotFound:
+		return 0, nil
+	default:
+		return 0, err
+	}
+}
+
+// Token retrieves the resume token, the timestamp of the last operation tailed
+func (t *tailer) Token() bson.MongoTimestamp {
+	t.m.Lock()
+	defer t.m.Unlock()
+	return t.token
+}
+
+func (t *tailer) run() {
+	defer close(t.done)
+
+	for {
+		if err := t.tail(); err != nil {
+			log.Printf("data/builtin/mongo: tailing the oplog: %v", err)
+		}
+
+		select {
+		case <-t.stop:
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// tail follows the oplog, from the token, until the tailer is
+// stopped, or the cursor fails. An operation which can't be applied
+// ends the tailing, without advancing the token, so that it is
+// tailed again when the tailing resumes.
+func (t *tailer) tail() error {
+	s, err := t.db.Fork()
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	// the operations on the DB's database, save the writes of
+	// resume tokens, lest the tailer tail its own advances
+	query := bson.M{
+		"ts": bson.M{"$gt": t.Token()},
+		"ns": bson.M{
+			"$regex": "^" + regexp.QuoteMeta(t.db.Name()) + `\.`,
+			"$ne":    t.db.Name() + "." + t.opts.TokenCollection,
+		},
+	}
+
+	iter := s.DB("local").C(t.opts.Oplog).Find(query).LogReplay().Tail(time.Second)
+	defer iter.Close()
+
+	for {
+		var op operation
+		for iter.Next(&op) {
+			if err := t.apply(s, &op); err != nil {
+				return fmt.Errorf("applying %s operation on %s: %v", op.Op, op.Namespace, err)
+			}
+
+			if err := t.advance(s, op.Timestamp); err != nil {
+				return err
+			}
+
+			op = operation{}
+		}
+
+		if err := iter.Err(); err != nil {
+			return err
+		}
+
+		select {
+		case <-t.stop:
+			return nil
+		default:
+		}
+
+		if !iter.Timeout() {
+			// the cursor is dead, query anew
+			return nil
+		}
+	}
+}
+
+// advance moves the token past the operation with the timestamp ts
+func (t *tailer) advance(s *mgo.Session, ts bson.MongoTimestamp) error {
+	t.m.Lock()
+	t.token = ts
+	t.m.Unlock()
+
+	if t.opts.ResumeID == "" {
+		return nil
+	}
+
+	_, err := s.DB(t.db.Name()).C(t.opts.TokenCollection).UpsertId(t.opts.ResumeID, resumeToken{
+		ID:        t.opts.ResumeID,
+		Timestamp: ts,
+	})
+	return err
+}
+
+// apply notifies the DB's hub of the change the operation
+// made, unless the DB made it itself, or the operation is not
+// on the collection of a kind of the DB's schema
+func (t *tailer) apply(s *mgo.Session, op *operation) error {
+	var ck data.ChangeKind
+	switch op.Op {
+	case "i":
+		ck = data.Create
+	case "u":
+		ck = data.Update
+	case "d":
+		ck = data.Delete
+	default:
+		return nil
+	}
+
+	k, ok := t.db.kindOf(strings.TrimPrefix(op.Namespace, t.db.Name()+"."))
+	if !ok {
+		return nil
+	}
+
+	// the _id is in the object of inserts and deletes, and
+	// the second object, the selector, of updates
+	doc := op.Object
+	if ck == data.Update {
+		doc = op.Object2
+	}
+
+	var selector struct {
+		ID interface{} `bson:"_id"`
+	}
+	if err := doc.Unmarshal(&selector); err != nil {
+		return err
+	}
+
+	id := idOf(selector.ID)
+
+	if t.own.seen(writeKey(k, id, ck)) {
+		return nil
+	}
+
+	r, err := t.db.schema.New(k)
+	if err != nil {
+		// the kind has no constructor
+		return nil
+	}
+
+	switch ck {
+	case data.Create:
+		err = op.Object.Unmarshal(r)
+	case data.Update:
+		// the object of an update may be a modifier, rather than
+		// the document, so retrieve the document as it is now
+		err = s.DB(t.db.Name()).C(strings.TrimPrefix(op.Namespace, t.db.Name()+".")).FindId(selector.ID).One(r)
+		if err == mgo.ErrNotFound {
+			// deleted since, the delete will be tailed
+			return nil
+		}
+	case data.Delete:
+		r.SetID(id)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	t.db.hub.Notify(data.NewChange(ck, r))
+	return nil
+}
+
+func (t *tailer) close() {
+	close(t.stop)
+	<-t.done
+}
+
+// own remembers a write the DB is about to make, so that it is not
+// notified again when it is tailed. It returns a function which
+// forgets the write, should it fail.
+func (db *DB) own(k data.Kind, id data.ID, ck data.ChangeKind) func() {
+	if db.tailer == nil {
+		return func() {}
+	}
+
+	key := writeKey(k, id, ck)
+	db.tailer.own.record(key)
+	return func() { db.tailer.own.forget(key) }
+}
+
+// kindOf determines the kind stored in the collection
+func (db *DB) kindOf(collection string) (data.Kind, bool) {
+	for _, k := range db.schema.Kinds() {
+		if c, err := db.schema.Storage(k); err == nil && c == collection {
+			return k, true
+		}
+	}
+
+	return "", false
+}
+
+func idOf(id interface{}) data.ID {
+	if oid, ok := id.(bson.ObjectId); ok {
+		return data.ID(oid.Hex())
+	}
+
+	return data.ID(fmt.Sprint(id))
+}
+
+// writes {{{
+
+// writes remembers the writes a DB made, for a window of time
+type writes struct {
+	window time.Duration
+
+	m       sync.Mutex
+	pending map[string][]time.Time
+}
+
+func newWrites(window time.Duration) *writes {
+	return &writes{
+		window:  window,
+		pending: make(map[string][]time.Time),
+	}
+}
+
+// writeKey identifies a write. Saves may be tailed as inserts or updates,
+// so both are identified as updates.
+func writeKey(k data.Kind, id data.ID, ck data.ChangeKind) string {
+	if ck == data.Create {
+		ck = data.Update
+	}
+
+	return fmt.Sprintf("%s/%s/%d", k, id, ck)
+}
+
+// record remembers a write, it must be called
+// before the write is made, lest it be tailed first
+func (w *writes) record(key string) {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	w.pending[key] = append(w.pending[key], time.Now())
+}
+
+// forget forgets a write, which failed
+func (w *writes) forget(key string) {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	if ts := w.pending[key]; len(ts) > 0 {
+		w.pending[key] = ts[1:]
+	}
+
+	if len(w.pending[key]) == 0 {
+		delete(w.pending, key)
+	}
+}
+
+// seen reports whether the write was made by the DB, forgetting it.
+// Writes older than the window are forgotten.
+func (w *writes) seen(key string) bool {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	expired := time.Now().Add(-w.window)
+	for k, ts := range w.pending {
+		for len(ts) > 0 && ts[0].Before(expired) {
+			ts = ts[1:]
+		}
+
+		if len(ts) == 0 {
+			delete(w.pending, k)
+		} else {
+			w.pending[k] = ts
+		}
+	}
+
+	ts, ok := w.pending[key]
+	if !ok {
+		return false
+	}
+
+	if len(ts) == 1 {
+		delete(w.pending, key)
+	} else {
+		w.pending[key] = ts[1:]
+	}
+
+	return true
+}
+
+// }}}
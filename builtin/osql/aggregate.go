@@ -0,0 +1,197 @@
+package osql
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+
+	"github.com/elos/data"
+	"golang.org/x/net/context"
+)
+
+// The aggregates are evaluated by the database, with the aggregate
+// functions of SQL, over the rows the query matches, disregarding
+// its order, skip and limit.
+
+func (q *Query) Count() (int, error) {
+	return q.CountContext(context.Background())
+}
+
+func (q *Query) CountContext(ctx context.Context) (int, error) {
+	var n int64
+	err := q.scalar(ctx, "count", "COUNT(*)", &n)
+	return int(n), err
+}
+
+func (q *Query) Exists() (bool, error) {
+	return q.ExistsContext(context.Background())
+}
+
+func (q *Query) ExistsContext(ctx context.Context) (bool, error) {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	_, stmt, args, err := q.aggregate("SELECT 1")
+	if err != nil {
+		return false, wrap(err, "count", q.kind, "")
+	}
+
+	rows, err := q.ext.QueryxContext(ctx, q.ext.Rebind(stmt+" LIMIT 1"), args...)
+	if err != nil {
+		return false, wrap(err, "count", q.kind, "")
+	}
+	defer rows.Close()
+
+	exists := rows.Next()
+	return exists, wrap(rows.Err(), "count", q.kind, "")
+}
+
+func (q *Query) Sum(field string) (float64, error) {
+	return q.SumContext(context.Background(), field)
+}
+
+func (q *Query) SumContext(ctx context.Context, field string) (float64, error) {
+	var sum sql.NullFloat64
+	if err := q.scalar(ctx, "aggregate", fmt.Sprintf("SUM(%s)", quote(field)), &sum); err != nil {
+		return 0, err
+	}
+
+	// the SUM of no rows is NULL
+	return sum.Float64, nil
+}
+
+func (q *Query) Min(field string) (float64, error) {
+	return q.MinContext(context.Background(), field)
+}
+
+func (q *Query) MinContext(ctx context.Context, field string) (float64, error) {
+	return q.numeric(ctx, "MIN", field)
+}
+
+func (q *Query) Max(field string) (float64, error) {
+	return q.MaxContext(context.Background(), field)
+}
+
+func (q *Query) MaxContext(ctx context.Context, field string) (float64, error) {
+	return q.numeric(ctx, "MAX", field)
+}
+
+func (q *Query) Avg(field string) (float64, error) {
+	return q.AvgContext(context.Background(), field)
+}
+
+func (q *Query) AvgContext(ctx context.Context, field string) (float64, error) {
+	return q.numeric(ctx, "AVG", field)
+}
+
+func (q *Query) GroupBy(field string) ([]data.Group, error) {
+	return q.GroupByContext(context.Background(), field)
+}
+
+// GroupByContext groups the rows by the column of the field. Its values
+// are decoded as they are for the field of a record, so that booleans
+// stored as integers are booleans, and arrays and objects are decoded
+// from json, as though the records were grouped.
+func (q *Query) GroupByContext(ctx context.Context, field string) ([]data.Group, error) {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	m, stmt, args, err := q.aggregate(fmt.Sprintf("SELECT %s, COUNT(*)", quote(field)))
+	if err != nil {
+		return nil, wrap(err, "aggregate", q.kind, "")
+	}
+
+	// the type of the field, if the model constructs records
+	var t reflect.Type
+	if m.New != nil {
+		t = fields(reflect.TypeOf(m.New()))[field]
+	}
+
+	rows, err := q.ext.QueryxContext(ctx, q.ext.Rebind(stmt+" GROUP BY "+quote(field)), args...)
+	if err != nil {
+		return nil, wrap(err, "aggregate", q.kind, "")
+	}
+	defer rows.Close()
+
+	groups := make([]data.Group, 0)
+	for rows.Next() {
+		var (
+			v interface{}
+			n int64
+		)
+
+		if err := rows.Scan(&v, &n); err != nil {
+			return nil, wrap(err, "aggregate", q.kind, "")
+		}
+
+		if b, ok := v.([]byte); ok {
+			v = string(b)
+		}
+
+		if v != nil && t != nil {
+			if v, err = decodeColumn(v, t); err != nil {
+				return nil, wrap(err, "aggregate", q.kind, "")
+			}
+		}
+
+		// numbers are float64, as though decoded from json
+		if i, ok := v.(int64); ok {
+			v = float64(i)
+		}
+
+		groups = append(groups, data.Group{Value: v, Count: int(n)})
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, wrap(err, "aggregate", q.kind, "")
+	}
+
+	data.SortGroups(groups)
+	return groups, nil
+}
+
+// aggregate builds the statement which selects the columns, such as
+// aggregate functions, of the rows the query matches, and returns
+// the model of the query's kind
+func (q *Query) aggregate(columns string) (*data.Model, string, []interface{}, error) {
+	m, err := q.db.schema.Model(q.kind)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	where, args, err := q.conditions()
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	return m, fmt.Sprintf("%s FROM %s%s", columns, quote(m.Storage), where), args, nil
+}
+
+// scalar selects the expression of the rows the query matches into dest
+func (q *Query) scalar(ctx context.Context, op, expr string, dest interface{}) error {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	_, stmt, args, err := q.aggregate("SELECT " + expr)
+	if err != nil {
+		return wrap(err, op, q.kind, "")
+	}
+
+	err = q.ext.QueryRowxContext(ctx, q.ext.Rebind(stmt), args...).Scan(dest)
+	return wrap(err, op, q.kind, "")
+}
+
+// numeric aggregates the field by the function fn, which is
+// NULL, and so data.ErrNotFound, if there are no values
+func (q *Query) numeric(ctx context.Context, fn, field string) (float64, error) {
+	var v sql.NullFloat64
+	if err := q.scalar(ctx, "aggregate", fmt.Sprintf("%s(%s)", fn, quote(field)), &v); err != nil {
+		return 0, err
+	}
+
+	if !v.Valid {
+		return 0, wrap(data.ErrNotFound, "aggregate", q.kind, "")
+	}
+
+	return v.Float64, nil
+}
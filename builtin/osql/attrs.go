@@ -0,0 +1,199 @@
+package osql
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/elos/data"
+	"github.com/elos/data/transfer"
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// value converts a json attribute to a value for a SQL statement.
+// Arrays and objects are encoded as json text, and whole numbers
+// are passed as integers so that they suit integer columns.
+func value(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case []interface{}, map[string]interface{}:
+		bytes, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		return string(bytes), nil
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < math.MaxInt64 {
+			return int64(v), nil
+		}
+		return v, nil
+	default:
+		return v, nil
+	}
+}
+
+// normalize converts a go value, such as the value of a Select,
+// to a value for a SQL statement, by way of its json attribute.
+func normalize(v interface{}) (interface{}, error) {
+	var attr interface{}
+	if err := transfer.TransferAttrs(v, &attr); err != nil {
+		return nil, err
+	}
+	return value(attr)
+}
+
+// values converts the record to the values of the columns,
+// in order. Columns which the record lacks are NULL.
+func values(r data.Record, columns []string, idColumn string) ([]interface{}, error) {
+	id, err := ID(r.ID().String())
+	if err != nil {
+		return nil, data.ErrInvalidID
+	}
+
+	attrs := make(data.AttrMap)
+	if err := transfer.TransferAttrs(r, &attrs); err != nil {
+		return nil, err
+	}
+
+	vs := make([]interface{}, len(columns))
+	for i, c := range columns {
+		if c == idColumn {
+			vs[i] = id
+			continue
+		}
+
+		if vs[i], err = value(attrs[c]); err != nil {
+			return nil, err
+		}
+	}
+
+	return vs, nil
+}
+
+// fields maps the json attribute names of the struct type t
+// to the types of the fields they are decoded into.
+func fields(t reflect.Type) map[string]reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	fs := make(map[string]reflect.Type)
+	if t.Kind() != reflect.Struct {
+		return fs
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+
+		name := f.Name
+		if tag := f.Tag.Get("json"); tag != "" {
+			if tag == "-" {
+				continue
+			}
+			if n := strings.Split(tag, ",")[0]; n != "" {
+				name = n
+			}
+		}
+
+		// embedded structs without a name are flattened by encoding/json
+		if f.Anonymous && f.Tag.Get("json") == "" {
+			for n, ft := range fields(f.Type) {
+				if _, ok := fs[n]; !ok {
+					fs[n] = ft
+				}
+			}
+			continue
+		}
+
+		if f.PkgPath != "" { // unexported
+			continue
+		}
+
+		fs[name] = f.Type
+	}
+
+	return fs
+}
+
+// decodeColumn reverses the conversion, made by values, of the value v
+// of the column of a field of type t: booleans stored as integers are
+// booleans, and arrays, maps and structs stored as json are decoded
+func decodeColumn(v interface{}, t reflect.Type) (interface{}, error) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		if i, ok := v.(int64); ok {
+			v = i != 0
+		}
+	case reflect.Slice, reflect.Array, reflect.Map, reflect.Struct:
+		s, ok := v.(string)
+		if !ok || t == timeType || t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8 {
+			break
+		}
+
+		var decoded interface{}
+		if err := json.Unmarshal([]byte(s), &decoded); err != nil {
+			return nil, err
+		}
+		v = decoded
+	}
+
+	return v, nil
+}
+
+// hydrate populates the record from a row, reversing the
+// conversions made by values. The record is first zeroed, so
+// that none of its previous attributes remain.
+func hydrate(row map[string]interface{}, r data.Record, idColumn string) error {
+	if v := reflect.ValueOf(r); v.Kind() == reflect.Ptr && !v.IsNil() {
+		v.Elem().Set(reflect.Zero(v.Elem().Type()))
+	}
+
+	fs := fields(reflect.TypeOf(r))
+	attrs := make(data.AttrMap)
+
+	for c, v := range row {
+		if b, ok := v.([]byte); ok {
+			v = string(b)
+		}
+
+		if v == nil {
+			continue
+		}
+
+		if c == idColumn {
+			continue
+		}
+
+		t, ok := fs[c]
+		if !ok {
+			continue
+		}
+
+		v, err := decodeColumn(v, t)
+		if err != nil {
+			return err
+		}
+
+		attrs[c] = v
+	}
+
+	if _, err := transfer.UnmarshalAttrs(attrs, r); err != nil {
+		return err
+	}
+
+	if id, ok := row[idColumn]; ok {
+		if b, ok := id.([]byte); ok {
+			id = string(b)
+		}
+		r.SetID(data.ID(fmt.Sprint(id)))
+	}
+
+	return nil
+}
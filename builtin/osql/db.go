@@ -1,23 +1,56 @@
 package osql
 
 import (
+	"crypto/rand"
 	"database/sql"
 	"errors"
+	"fmt"
+	"math"
+	"math/big"
 	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/elos/data"
 	"github.com/jmoiron/sqlx"
+	"golang.org/x/net/context"
 )
 
 type (
 	Opts struct {
 		Database   *sql.DB
 		DriverName string
+
+		// Schema determines the tables of kinds,
+		// if nil the DB starts with an empty schema
+		Schema *data.Schema
+
+		// Journal, if not empty, is the table in which the
+		// changes are journaled, so that subscribers may
+		// resume with ChangesSince
+		Journal string
 	}
 
+	// DB is a data.DB backed by a SQL database.
+	//
+	// Records are persisted by their json attributes, one per column,
+	// so a table must have a column for every attribute it is to store,
+	// named as the attribute. Numbers, strings and bools are stored as
+	// is, while arrays and objects are stored as json encoded text.
+	//
+	// The column of the model's IDField (by default "id") stores the
+	// record's ID. IDs are integers, so it should be an integer primary key.
+	//
+	// The unique fields of the model must be UNIQUE columns of the table.
+	// The DB doesn't create them, but it names the field of a violation.
 	DB struct {
 		*sqlx.DB
-		tables map[data.Kind]string
+		schema *data.Schema
+
+		m       sync.Mutex
+		columns map[string][]string
+
+		hub *data.ChangeHub
 	}
 )
 
@@ -34,18 +67,99 @@ func New(opts *Opts) (*DB, error) {
 
 	db := sqlx.NewDb(opts.Database, opts.DriverName)
 
+	schema := opts.Schema
+	if schema == nil {
+		schema = data.NewSchema()
+	}
+
+	var hub *data.ChangeHub
+	if opts.Journal != "" {
+		j, err := NewJournal(db, opts.Journal, schema)
+		if err != nil {
+			return nil, err
+		}
+		hub = data.NewJournaledChangeHub(context.TODO(), j)
+	} else {
+		hub = data.NewChangeHub(context.TODO())
+	}
+
 	return &DB{
-		DB:     db,
-		tables: make(map[data.Kind]string),
+		DB:      db,
+		schema:  schema,
+		columns: make(map[string][]string),
+		hub:     hub,
 	}, nil
 }
 
+// RegisterKind registers the kind with the DB's schema, to be stored in the table
 func (db *DB) RegisterKind(k data.Kind, tableName string) {
-	db.tables[k] = tableName
+	db.schema.Register(&data.Model{
+		Kind:    k,
+		Storage: tableName,
+	})
+}
+
+func (db *DB) Schema() *data.Schema {
+	return db.schema
 }
 
+// model retrieves the model registered for the kind k, and the
+// columns of its table. The columns are the model's fields, and
+// its IDField, or if the model has no fields, those of the table.
+func (db *DB) model(k data.Kind) (*data.Model, []string, error) {
+	m, err := db.schema.Model(k)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(m.Fields) == 0 {
+		columns, err := db.tableColumns(m.Storage)
+		return m, columns, err
+	}
+
+	columns := []string{m.IDField}
+	for _, f := range m.Fields {
+		if f != m.IDField {
+			columns = append(columns, f)
+		}
+	}
+
+	return m, columns, nil
+}
+
+// tableColumns retrieves, and caches, the names of the columns of the table
+func (db *DB) tableColumns(table string) ([]string, error) {
+	db.m.Lock()
+	defer db.m.Unlock()
+
+	if columns, ok := db.columns[table]; ok {
+		return columns, nil
+	}
+
+	rows, err := db.DB.Queryx(fmt.Sprintf("SELECT * FROM %s LIMIT 0", quote(table)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	db.columns[table] = columns
+	return columns, nil
+}
+
+// NewID generates a random, positive, 63 bit integer ID. IDs are generated
+// without consulting the database, so that records can be given an ID before
+// they are saved, as with the other builtins.
 func (db *DB) NewID() data.ID {
-	return data.ID("temp")
+	n, err := rand.Int(rand.Reader, big.NewInt(math.MaxInt64))
+	if err != nil {
+		panic(fmt.Sprintf("data/builtin/sql: generating id: %v", err))
+	}
+	return data.ID(strconv.FormatInt(n.Int64()+1, 10))
 }
 
 func ID(s string) (int64, error) {
@@ -55,10 +169,31 @@ func ID(s string) (int64, error) {
 func (db *DB) ParseID(s string) (data.ID, error) {
 	_, err := ID(s)
 	if err != nil {
-		return data.ID(s), data.ErrInvalidID
+		return data.ID(s), wrap(data.ErrInvalidID, "parse id", "", data.ID(s))
 	} else {
 		return data.ID(s), nil
 	}
 }
 
+// data.DB implementation
+func (db *DB) Changes() *chan *data.Change {
+	return db.hub.Changes()
+}
+
+// data.Unsubscriber implementation
+func (db *DB) Unsubscribe(c *chan *data.Change) {
+	db.hub.Unsubscribe(c)
+}
+
+// data.Resumer implementation, it returns data.ErrNoJournal
+// unless the DB was constructed with a Journal table
+func (db *DB) ChangesSince(seq uint64) (*chan *data.Change, error) {
+	return db.hub.ChangesSince(seq)
+}
+
 // }}}
+
+// quote quotes a SQL identifier, such as a table or column name
+func quote(identifier string) string {
+	return `"` + strings.Replace(identifier, `"`, `""`, -1) + `"`
+}
@@ -0,0 +1,305 @@
+package osql_test
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/elos/data"
+	"github.com/elos/data/builtin/osql"
+	"github.com/elos/data/dbtest"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const schema = `
+CREATE TABLE dbtest_records (
+	id INTEGER PRIMARY KEY,
+	name TEXT,
+	count INTEGER,
+	tags TEXT,
+	note TEXT,
+	flag INTEGER -- a boolean, as sqlite stores them
+);
+
+CREATE TABLE dbtest_versioned_records (
+	id INTEGER PRIMARY KEY,
+	name TEXT,
+	version INTEGER
+);
+
+CREATE TABLE dbtest_unique_records (
+	id INTEGER PRIMARY KEY,
+	"key" TEXT UNIQUE,
+	name TEXT
+)`
+
+var (
+	dir       string
+	databases = 0
+)
+
+func TestMain(m *testing.M) {
+	var err error
+	if dir, err = ioutil.TempDir("", "osql_test"); err != nil {
+		panic(err)
+	}
+
+	i := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(i)
+}
+
+// newDB opens a new, empty, sqlite database. The databases are
+// files, rather than in memory, so that transactions are isolated.
+func newDB() (*osql.DB, error) {
+	databases++
+	sqlDB, err := sql.Open("sqlite3", filepath.Join(dir, fmt.Sprintf("%d.db", databases)))
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := sqlDB.Exec(schema); err != nil {
+		return nil, err
+	}
+
+	db, err := osql.New(&osql.Opts{
+		Database:   sqlDB,
+		DriverName: "sqlite3",
+		Journal:    "changes",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.Schema().Register(dbtest.Model("dbtest_records")); err != nil {
+		return nil, err
+	}
+
+	if err := db.Schema().Register(dbtest.VersionedModel("dbtest_versioned_records")); err != nil {
+		return nil, err
+	}
+
+	return db, db.Schema().Register(dbtest.UniqueModel("dbtest_unique_records"))
+}
+
+func TestConformance(t *testing.T) {
+	constructor := func() (data.DB, error) {
+		return newDB()
+	}
+
+	t.Run("IDs", func(t *testing.T) { dbtest.TestIDs(t, constructor) })
+	t.Run("Save", func(t *testing.T) { dbtest.TestSave(t, constructor) })
+	t.Run("Delete", func(t *testing.T) { dbtest.TestDelete(t, constructor) })
+	t.Run("Populate", func(t *testing.T) { dbtest.TestPopulate(t, constructor) })
+	t.Run("Query", func(t *testing.T) { dbtest.TestQuery(t, constructor) })
+	t.Run("Iterators", func(t *testing.T) { dbtest.TestIterators(t, constructor) })
+	t.Run("Aggregates", func(t *testing.T) { dbtest.TestAggregates(t, constructor) })
+	t.Run("Projection", func(t *testing.T) { dbtest.TestProjection(t, constructor) })
+	t.Run("Predicates", func(t *testing.T) { dbtest.TestPredicates(t, constructor) })
+	// not TestDocumentPredicates, columns hold scalars, arrays are stored as json
+	t.Run("Changes", func(t *testing.T) { dbtest.TestChanges(t, constructor) })
+	t.Run("IdleSubscriber", func(t *testing.T) { dbtest.TestIdleSubscriber(t, constructor) })
+	t.Run("Journal", func(t *testing.T) { dbtest.TestJournal(t, constructor) })
+	t.Run("Versions", func(t *testing.T) { dbtest.TestVersions(t, constructor) })
+	t.Run("Unique", func(t *testing.T) { dbtest.TestUnique(t, constructor) })
+	t.Run("Transactions", func(t *testing.T) { dbtest.TestTransactions(t, constructor) })
+	t.Run("Context", func(t *testing.T) { dbtest.TestContext(t, constructor) })
+}
+
+// valueRecord is a record which is not a pointer
+type valueRecord struct {
+	Id string `json:"id"`
+}
+
+func (r valueRecord) Kind() data.Kind  { return dbtest.RecordKind }
+func (r valueRecord) ID() data.ID      { return data.ID(r.Id) }
+func (r valueRecord) SetID(id data.ID) {}
+
+func TestSaveValue(t *testing.T) {
+	db, err := newDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Save(valueRecord{Id: "1"}); err == nil {
+		t.Error("db.Save of a value: got no error")
+	}
+}
+
+func TestInvalidID(t *testing.T) {
+	db, err := newDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &dbtest.Record{Id: "not an integer"}
+
+	if got, want := db.Save(r), data.ErrInvalidID; !errors.Is(got, want) {
+		t.Errorf("db.Save: got %v, want %v", got, want)
+	}
+
+	if got, want := db.Delete(r), data.ErrInvalidID; !errors.Is(got, want) {
+		t.Errorf("db.Delete: got %v, want %v", got, want)
+	}
+
+	if got, want := db.PopulateByID(r), data.ErrInvalidID; !errors.Is(got, want) {
+		t.Errorf("db.PopulateByID: got %v, want %v", got, want)
+	}
+
+	if _, err := db.ParseID("not an integer"); !errors.Is(err, data.ErrInvalidID) {
+		t.Errorf("db.ParseID: got %v, want %v", err, data.ErrInvalidID)
+	}
+}
+
+func TestClearedAttributes(t *testing.T) {
+	db, err := newDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &dbtest.Record{Name: "cleared", Note: "note", Tags: []string{"tag"}}
+	r.SetID(db.NewID())
+	if err := db.Save(r); err != nil {
+		t.Fatalf("db.Save error: %v", err)
+	}
+
+	r.Note = ""
+	r.Tags = nil
+	if err := db.Save(r); err != nil {
+		t.Fatalf("db.Save error: %v", err)
+	}
+
+	populated := &dbtest.Record{Id: r.Id, Note: "stale"}
+	if err := db.PopulateByID(populated); err != nil {
+		t.Fatalf("db.PopulateByID error: %v", err)
+	}
+
+	if got, want := populated.Note, ""; got != want {
+		t.Errorf("populated.Note: got %q, want %q", got, want)
+	}
+
+	if got, want := len(populated.Tags), 0; got != want {
+		t.Errorf("len(populated.Tags): got %d, want %d", got, want)
+	}
+}
+
+func TestUnregisteredKind(t *testing.T) {
+	db, err := newDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &unregistered{}
+	r.SetID(db.NewID())
+
+	if got, want := db.Save(r), data.ErrUnregisteredKind; !errors.Is(got, want) {
+		t.Errorf("db.Save: got %v, want %v", got, want)
+	}
+
+	if got, want := db.Delete(r), data.ErrUnregisteredKind; !errors.Is(got, want) {
+		t.Errorf("db.Delete: got %v, want %v", got, want)
+	}
+
+	if got, want := db.PopulateByID(r), data.ErrUnregisteredKind; !errors.Is(got, want) {
+		t.Errorf("db.PopulateByID: got %v, want %v", got, want)
+	}
+
+	if _, err := db.Query(r.Kind()).Execute(); !errors.Is(err, data.ErrUnregisteredKind) {
+		t.Errorf("db.Query: got %v, want %v", err, data.ErrUnregisteredKind)
+	}
+}
+
+func TestSchemaFields(t *testing.T) {
+	s := data.NewSchema()
+	s.Register(&data.Model{
+		Kind:    dbtest.RecordKind,
+		New:     func() data.Record { return new(dbtest.Record) },
+		Storage: "dbtest_records",
+		Fields:  []string{"name", "count"},
+	})
+
+	sqlDB, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+
+	if _, err := sqlDB.Exec(schema); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := osql.New(&osql.Opts{
+		Database:   sqlDB,
+		DriverName: "sqlite3",
+		Schema:     s,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &dbtest.Record{Name: "fields", Count: 2, Note: "not a field"}
+	r.SetID(db.NewID())
+	if err := db.Save(r); err != nil {
+		t.Fatalf("db.Save error: %v", err)
+	}
+
+	loaded, err := s.Load(db, dbtest.RecordKind, r.ID())
+	if err != nil {
+		t.Fatalf("s.Load error: %v", err)
+	}
+
+	got := loaded.(*dbtest.Record)
+
+	if got.Name != "fields" || got.Count != 2 {
+		t.Errorf("loaded: got %+v, want name and count of %+v", got, r)
+	}
+
+	if got.Note != "" {
+		t.Errorf("loaded.Note: got %q, want the unlisted field to be empty", got.Note)
+	}
+}
+
+type unregistered struct {
+	Id string `json:"id"`
+}
+
+func (u *unregistered) ID() data.ID      { return data.ID(u.Id) }
+func (u *unregistered) SetID(id data.ID) { u.Id = id.String() }
+func (u *unregistered) Kind() data.Kind  { return "unregistered" }
+
+func TestDuplicateKey(t *testing.T) {
+	db, err := newDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.Exec("CREATE UNIQUE INDEX dbtest_records_name ON dbtest_records (name)"); err != nil {
+		t.Fatal(err)
+	}
+
+	one, two := &dbtest.Record{Name: "same"}, &dbtest.Record{Name: "same"}
+	one.SetID(db.NewID())
+	two.SetID(db.NewID())
+
+	if err := db.Save(one); err != nil {
+		t.Fatalf("db.Save error: %v", err)
+	}
+
+	err = db.Save(two)
+	if !errors.Is(err, data.ErrDuplicateKey) {
+		t.Fatalf("db.Save: got %v, want %v", err, data.ErrDuplicateKey)
+	}
+
+	var e *data.Error
+	if !errors.As(err, &e) {
+		t.Fatalf("db.Save: got %T, want *data.Error", err)
+	}
+
+	if got, want := e.ID, two.ID(); got != want {
+		t.Errorf("e.ID: got %s, want %s", got, want)
+	}
+}
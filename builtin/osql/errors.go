@@ -0,0 +1,114 @@
+package osql
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"net"
+	"strings"
+	"unicode"
+
+	"github.com/elos/data"
+)
+
+// duplicates are the messages with which the drivers of
+// sqlite, postgres and mysql report unique constraint violations
+var duplicates = []string{
+	"unique constraint failed",
+	"duplicate key value violates unique constraint",
+	"duplicate entry",
+}
+
+// classify maps the errors of database/sql, and of its drivers, to
+// the errors of the data package, so that they may be inspected
+// without knowledge of the database
+func classify(err error) error {
+	switch err {
+	case nil:
+		return nil
+	case sql.ErrNoRows:
+		return data.ErrNotFound
+	case sql.ErrTxDone:
+		return data.ErrTxDone
+	case driver.ErrBadConn:
+		return &data.Error{Err: data.ErrNoConnection, Cause: err}
+	}
+
+	if _, ok := err.(*data.Error); ok {
+		return err
+	}
+
+	if nerr, ok := err.(net.Error); ok {
+		if nerr.Timeout() {
+			return &data.Error{Err: data.ErrTimeout, Cause: err}
+		}
+		return &data.Error{Err: data.ErrNoConnection, Cause: err}
+	}
+
+	msg := strings.ToLower(err.Error())
+	for _, d := range duplicates {
+		if strings.Contains(msg, d) {
+			return &data.Error{Err: data.ErrDuplicateKey, Cause: err}
+		}
+	}
+
+	return err
+}
+
+// constraints are the phrases after which the drivers of sqlite,
+// postgres and mysql name the violated unique constraint
+var constraints = []string{
+	"unique constraint failed:",
+	"violates unique constraint",
+	"for key",
+}
+
+// duplicate classifies the error of a write of a record of the model
+// m, and if it violated a unique constraint, determines the field of
+// the model it constrains
+func duplicate(err error, m *data.Model) error {
+	e, ok := classify(err).(*data.Error)
+	if !ok || e.Err != data.ErrDuplicateKey || e.Field != "" {
+		return err
+	}
+
+	e.Field = violated(err, m)
+	return e
+}
+
+// violated determines the unique field of the model m whose constraint
+// the error err violated. A constraint is recognized by its column, as
+// sqlite reports it, or by the names postgres, and conventionally
+// mysql, give the constraint of a column: <table>_<column>_key, or
+// <table>_<column>. It returns "" if the field can't be determined.
+func violated(err error, m *data.Model) string {
+	msg := strings.ToLower(err.Error())
+
+	var named string
+	for _, c := range constraints {
+		if i := strings.LastIndex(msg, c); i >= 0 {
+			named = msg[i+len(c):]
+			break
+		}
+	}
+
+	identifiers := strings.FieldsFunc(named, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	})
+
+	table := strings.ToLower(m.Storage)
+	for _, f := range m.Unique {
+		column := strings.ToLower(f)
+		for _, id := range identifiers {
+			if id == column || id == table+"_"+column || id == table+"_"+column+"_key" {
+				return f
+			}
+		}
+	}
+
+	return ""
+}
+
+// wrap classifies the error of the operation op, and wraps it in a data.Error
+func wrap(err error, op string, k data.Kind, id data.ID) error {
+	return data.WrapError(classify(err), op, k, id)
+}
@@ -0,0 +1,115 @@
+package osql
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/elos/data"
+	"github.com/jmoiron/sqlx"
+)
+
+// Journal is a data.Journal which persists changes in a table.
+//
+// The records of the changes are stored as json, and are decoded
+// into records constructed by the schema, so the kinds must be
+// registered with a Model whose New is not nil to be replayed.
+//
+// The journal is not truncated, prune the table as need be.
+type Journal struct {
+	db     *sqlx.DB
+	table  string
+	schema *data.Schema
+
+	m   sync.Mutex
+	seq uint64
+}
+
+// NewJournal constructs a Journal, creating the table unless it
+// exists. The journal continues the sequence of the changes already
+// in the table.
+func NewJournal(db *sqlx.DB, table string, s *data.Schema) (*Journal, error) {
+	create := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	seq BIGINT PRIMARY KEY,
+	kind TEXT NOT NULL,
+	change INTEGER NOT NULL,
+	record TEXT NOT NULL
+)`, quote(table))
+
+	if _, err := db.Exec(create); err != nil {
+		return nil, err
+	}
+
+	var seq sql.NullInt64
+	if err := db.QueryRowx(fmt.Sprintf("SELECT MAX(seq) FROM %s", quote(table))).Scan(&seq); err != nil {
+		return nil, err
+	}
+
+	return &Journal{
+		db:     db,
+		table:  table,
+		schema: s,
+		seq:    uint64(seq.Int64),
+	}, nil
+}
+
+// data.Journal implementation
+func (j *Journal) Append(c *data.Change) error {
+	record, err := json.Marshal(c.Record)
+	if err != nil {
+		return err
+	}
+
+	j.m.Lock()
+	defer j.m.Unlock()
+
+	stmt := fmt.Sprintf("INSERT INTO %s (seq, kind, change, record) VALUES (?, ?, ?, ?)", quote(j.table))
+	if _, err := j.db.Exec(j.db.Rebind(stmt), j.seq+1, string(c.Record.Kind()), int(c.ChangeKind), string(record)); err != nil {
+		return err
+	}
+
+	j.seq++
+	c.Seq = j.seq
+	return nil
+}
+
+// data.Journal implementation
+func (j *Journal) Since(seq uint64) ([]*data.Change, error) {
+	stmt := fmt.Sprintf("SELECT seq, kind, change, record FROM %s WHERE seq > ? ORDER BY seq", quote(j.table))
+	rows, err := j.db.Queryx(j.db.Rebind(stmt), seq)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var changes []*data.Change
+
+	for rows.Next() {
+		var (
+			s      uint64
+			kind   string
+			change int
+			record string
+		)
+
+		if err := rows.Scan(&s, &kind, &change, &record); err != nil {
+			return nil, err
+		}
+
+		r, err := j.schema.New(data.Kind(kind))
+		if err != nil {
+			return nil, err
+		}
+
+		if err := json.Unmarshal([]byte(record), r); err != nil {
+			return nil, err
+		}
+
+		c := data.NewChange(data.ChangeKind(change), r)
+		c.Seq = s
+		changes = append(changes, c)
+	}
+
+	return changes, rows.Err()
+}
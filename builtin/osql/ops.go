@@ -3,32 +3,333 @@ package osql
 import (
 	"database/sql"
 	"fmt"
+	"reflect"
+	"strings"
 
 	"github.com/elos/data"
+	"github.com/elos/data/transfer"
+	"github.com/jmoiron/sqlx"
+	"golang.org/x/net/context"
 )
 
+// upsert builds the statement which inserts a row into the table,
+// or updates the row should one already exist with the same id.
+func upsert(table string, columns []string, idColumn string) string {
+	quoted := make([]string, len(columns))
+	binds := make([]string, len(columns))
+	updates := make([]string, 0, len(columns))
+
+	for i, c := range columns {
+		quoted[i] = quote(c)
+		binds[i] = "?"
+		if c != idColumn {
+			updates = append(updates, fmt.Sprintf("%s = excluded.%s", quote(c), quote(c)))
+		}
+	}
+
+	stmt := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) ON CONFLICT (%s)",
+		quote(table), strings.Join(quoted, ", "), strings.Join(binds, ", "), quote(idColumn))
+
+	if len(updates) == 0 {
+		return stmt + " DO NOTHING"
+	}
+
+	return stmt + " DO UPDATE SET " + strings.Join(updates, ", ")
+}
+
+// insert builds the statement which inserts a row into
+// the table, unless a row already exists with the same id
+func insert(table string, columns []string, idColumn string) string {
+	quoted := make([]string, len(columns))
+	binds := make([]string, len(columns))
+
+	for i, c := range columns {
+		quoted[i] = quote(c)
+		binds[i] = "?"
+	}
+
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) ON CONFLICT (%s) DO NOTHING",
+		quote(table), strings.Join(quoted, ", "), strings.Join(binds, ", "), quote(idColumn))
+}
+
+// update builds the statement which updates the row with an id,
+// only if it is at a version. The columns are set in order, save
+// the id column, and then the id and version are bound.
+func update(table string, columns []string, idColumn, versionColumn string) string {
+	sets := make([]string, 0, len(columns))
+
+	for _, c := range columns {
+		if c != idColumn {
+			sets = append(sets, fmt.Sprintf("%s = ?", quote(c)))
+		}
+	}
+
+	return fmt.Sprintf("UPDATE %s SET %s WHERE %s = ? AND %s = ?",
+		quote(table), strings.Join(sets, ", "), quote(idColumn), quote(versionColumn))
+}
+
+// ext is a database, or a transaction, which executes statements
+type ext interface {
+	sqlx.ExtContext
+	Rebind(string) string
+}
+
+// save upserts the record, and returns the change it makes. The save of
+// a Versioned record is conditional, it inserts the record if its version
+// is 0, and otherwise updates the row at its version.
+func (db *DB) save(ctx context.Context, e ext, r data.Record) (c *data.Change, err error) {
+	id, err := ID(r.ID().String())
+	if err != nil {
+		return nil, data.ErrInvalidID
+	}
+
+	// the previous record, if any, is read into another of r's type
+	previous, err := newOf(r)
+	if err != nil {
+		return nil, err
+	}
+
+	m, columns, err := db.model(r.Kind())
+	if err != nil {
+		return nil, err
+	}
+
+	v, versioned := r.(data.Versioned)
+	if versioned && !contains(columns, m.VersionField) {
+		return nil, fmt.Errorf("data/builtin/sql: table %s has no %s column for versions", m.Storage, m.VersionField)
+	}
+
+	version := 0
+	if versioned {
+		version = v.Version()
+		v.SetVersion(version + 1)
+
+		// should the save fail, r is left at the version it was
+		defer func() {
+			if err != nil {
+				v.SetVersion(version)
+			}
+		}()
+	}
+
+	vs, err := values(r, columns, m.IDField)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt := fmt.Sprintf("SELECT * FROM %s WHERE %s = ?", quote(m.Storage), quote(m.IDField))
+	err = populate(ctx, e, previous, m, stmt, id)
+	existed := err == nil
+	if err != nil && err != data.ErrNotFound {
+		return nil, err
+	}
+
+	switch {
+	case !versioned:
+		_, err = e.ExecContext(ctx, e.Rebind(upsert(m.Storage, columns, m.IDField)), vs...)
+	case version == 0:
+		err = conditional(e.ExecContext(ctx, e.Rebind(insert(m.Storage, columns, m.IDField)), vs...))
+	default:
+		args := make([]interface{}, 0, len(vs)+1)
+		for i, c := range columns {
+			if c != m.IDField {
+				args = append(args, vs[i])
+			}
+		}
+		args = append(args, id, version)
+
+		err = conditional(e.ExecContext(ctx, e.Rebind(update(m.Storage, columns, m.IDField, m.VersionField)), args...))
+	}
+
+	if err != nil {
+		return nil, duplicate(err, m)
+	}
+
+	if !existed {
+		return data.NewCreate(r), nil
+	}
+
+	d, err := diff(previous, r, columns)
+	if err != nil {
+		return nil, err
+	}
+
+	c = data.NewUpdate(r)
+	c.Previous = previous
+	c.Diff = d
+	return c, nil
+}
+
+// conditional checks the result of a conditional write, which
+// conflicts if it affects no row
+func conditional(result sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	switch {
+	case err != nil:
+		return err
+	case n == 0:
+		return data.ErrConflict
+	default:
+		return nil
+	}
+}
+
+func contains(columns []string, column string) bool {
+	for _, c := range columns {
+		if c == column {
+			return true
+		}
+	}
+	return false
+}
+
+// diff computes the diff of the columns of the record, from old to new
+func diff(old, new data.Record, columns []string) (data.Diff, error) {
+	oldAttrs, err := transfer.Attrs(old)
+	if err != nil {
+		return nil, err
+	}
+
+	newAttrs, err := transfer.Attrs(new)
+	if err != nil {
+		return nil, err
+	}
+
+	persisted := make(map[string]bool, len(columns))
+	for _, c := range columns {
+		persisted[c] = true
+	}
+
+	for _, attrs := range []data.AttrMap{oldAttrs, newAttrs} {
+		for field := range attrs {
+			if !persisted[field] {
+				delete(attrs, field)
+			}
+		}
+	}
+
+	return data.DiffAttrs(oldAttrs, newAttrs), nil
+}
+
 func (db *DB) Save(r data.Record) error {
+	return db.SaveContext(context.Background(), r)
+}
+
+func (db *DB) SaveContext(ctx context.Context, r data.Record) (err error) {
+	defer func() { err = wrap(err, "save", r.Kind(), r.ID()) }()
+
+	// should the transaction fail to commit, r is left at the version it was
+	if v, ok := r.(data.Versioned); ok {
+		version := v.Version()
+		defer func() {
+			if err != nil {
+				v.SetVersion(version)
+			}
+		}()
+	}
+
+	tx, err := db.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	c, err := db.save(ctx, tx, r)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	db.hub.Notify(c)
 	return nil
 }
 
+// delete removes the record, and returns the change it makes
+func (db *DB) delete(ctx context.Context, e ext, r data.Record) (*data.Change, error) {
+	id, err := ID(r.ID().String())
+	if err != nil {
+		return nil, data.ErrInvalidID
+	}
+
+	m, err := db.schema.Model(r.Kind())
+	if err != nil {
+		return nil, err
+	}
+
+	stmt := fmt.Sprintf("DELETE FROM %s WHERE %s = ?", quote(m.Storage), quote(m.IDField))
+	result, err := e.ExecContext(ctx, e.Rebind(stmt), id)
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := result.RowsAffected()
+	switch {
+	case err != nil:
+		return nil, err
+	case n == 0:
+		return nil, data.ErrNotFound
+	}
+
+	return data.NewDelete(r), nil
+}
+
 func (db *DB) Delete(r data.Record) error {
+	return db.DeleteContext(context.Background(), r)
+}
+
+func (db *DB) DeleteContext(ctx context.Context, r data.Record) error {
+	c, err := db.delete(ctx, db.DB, r)
+	if err != nil {
+		return wrap(err, "delete", r.Kind(), r.ID())
+	}
+
+	db.hub.Notify(c)
 	return nil
 }
 
-func (db *DB) PopulateByID(r data.Record) error {
+// populate hydrates the record with the first row the statement selects
+func populate(ctx context.Context, e ext, r data.Record, m *data.Model, stmt string, args ...interface{}) error {
+	rows, err := e.QueryxContext(ctx, e.Rebind(stmt), args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		return data.ErrNotFound
+	}
+
+	row := make(map[string]interface{})
+	if err := rows.MapScan(row); err != nil {
+		return err
+	}
+
+	return hydrate(row, r, m.IDField)
+}
+
+func (db *DB) populateByID(ctx context.Context, e ext, r data.Record) error {
 	id, err := ID(r.ID().String())
 	if err != nil {
 		return data.ErrInvalidID
 	}
 
-	kind := r.Kind()
-	table, ok := db.tables[kind]
-	if !ok {
-		panic(fmt.Sprintf("data/builtin/sql: undefined table for kind: %s", kind))
+	m, err := db.schema.Model(r.Kind())
+	if err != nil {
+		return err
 	}
 
-	stmt := fmt.Sprintf("SELECT * FROM %s WHERE id=?", table)
-	err = db.DB.QueryRowx(stmt, id).StructScan(r)
+	stmt := fmt.Sprintf("SELECT * FROM %s WHERE %s = ?", quote(m.Storage), quote(m.IDField))
+	err = populate(ctx, e, r, m, stmt, id)
 
 	switch {
 	case err == sql.ErrNoRows:
@@ -40,7 +341,61 @@ func (db *DB) PopulateByID(r data.Record) error {
 	}
 }
 
-func (db *DB) PopulateByField(r data.Record) error {
+func (db *DB) PopulateByID(r data.Record) error {
+	return db.PopulateByIDContext(context.Background(), r)
+}
 
-	return nil
+func (db *DB) PopulateByIDContext(ctx context.Context, r data.Record) error {
+	return wrap(db.populateByID(ctx, db.DB, r), "populate", r.Kind(), r.ID())
+}
+
+func (db *DB) populateByField(ctx context.Context, e ext, field string, value interface{}, r data.Record) error {
+	m, err := db.schema.Model(r.Kind())
+	if err != nil {
+		return err
+	}
+
+	v, err := normalize(value)
+	if err != nil {
+		return err
+	}
+
+	stmt := fmt.Sprintf("SELECT * FROM %s WHERE %s = ? LIMIT 1", quote(m.Storage), quote(field))
+	return populate(ctx, e, r, m, stmt, v)
+}
+
+func (db *DB) PopulateByField(field string, value interface{}, r data.Record) error {
+	return db.PopulateByFieldContext(context.Background(), field, value, r)
+}
+
+func (db *DB) PopulateByFieldContext(ctx context.Context, field string, value interface{}, r data.Record) error {
+	return wrap(db.populateByField(ctx, db.DB, field, value, r), "populate", r.Kind(), "")
+}
+
+func (db *DB) query(e ext, k data.Kind) data.Query {
+	return &Query{
+		db:    db,
+		ext:   e,
+		kind:  k,
+		match: data.AttrMap{},
+	}
+}
+
+func (db *DB) Query(k data.Kind) data.Query {
+	return db.query(db.DB, k)
+}
+
+// newOf allocates a record of the concrete type of r
+func newOf(r data.Record) (data.Record, error) {
+	t := reflect.TypeOf(r)
+	if t == nil || t.Kind() != reflect.Ptr {
+		return nil, fmt.Errorf("data/builtin/sql: can not save record of type %T, must be a pointer", r)
+	}
+
+	c, ok := reflect.New(t.Elem()).Interface().(data.Record)
+	if !ok {
+		return nil, fmt.Errorf("data/builtin/sql: can not save record of type %T", r)
+	}
+
+	return c, nil
 }
@@ -0,0 +1,120 @@
+package osql
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/elos/data"
+)
+
+var comparisons = map[data.Operator]string{
+	data.OpGt:  ">",
+	data.OpGte: ">=",
+	data.OpLt:  "<",
+	data.OpLte: "<=",
+}
+
+// predicate translates a data.Predicate to a SQL condition, and its
+// arguments. Missing attributes are NULL, so the negative operators
+// ($ne, $nin, $not) match NULLs, as they would with mongo.
+//
+// Arrays and objects are stored as json text, so unlike the document
+// stores a predicate does not match the elements of an array.
+func predicate(p *data.Predicate) (string, []interface{}, error) {
+	column := quote(p.Field)
+
+	switch p.Op {
+	case data.OpEq, data.OpNe:
+		v, err := normalize(p.Value)
+		if err != nil {
+			return "", nil, err
+		}
+
+		if v == nil {
+			if p.Op == data.OpEq {
+				return column + " IS NULL", nil, nil
+			}
+			return column + " IS NOT NULL", nil, nil
+		}
+
+		if p.Op == data.OpEq {
+			return column + " = ?", []interface{}{v}, nil
+		}
+		return fmt.Sprintf("(%s IS NULL OR %s <> ?)", column, column), []interface{}{v}, nil
+	case data.OpGt, data.OpGte, data.OpLt, data.OpLte:
+		v, err := normalize(p.Value)
+		if err != nil {
+			return "", nil, err
+		}
+		return fmt.Sprintf("%s %s ?", column, comparisons[p.Op]), []interface{}{v}, nil
+	case data.OpIn, data.OpNin:
+		vs, ok := p.Value.([]interface{})
+		if !ok {
+			return "", nil, fmt.Errorf("data/builtin/sql: %s requires a list, got %T", p.Op, p.Value)
+		}
+
+		if len(vs) == 0 {
+			if p.Op == data.OpIn {
+				return "1 = 0", nil, nil
+			}
+			return "1 = 1", nil, nil
+		}
+
+		binds := make([]string, len(vs))
+		args := make([]interface{}, len(vs))
+		for i, v := range vs {
+			n, err := normalize(v)
+			if err != nil {
+				return "", nil, err
+			}
+			binds[i], args[i] = "?", n
+		}
+
+		if p.Op == data.OpIn {
+			return fmt.Sprintf("%s IN (%s)", column, strings.Join(binds, ", ")), args, nil
+		}
+		return fmt.Sprintf("(%s IS NULL OR %s NOT IN (%s))", column, column, strings.Join(binds, ", ")), args, nil
+	case data.OpExists:
+		if p.Value == true {
+			return column + " IS NOT NULL", nil, nil
+		}
+		return column + " IS NULL", nil, nil
+	case data.OpAnd, data.OpOr:
+		if len(p.Operands) == 0 {
+			if p.Op == data.OpAnd {
+				return "1 = 1", nil, nil
+			}
+			return "1 = 0", nil, nil
+		}
+
+		conditions := make([]string, len(p.Operands))
+		var args []interface{}
+		for i, o := range p.Operands {
+			c, a, err := predicate(o)
+			if err != nil {
+				return "", nil, err
+			}
+			conditions[i] = "(" + c + ")"
+			args = append(args, a...)
+		}
+
+		join := " AND "
+		if p.Op == data.OpOr {
+			join = " OR "
+		}
+		return strings.Join(conditions, join), args, nil
+	case data.OpNot:
+		if len(p.Operands) != 1 {
+			return "", nil, fmt.Errorf("data/builtin/sql: %s requires one operand, got %d", p.Op, len(p.Operands))
+		}
+
+		c, args, err := predicate(p.Operands[0])
+		if err != nil {
+			return "", nil, err
+		}
+		// a comparison with NULL is NULL, rather than false
+		return fmt.Sprintf("(%s) IS NOT TRUE", c), args, nil
+	default:
+		return "", nil, fmt.Errorf("data/builtin/sql: unsupported operator %s", p.Op)
+	}
+}
@@ -0,0 +1,297 @@
+package osql
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/elos/data"
+	"github.com/jmoiron/sqlx"
+	"golang.org/x/net/context"
+)
+
+type Query struct {
+	db                 *DB
+	ext                ext
+	kind               data.Kind
+	match              data.AttrMap
+	where              []*data.Predicate
+	limit, skip, batch int
+	order              []string
+	projection         data.Projection
+	m                  sync.Mutex
+}
+
+// statement builds the SELECT statement, and its arguments, for the
+// query, which selects the columns, or every column if they are nil
+func (q *Query) statement(m *data.Model, columns []string) (string, []interface{}, error) {
+	where, args, err := q.conditions()
+	if err != nil {
+		return "", nil, err
+	}
+
+	list := "*"
+	if columns != nil {
+		quoted := make([]string, len(columns))
+		for i, c := range columns {
+			quoted[i] = quote(c)
+		}
+		list = strings.Join(quoted, ", ")
+	}
+
+	stmt := fmt.Sprintf("SELECT %s FROM %s%s", list, quote(m.Storage), where)
+
+	if len(q.order) > 0 {
+		orders := make([]string, len(q.order))
+		for i, f := range q.order {
+			if strings.HasPrefix(f, "-") {
+				orders[i] = quote(f[1:]) + " DESC"
+			} else {
+				orders[i] = quote(f) + " ASC"
+			}
+		}
+		stmt += " ORDER BY " + strings.Join(orders, ", ")
+	}
+
+	if q.limit != 0 || q.skip != 0 {
+		// not every database can OFFSET without a LIMIT
+		limit := int64(q.limit)
+		if limit == 0 {
+			limit = math.MaxInt64
+		}
+		stmt += " LIMIT ? OFFSET ?"
+		args = append(args, limit, q.skip)
+	}
+
+	return stmt, args, nil
+}
+
+// conditions builds the WHERE clause, and its arguments, of the
+// selection and predicates of the query, it is empty if there are none
+func (q *Query) conditions() (string, []interface{}, error) {
+	var (
+		conditions []string
+		args       []interface{}
+	)
+
+	// iterate the match in a stable order, so statements are reproducible
+	fields := make([]string, 0, len(q.match))
+	for f := range q.match {
+		fields = append(fields, f)
+	}
+	sort.Strings(fields)
+
+	for _, f := range fields {
+		c, a, err := predicate(data.Eq(f, q.match[f]))
+		if err != nil {
+			return "", nil, err
+		}
+		conditions = append(conditions, c)
+		args = append(args, a...)
+	}
+
+	for _, p := range q.where {
+		c, a, err := predicate(p)
+		if err != nil {
+			return "", nil, err
+		}
+		conditions = append(conditions, c)
+		args = append(args, a...)
+	}
+
+	if len(conditions) == 0 {
+		return "", args, nil
+	}
+
+	return " WHERE " + strings.Join(conditions, " AND "), args, nil
+}
+
+func (q *Query) Execute() (data.Iterator, error) {
+	return q.ExecuteContext(context.Background())
+}
+
+// ExecuteContext executes the query, the rows of the
+// resulting Iterator are bound to the context.
+func (q *Query) ExecuteContext(ctx context.Context) (data.Iterator, error) {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	m, err := q.db.schema.Model(q.kind)
+	if err != nil {
+		return nil, wrap(err, "query", q.kind, "")
+	}
+
+	var columns []string
+	if q.projection.Restricts() {
+		if columns, err = q.columns(ctx, m); err != nil {
+			return nil, wrap(err, "query", q.kind, "")
+		}
+	}
+
+	stmt, args, err := q.statement(m, columns)
+	if err != nil {
+		return nil, wrap(err, "query", q.kind, "")
+	}
+
+	rows, err := q.ext.QueryxContext(ctx, q.ext.Rebind(stmt), args...)
+	if err != nil {
+		return nil, wrap(err, "query", q.kind, "")
+	}
+
+	return data.IterContext(ctx, newIter(rows, m.IDField)), nil
+}
+
+// columns determines the columns of the model's table which the
+// projection of the query fetches, always including the id column
+func (q *Query) columns(ctx context.Context, m *data.Model) ([]string, error) {
+	// the columns are those of the table, so that the projection
+	// disregards fields the table lacks, as the records would
+	rows, err := q.ext.QueryxContext(ctx, fmt.Sprintf("SELECT * FROM %s LIMIT 0", quote(m.Storage)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	all, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	columns := make([]string, 0, len(all))
+	for _, c := range all {
+		if c == m.IDField || q.projection.Fetches(c) {
+			columns = append(columns, c)
+		}
+	}
+
+	return columns, nil
+}
+
+func (q *Query) Select(am data.AttrMap) data.Query {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	q.match = am
+	return q
+}
+
+func (q *Query) Where(p *data.Predicate) data.Query {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	q.where = append(q.where, p)
+	return q
+}
+
+func (q *Query) Limit(i int) data.Query {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	q.limit = i
+	return q
+}
+
+func (q *Query) Skip(i int) data.Query {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	q.skip = i
+	return q
+}
+
+// Batch has no effect, the driver determines how rows are fetched
+func (q *Query) Batch(i int) data.Query {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	q.batch = i
+	return q
+}
+
+// Order sorts by the fields, in ascending order unless
+// the field is prefixed by a '-', as with mongo.
+func (q *Query) Order(fields ...string) data.Query {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	q.order = fields
+	return q
+}
+
+// Include selects only the columns of the fields, see data.Query
+func (q *Query) Include(fields ...string) data.Query {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	q.projection.Include = append([]string(nil), fields...)
+	return q
+}
+
+func (q *Query) Exclude(fields ...string) data.Query {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	q.projection.Exclude = append([]string(nil), fields...)
+	return q
+}
+
+type iter struct {
+	rows     *sqlx.Rows
+	idColumn string
+	err      error
+	sync.Mutex
+}
+
+func newIter(rows *sqlx.Rows, idColumn string) data.Iterator {
+	return &iter{rows: rows, idColumn: idColumn}
+}
+
+func (i *iter) Next(r data.Record) bool {
+	i.Lock()
+	defer i.Unlock()
+
+	if i.err != nil || !i.rows.Next() {
+		return false
+	}
+
+	row := make(map[string]interface{})
+	if err := i.rows.MapScan(row); err != nil {
+		i.err = err
+		return false
+	}
+
+	if err := hydrate(row, r, i.idColumn); err != nil {
+		i.err = err
+		return false
+	}
+
+	return true
+}
+
+func (i *iter) Err() error {
+	i.Lock()
+	defer i.Unlock()
+
+	if i.err != nil {
+		return wrap(i.err, "iterate", "", "")
+	}
+
+	return wrap(i.rows.Err(), "iterate", "", "")
+}
+
+func (i *iter) Close() error {
+	i.Lock()
+	defer i.Unlock()
+
+	if err := i.rows.Close(); err != nil {
+		return wrap(err, "iterate", "", "")
+	}
+
+	if i.err != nil {
+		return wrap(i.err, "iterate", "", "")
+	}
+
+	return wrap(i.rows.Err(), "iterate", "", "")
+}
@@ -0,0 +1,159 @@
+package osql
+
+import (
+	"sync"
+
+	"github.com/elos/data"
+	"github.com/jmoiron/sqlx"
+	"golang.org/x/net/context"
+)
+
+// Begin starts a database transaction, the isolation
+// of which is that of the database.
+func (db *DB) Begin() (data.Tx, error) {
+	tx, err := db.DB.Beginx()
+	if err != nil {
+		return nil, wrap(err, "begin", "", "")
+	}
+
+	return &Tx{db: db, tx: tx}, nil
+}
+
+// A Tx is a transactional view of a DB, backed by a database transaction
+type Tx struct {
+	db      *DB
+	tx      *sqlx.Tx
+	pending []*data.Change
+
+	// versions are those of the Versioned records saved, before
+	// the saves advanced them, in order to restore them should
+	// the transaction not commit
+	versions []savedVersion
+
+	m sync.Mutex
+}
+
+func (tx *Tx) NewID() data.ID {
+	return tx.db.NewID()
+}
+
+func (tx *Tx) ParseID(s string) (data.ID, error) {
+	return tx.db.ParseID(s)
+}
+
+func (tx *Tx) Save(r data.Record) error {
+	return tx.SaveContext(context.Background(), r)
+}
+
+func (tx *Tx) SaveContext(ctx context.Context, r data.Record) error {
+	tx.m.Lock()
+	defer tx.m.Unlock()
+
+	version, _ := data.VersionOf(r)
+
+	c, err := tx.db.save(ctx, tx.tx, r)
+	if err != nil {
+		return wrap(err, "save", r.Kind(), r.ID())
+	}
+
+	if v, ok := r.(data.Versioned); ok {
+		tx.versions = append(tx.versions, savedVersion{v, version})
+	}
+
+	tx.pending = append(tx.pending, c)
+	return nil
+}
+
+func (tx *Tx) Delete(r data.Record) error {
+	return tx.DeleteContext(context.Background(), r)
+}
+
+func (tx *Tx) DeleteContext(ctx context.Context, r data.Record) error {
+	tx.m.Lock()
+	defer tx.m.Unlock()
+
+	c, err := tx.db.delete(ctx, tx.tx, r)
+	if err != nil {
+		return wrap(err, "delete", r.Kind(), r.ID())
+	}
+
+	tx.pending = append(tx.pending, c)
+	return nil
+}
+
+func (tx *Tx) PopulateByID(r data.Record) error {
+	return tx.PopulateByIDContext(context.Background(), r)
+}
+
+func (tx *Tx) PopulateByIDContext(ctx context.Context, r data.Record) error {
+	return wrap(tx.db.populateByID(ctx, tx.tx, r), "populate", r.Kind(), r.ID())
+}
+
+func (tx *Tx) PopulateByField(field string, value interface{}, r data.Record) error {
+	return tx.PopulateByFieldContext(context.Background(), field, value, r)
+}
+
+func (tx *Tx) PopulateByFieldContext(ctx context.Context, field string, value interface{}, r data.Record) error {
+	return wrap(tx.db.populateByField(ctx, tx.tx, field, value, r), "populate", r.Kind(), "")
+}
+
+func (tx *Tx) Query(k data.Kind) data.Query {
+	return tx.db.query(tx.tx, k)
+}
+
+func (tx *Tx) Changes() *chan *data.Change {
+	return tx.db.Changes()
+}
+
+func (tx *Tx) Unsubscribe(c *chan *data.Change) {
+	tx.db.Unsubscribe(c)
+}
+
+func (tx *Tx) ChangesSince(seq uint64) (*chan *data.Change, error) {
+	return tx.db.ChangesSince(seq)
+}
+
+// Commit commits the database transaction, and then notifies the
+// DB's subscribers of the changes. Should it fail, the Versioned records
+// the transaction saved are restored to the versions they were at before.
+func (tx *Tx) Commit() error {
+	tx.m.Lock()
+	defer tx.m.Unlock()
+
+	if err := tx.tx.Commit(); err != nil {
+		tx.restore()
+		return wrap(err, "commit", "", "")
+	}
+	tx.versions = nil
+
+	for _, c := range tx.pending {
+		tx.db.hub.Notify(c)
+	}
+	tx.pending = nil
+
+	return nil
+}
+
+func (tx *Tx) Rollback() error {
+	tx.m.Lock()
+	defer tx.m.Unlock()
+
+	tx.pending = nil
+	tx.restore()
+	return wrap(tx.tx.Rollback(), "rollback", "", "")
+}
+
+// savedVersion is the version of a Versioned record before it was saved
+type savedVersion struct {
+	v       data.Versioned
+	version int
+}
+
+// restore sets the Versioned records the transaction saved back to the
+// versions they were at before, the earliest of a record's saves last
+func (tx *Tx) restore() {
+	for i := len(tx.versions) - 1; i >= 0; i-- {
+		tx.versions[i].v.SetVersion(tx.versions[i].version)
+	}
+	tx.versions = nil
+}
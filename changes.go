@@ -1,6 +1,11 @@
 package data
 
-import "golang.org/x/net/context"
+import (
+	"log"
+	"sync"
+
+	"golang.org/x/net/context"
+)
 
 type (
 	// A changeKind indicates the nature of a Chage
@@ -12,32 +17,111 @@ type (
 	Change struct {
 		Record     `json:"record"`
 		ChangeKind `json:"kind"`
+
+		// Seq is the sequence number of the change, assigned
+		// by the Journal of the hub, if the hub has one
+		Seq uint64 `json:"seq,omitempty"`
+
+		// Previous is the record as it was before an Update, if
+		// the DB retrieves it, otherwise it is nil
+		Previous Record `json:"previous,omitempty"`
+
+		// Diff describes the fields an Update changed, if the
+		// DB computes it, otherwise it is nil
+		Diff Diff `json:"diff,omitempty"`
 	}
 
+	// A ChangeHub fans out Changes to its subscribers.
+	//
+	// Each subscriber has a buffer of its own, and receives the
+	// changes in the order they were notified. What happens when a
+	// subscriber falls behind, and its buffer is full, is determined
+	// by the Overflow policy of its subscription.
+	//
+	// When the context of the hub ends, the hub closes, and with it
+	// the channels of all its subscribers.
 	ChangeHub struct {
-		subs     []chan *Change
-		register chan chan *Change
-		Inbound  chan *Change
+		// notifying serializes calls to Notify, which
+		// is what orders the changes for the subscribers
+		notifying sync.Mutex
+
+		m      sync.Mutex
+		subs   map[chan *Change]*subscriber
+		closed bool
+
+		// journal, if not nil, records the changes
+		journal Journal
+
+		// Inbound notifies the changes sent on it, as Notify does,
+		// until the context of the hub ends.
+		//
+		// Deprecated: use Notify, which returns once the subscribers
+		// have the change, so that the changes of a writer are ordered.
+		Inbound chan *Change
+	}
+
+	// An Overflow policy decides what becomes of a change
+	// notified to a subscriber whose buffer is full
+	Overflow int
+
+	// SubscribeOpts configure a subscription to a ChangeHub
+	SubscribeOpts struct {
+		// Buffer is the number of changes which may be waiting
+		// for the subscriber before the Overflow policy applies
+		Buffer int
+
+		Overflow Overflow
+	}
+
+	// An Unsubscriber can cancel a subscription made with Changes.
+	// It is an optional interface, which a DB may implement.
+	Unsubscriber interface {
+		Unsubscribe(*chan *Change)
 	}
 
 	FilterFunc func(c *Change) bool
 )
 
 const (
-	// Update is the ChangeKind triggered on a save
-	// As such, it covers both creation and changeification
+	// Update is the ChangeKind triggered on a save of a record
+	// which already existed
 	Update ChangeKind = iota + 1
 
 	// Delete is the ChangeKind triggered on Delete
 	Delete
 
+	// Create is the ChangeKind triggered on a save of a new record
 	Create
 )
 
+const (
+	// Block makes Notify wait until the subscriber has room
+	// for the change, no change is lost, but a subscriber which
+	// does not keep up slows those who notify
+	Block Overflow = iota
+
+	// DropOldest discards the oldest change waiting
+	// for the subscriber to make room for the new one
+	DropOldest
+
+	// Disconnect unsubscribes the subscriber, closing its channel
+	Disconnect
+)
+
+// DefaultSubscribeOpts are the options of the subscriptions made with
+// Changes. No change is lost, so once a subscriber falls Buffer changes
+// behind, Notify waits for it. A subscriber which stops receiving must
+// unsubscribe, or subscribe with DropOldest or Disconnect, lest it stall
+// the writes of the DB.
+var DefaultSubscribeOpts = SubscribeOpts{
+	Buffer:   64,
+	Overflow: Block,
+}
+
 // Change Implementation {{{
 
 func NewChange(k ChangeKind, r Record) *Change {
-	return &Change{r, k}
+	return &Change{Record: r, ChangeKind: k}
 }
 
 func NewUpdate(r Record) *Change {
@@ -54,52 +138,239 @@ func NewCreate(r Record) *Change {
 
 // }}}
 
+// ChangeHub Implementation {{{
+
 func NewChangeHub(ctx context.Context) *ChangeHub {
 	hub := &ChangeHub{
-		subs:     make([]chan *Change, 0),
-		register: make(chan chan *Change),
-		Inbound:  make(chan *Change),
+		subs:    make(map[chan *Change]*subscriber),
+		Inbound: make(chan *Change),
 	}
-	go hub.start(ctx)
-	return hub
-}
 
-func (h *ChangeHub) start(ctx context.Context) {
-Run:
-	for {
-		select {
-		// add registstrations to subs
-		case sub := <-h.register:
-			h.subs = append(h.subs, sub)
-		// fan out changes
-		case change := <-h.Inbound:
-			for _, sub := range h.subs {
-				go func(s chan<- *Change, c *Change) {
-					s <- c // send the change to the subscribe
-				}(sub, change)
+	go func() {
+		for {
+			select {
+			case c := <-hub.Inbound:
+				hub.Notify(c)
+			case <-ctx.Done():
+				hub.Close()
+				return
 			}
-		// end
-		case <-ctx.Done():
-			break Run
 		}
-	}
+	}()
+
+	return hub
+}
+
+// NewJournaledChangeHub constructs a ChangeHub which appends every
+// change to the journal before it notifies subscribers, so that
+// they may resume with ChangesSince.
+func NewJournaledChangeHub(ctx context.Context, j Journal) *ChangeHub {
+	hub := NewChangeHub(ctx)
+	hub.journal = j
+	return hub
 }
 
+// Changes subscribes to the hub with the DefaultSubscribeOpts
 func (h *ChangeHub) Changes() *chan *Change {
-	// make the channel
-	c := make(chan *Change)
-	// register it
-	h.register <- c
-	// return it
-	return &c
+	return h.Subscribe(DefaultSubscribeOpts)
+}
+
+// Subscribe subscribes to the hub. The returned channel is closed
+// when the subscription is cancelled, or the hub closes.
+func (h *ChangeHub) Subscribe(opts SubscribeOpts) *chan *Change {
+	return h.subscribe(opts, nil)
+}
+
+// subscribe registers a subscriber, whose buffer holds the
+// backlog of changes, in addition to the buffer of the opts
+func (h *ChangeHub) subscribe(opts SubscribeOpts, backlog []*Change) *chan *Change {
+	if opts.Buffer < 0 {
+		opts.Buffer = 0
+	}
+
+	s := &subscriber{
+		c:        make(chan *Change, opts.Buffer+len(backlog)),
+		overflow: opts.Overflow,
+		done:     make(chan struct{}),
+	}
+
+	for _, c := range backlog {
+		s.c <- c
+	}
+
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	if h.closed {
+		s.close()
+	} else {
+		h.subs[s.c] = s
+	}
+
+	return &s.c
 }
 
+// ChangesSince subscribes to the hub with the DefaultSubscribeOpts,
+// starting after the change with the sequence number seq
+func (h *ChangeHub) ChangesSince(seq uint64) (*chan *Change, error) {
+	return h.SubscribeSince(seq, DefaultSubscribeOpts)
+}
+
+// SubscribeSince subscribes to the hub, starting after the change with
+// the sequence number seq. The changes the journal recorded since are
+// delivered first, in order, and then the changes as they are notified,
+// none are missed or repeated.
+//
+// SubscribeSince returns ErrNoJournal if the hub has no journal, and
+// ErrJournalTruncated if the journal no longer retains the changes.
+func (h *ChangeHub) SubscribeSince(seq uint64, opts SubscribeOpts) (*chan *Change, error) {
+	if h.journal == nil {
+		return nil, ErrNoJournal
+	}
+
+	// hold off notifications, so none are
+	// missed between the replay and the subscription
+	h.notifying.Lock()
+	defer h.notifying.Unlock()
+
+	replay, err := h.journal.Since(seq)
+	if err != nil {
+		return nil, err
+	}
+
+	return h.subscribe(opts, replay), nil
+}
+
+// Unsubscribe cancels the subscription of the channel, and closes it.
+// The changes already in its buffer may still be received.
+func (h *ChangeHub) Unsubscribe(c *chan *Change) {
+	h.m.Lock()
+	s, ok := h.subs[*c]
+	delete(h.subs, *c)
+	h.m.Unlock()
+
+	if ok {
+		s.close()
+	}
+}
+
+// Notify sends the change to each subscriber
 func (h *ChangeHub) Notify(c *Change) {
-	go func() {
-		h.Inbound <- c
-	}()
+	h.notifying.Lock()
+	defer h.notifying.Unlock()
+
+	if h.journal != nil {
+		if err := h.journal.Append(c); err != nil {
+			log.Printf("data: journaling change: %v", err)
+		}
+	}
+
+	h.m.Lock()
+	subs := make([]*subscriber, 0, len(h.subs))
+	for _, s := range h.subs {
+		subs = append(subs, s)
+	}
+	h.m.Unlock()
+
+	for _, s := range subs {
+		if !s.send(c) {
+			h.Unsubscribe(&s.c)
+		}
+	}
 }
 
+// Close closes the hub, and the channels of its subscribers.
+// Once closed, the hub ignores notifications, and the
+// channels of new subscriptions are already closed.
+func (h *ChangeHub) Close() {
+	h.m.Lock()
+	subs := h.subs
+	h.subs = make(map[chan *Change]*subscriber)
+	h.closed = true
+	h.m.Unlock()
+
+	for _, s := range subs {
+		s.close()
+	}
+}
+
+// }}}
+
+// subscriber {{{
+
+type subscriber struct {
+	c        chan *Change
+	overflow Overflow
+
+	// done is closed first, to release a blocked send,
+	// then c is closed, once the send has let go of m
+	done chan struct{}
+	once sync.Once
+
+	m      sync.Mutex
+	closed bool
+}
+
+// send delivers the change according to the overflow policy,
+// it returns false if the subscriber should be disconnected
+func (s *subscriber) send(c *Change) bool {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.closed {
+		return true
+	}
+
+	select {
+	case s.c <- c:
+		return true
+	default:
+	}
+
+	switch s.overflow {
+	case DropOldest:
+		// with no buffer, there is nothing older to drop
+		if cap(s.c) == 0 {
+			return true
+		}
+
+		for {
+			select {
+			case s.c <- c:
+				return true
+			default:
+			}
+
+			select {
+			case <-s.c:
+			default:
+			}
+		}
+	case Disconnect:
+		return false
+	default:
+		select {
+		case s.c <- c:
+		case <-s.done:
+		}
+		return true
+	}
+}
+
+func (s *subscriber) close() {
+	s.once.Do(func() {
+		close(s.done)
+
+		s.m.Lock()
+		defer s.m.Unlock()
+
+		s.closed = true
+		close(s.c)
+	})
+}
+
+// }}}
+
 // Filtering {{{
 
 // TODO make name clearer
@@ -107,6 +378,7 @@ func Filter(ch *chan *Change, fn FilterFunc) *chan *Change {
 	nc := make(chan *Change)
 
 	go func() {
+		defer close(nc)
 		for change := range *ch {
 			if fn(change) {
 				nc <- change
@@ -0,0 +1,250 @@
+package data_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/elos/data"
+	"golang.org/x/net/context"
+)
+
+func change(i int) *data.Change {
+	return data.NewUpdate(&thing{Id: fmt.Sprintf("%d", i)})
+}
+
+// receive receives n changes, and returns the ids of their records
+func receive(t *testing.T, c *chan *data.Change, n int) []string {
+	ids := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		select {
+		case change, ok := <-*c:
+			if !ok {
+				return ids
+			}
+			ids = append(ids, change.Record.ID().String())
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for change %d", i)
+		}
+	}
+	return ids
+}
+
+// closed reports whether the channel closes, discarding the changes before it does
+func closed(c *chan *data.Change) bool {
+	for {
+		select {
+		case _, ok := <-*c:
+			if !ok {
+				return true
+			}
+		case <-time.After(time.Second):
+			return false
+		}
+	}
+}
+
+func TestChangeHubOrder(t *testing.T) {
+	hub := data.NewChangeHub(context.Background())
+	defer hub.Close()
+
+	c := hub.Subscribe(data.SubscribeOpts{Buffer: 1, Overflow: data.Block})
+
+	go func() {
+		for i := 0; i < 100; i++ {
+			hub.Notify(change(i))
+		}
+	}()
+
+	for i, id := range receive(t, c, 100) {
+		if got, want := id, fmt.Sprintf("%d", i); got != want {
+			t.Fatalf("change %d: got %s, want %s", i, got, want)
+		}
+	}
+}
+
+func TestChangeHubBlock(t *testing.T) {
+	hub := data.NewChangeHub(context.Background())
+	defer hub.Close()
+
+	c := hub.Subscribe(data.SubscribeOpts{Buffer: 1, Overflow: data.Block})
+
+	hub.Notify(change(0))
+
+	notified := make(chan struct{})
+	go func() {
+		hub.Notify(change(1))
+		close(notified)
+	}()
+
+	select {
+	case <-notified:
+		t.Fatal("Notify returned while the subscriber's buffer was full")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if got, want := fmt.Sprint(receive(t, c, 2)), "[0 1]"; got != want {
+		t.Errorf("changes: got %s, want %s", got, want)
+	}
+
+	<-notified
+}
+
+func TestChangeHubDropOldest(t *testing.T) {
+	hub := data.NewChangeHub(context.Background())
+	defer hub.Close()
+
+	c := hub.Subscribe(data.SubscribeOpts{Buffer: 2, Overflow: data.DropOldest})
+
+	for i := 0; i < 5; i++ {
+		hub.Notify(change(i))
+	}
+
+	if got, want := fmt.Sprint(receive(t, c, 2)), "[3 4]"; got != want {
+		t.Errorf("changes: got %s, want %s", got, want)
+	}
+}
+
+func TestChangeHubDisconnect(t *testing.T) {
+	hub := data.NewChangeHub(context.Background())
+	defer hub.Close()
+
+	slow := hub.Subscribe(data.SubscribeOpts{Buffer: 1, Overflow: data.Disconnect})
+	fast := hub.Subscribe(data.SubscribeOpts{Buffer: 3, Overflow: data.Disconnect})
+
+	for i := 0; i < 3; i++ {
+		hub.Notify(change(i))
+	}
+
+	if got, want := fmt.Sprint(receive(t, slow, 2)), "[0]"; got != want {
+		t.Errorf("slow changes: got %s, want %s", got, want)
+	}
+
+	if got, want := fmt.Sprint(receive(t, fast, 3)), "[0 1 2]"; got != want {
+		t.Errorf("fast changes: got %s, want %s", got, want)
+	}
+}
+
+func TestChangeHubDefault(t *testing.T) {
+	hub := data.NewChangeHub(context.Background())
+	defer hub.Close()
+
+	// a subscriber which falls behind
+	c := hub.Changes()
+
+	n := data.DefaultSubscribeOpts.Buffer + 10
+
+	notified := make(chan struct{})
+	go func() {
+		for i := 0; i < n; i++ {
+			hub.Notify(change(i))
+		}
+		close(notified)
+	}()
+
+	select {
+	case <-notified:
+		t.Fatal("Notify didn't wait for a subscriber of Changes")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	// misses no change
+	ids := receive(t, c, n)
+	if got, want := len(ids), n; got != want {
+		t.Fatalf("changes: got %d, want %d", got, want)
+	}
+
+	for i, id := range ids {
+		if got, want := id, fmt.Sprintf("%d", i); got != want {
+			t.Fatalf("change %d: got %s, want %s", i, got, want)
+		}
+	}
+
+	<-notified
+}
+
+func TestChangeHubInbound(t *testing.T) {
+	hub := data.NewChangeHub(context.Background())
+	defer hub.Close()
+
+	c := hub.Changes()
+
+	hub.Inbound <- change(0)
+
+	if got, want := fmt.Sprint(receive(t, c, 1)), "[0]"; got != want {
+		t.Errorf("changes: got %s, want %s", got, want)
+	}
+}
+
+func TestChangeHubUnsubscribe(t *testing.T) {
+	hub := data.NewChangeHub(context.Background())
+	defer hub.Close()
+
+	c := hub.Changes()
+	other := hub.Changes()
+
+	hub.Unsubscribe(c)
+	hub.Unsubscribe(c) // idempotent
+
+	if !closed(c) {
+		t.Error("channel not closed after Unsubscribe")
+	}
+
+	hub.Notify(change(0))
+
+	if got, want := fmt.Sprint(receive(t, other, 1)), "[0]"; got != want {
+		t.Errorf("changes: got %s, want %s", got, want)
+	}
+}
+
+// Unsubscribe releases a Notify which is blocked on the subscriber
+func TestChangeHubUnsubscribeBlocked(t *testing.T) {
+	hub := data.NewChangeHub(context.Background())
+	defer hub.Close()
+
+	c := hub.Subscribe(data.SubscribeOpts{Buffer: 0, Overflow: data.Block})
+
+	notified := make(chan struct{})
+	go func() {
+		hub.Notify(change(0))
+		close(notified)
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	hub.Unsubscribe(c)
+
+	select {
+	case <-notified:
+	case <-time.After(time.Second):
+		t.Fatal("Notify still blocked after Unsubscribe")
+	}
+}
+
+func TestChangeHubContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	hub := data.NewChangeHub(ctx)
+
+	c := hub.Subscribe(data.SubscribeOpts{Buffer: 0, Overflow: data.Block})
+
+	notified := make(chan struct{})
+	go func() {
+		hub.Notify(change(0))
+		close(notified)
+	}()
+
+	cancel()
+
+	if !closed(c) {
+		t.Error("channel not closed after the context ended")
+	}
+
+	select {
+	case <-notified:
+	case <-time.After(time.Second):
+		t.Fatal("Notify still blocked after the context ended")
+	}
+
+	if !closed(hub.Changes()) {
+		t.Error("channel of a subscription made after the context ended is not closed")
+	}
+}
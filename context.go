@@ -0,0 +1,325 @@
+package data
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+type (
+	// A ContextQuery is a Query which can be executed with a context.
+	//
+	// The Iterator returned by ExecuteContext is bound to the context:
+	// once the context is done Next returns false, and Close returns
+	// an Error which wraps the context's error.
+	//
+	// The aggregates of a ContextQuery, CountContext and the others,
+	// are those of the Query, made with the context.
+	ContextQuery interface {
+		Query
+		ExecuteContext(context.Context) (Iterator, error)
+
+		CountContext(context.Context) (int, error)
+		ExistsContext(context.Context) (bool, error)
+		SumContext(ctx context.Context, field string) (float64, error)
+		MinContext(ctx context.Context, field string) (float64, error)
+		MaxContext(ctx context.Context, field string) (float64, error)
+		AvgContext(ctx context.Context, field string) (float64, error)
+		GroupByContext(ctx context.Context, field string) ([]Group, error)
+	}
+
+	// A ContextDB is a DB whose operations accept a context, and honor
+	// its cancellation and deadline. When the context is done, an operation
+	// returns an Error which wraps the context's error, context.Canceled or
+	// context.DeadlineExceeded, the latter classified as ErrTimeout.
+	//
+	// The Queries of a ContextDB are ContextQueries.
+	//
+	// Use WithContext to lift any DB into a ContextDB.
+	ContextDB interface {
+		DB
+
+		SaveContext(context.Context, Record) error
+		DeleteContext(context.Context, Record) error
+		PopulateByIDContext(context.Context, Record) error
+		PopulateByFieldContext(ctx context.Context, field string, value interface{}, r Record) error
+	}
+)
+
+// WithContext lifts a DB into a ContextDB. If the DB is already a
+// ContextDB it is returned as is.
+//
+// Otherwise, the operations of the returned ContextDB check the context
+// before they begin, and return as soon as the context is done. However,
+// the underlying operation can not be cancelled, so it may nevertheless
+// complete. A populate which completes late leaves the caller's record
+// unchanged, and an Iterator which arrives late is closed.
+func WithContext(db DB) ContextDB {
+	if cdb, ok := db.(ContextDB); ok {
+		return cdb
+	}
+
+	return &contextDB{DB: db}
+}
+
+type contextDB struct {
+	DB
+}
+
+// run performs the operation fn, unless the context is done first.
+// Should it be, fn may yet complete, and if it succeeds, late, if not
+// nil, is called, to release what it acquired.
+func run(ctx context.Context, fn func() error, late func()) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	result := make(chan error, 1)
+	go func() {
+		result <- fn()
+	}()
+
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		if late != nil {
+			go func() {
+				if err := <-result; err == nil {
+					late()
+				}
+			}()
+		}
+		return ctx.Err()
+	}
+}
+
+// populate performs the populate fn with a fresh record of the type of r,
+// and copies it into r only if fn completes, so that r is not changed once
+// the context is done
+func populate(ctx context.Context, r Record, fn func(Record) error) error {
+	t := reflect.TypeOf(r)
+	if t == nil || t.Kind() != reflect.Ptr {
+		return fmt.Errorf("data: can not populate record of type %T, must be a pointer", r)
+	}
+
+	c, ok := reflect.New(t.Elem()).Interface().(Record)
+	if !ok {
+		return fmt.Errorf("data: can not populate record of type %T", r)
+	}
+	c.SetID(r.ID())
+
+	if err := run(ctx, func() error { return fn(c) }, nil); err != nil {
+		return err
+	}
+
+	reflect.ValueOf(r).Elem().Set(reflect.ValueOf(c).Elem())
+	return nil
+}
+
+func (db *contextDB) SaveContext(ctx context.Context, r Record) error {
+	return WrapError(run(ctx, func() error { return db.Save(r) }, nil), "save", r.Kind(), r.ID())
+}
+
+func (db *contextDB) DeleteContext(ctx context.Context, r Record) error {
+	return WrapError(run(ctx, func() error { return db.Delete(r) }, nil), "delete", r.Kind(), r.ID())
+}
+
+func (db *contextDB) PopulateByIDContext(ctx context.Context, r Record) error {
+	return WrapError(populate(ctx, r, db.PopulateByID), "populate", r.Kind(), r.ID())
+}
+
+func (db *contextDB) PopulateByFieldContext(ctx context.Context, field string, value interface{}, r Record) error {
+	err := populate(ctx, r, func(c Record) error { return db.PopulateByField(field, value, c) })
+	return WrapError(err, "populate", r.Kind(), "")
+}
+
+func (db *contextDB) Query(k Kind) Query {
+	return &contextQuery{Query: db.DB.Query(k)}
+}
+
+// contextQuery lifts a Query into a ContextQuery. The methods which
+// build the query return the contextQuery, so that it remains one.
+type contextQuery struct {
+	Query
+}
+
+func (q *contextQuery) Skip(i int) Query {
+	q.Query = q.Query.Skip(i)
+	return q
+}
+
+func (q *contextQuery) Limit(i int) Query {
+	q.Query = q.Query.Limit(i)
+	return q
+}
+
+func (q *contextQuery) Batch(i int) Query {
+	q.Query = q.Query.Batch(i)
+	return q
+}
+
+func (q *contextQuery) Select(am AttrMap) Query {
+	q.Query = q.Query.Select(am)
+	return q
+}
+
+func (q *contextQuery) Where(p *Predicate) Query {
+	q.Query = q.Query.Where(p)
+	return q
+}
+
+func (q *contextQuery) Order(fields ...string) Query {
+	q.Query = q.Query.Order(fields...)
+	return q
+}
+
+func (q *contextQuery) Include(fields ...string) Query {
+	q.Query = q.Query.Include(fields...)
+	return q
+}
+
+func (q *contextQuery) Exclude(fields ...string) Query {
+	q.Query = q.Query.Exclude(fields...)
+	return q
+}
+
+func (q *contextQuery) ExecuteContext(ctx context.Context) (Iterator, error) {
+	var iter Iterator
+
+	err := run(ctx, func() (err error) {
+		iter, err = q.Query.Execute()
+		return err
+	}, func() {
+		// the iterator arrived once the context was done
+		iter.Close()
+	})
+	if err != nil {
+		return nil, WrapError(err, "query", "", "")
+	}
+
+	return IterContext(ctx, iter), nil
+}
+
+// the aggregates' results are only read if they completed, as
+// an aggregate which doesn't may yet set them
+
+func (q *contextQuery) CountContext(ctx context.Context) (int, error) {
+	var n int
+	if err := run(ctx, func() (err error) { n, err = q.Query.Count(); return err }, nil); err != nil {
+		return 0, WrapError(err, "count", "", "")
+	}
+	return n, nil
+}
+
+func (q *contextQuery) ExistsContext(ctx context.Context) (bool, error) {
+	var exists bool
+	if err := run(ctx, func() (err error) { exists, err = q.Query.Exists(); return err }, nil); err != nil {
+		return false, WrapError(err, "count", "", "")
+	}
+	return exists, nil
+}
+
+func (q *contextQuery) SumContext(ctx context.Context, field string) (float64, error) {
+	return q.aggregate(ctx, q.Query.Sum, field)
+}
+
+func (q *contextQuery) MinContext(ctx context.Context, field string) (float64, error) {
+	return q.aggregate(ctx, q.Query.Min, field)
+}
+
+func (q *contextQuery) MaxContext(ctx context.Context, field string) (float64, error) {
+	return q.aggregate(ctx, q.Query.Max, field)
+}
+
+func (q *contextQuery) AvgContext(ctx context.Context, field string) (float64, error) {
+	return q.aggregate(ctx, q.Query.Avg, field)
+}
+
+func (q *contextQuery) GroupByContext(ctx context.Context, field string) ([]Group, error) {
+	var groups []Group
+	if err := run(ctx, func() (err error) { groups, err = q.Query.GroupBy(field); return err }, nil); err != nil {
+		return nil, WrapError(err, "aggregate", "", "")
+	}
+	return groups, nil
+}
+
+// aggregate aggregates the field by fn, with the context
+func (q *contextQuery) aggregate(ctx context.Context, fn func(string) (float64, error), field string) (float64, error) {
+	var v float64
+	if err := run(ctx, func() (err error) { v, err = fn(field); return err }, nil); err != nil {
+		return 0, WrapError(err, "aggregate", "", "")
+	}
+	return v, nil
+}
+
+// ExecuteContext executes the query with the context, if it is a
+// ContextQuery, otherwise it executes the query and binds the
+// resulting Iterator to the context.
+func ExecuteContext(ctx context.Context, q Query) (Iterator, error) {
+	if cq, ok := q.(ContextQuery); ok {
+		return cq.ExecuteContext(ctx)
+	}
+
+	return (&contextQuery{Query: q}).ExecuteContext(ctx)
+}
+
+// IterContext binds the Iterator to the context. Once the context is
+// done, Next returns false, and Close returns an Error which wraps
+// the context's error.
+func IterContext(ctx context.Context, i Iterator) Iterator {
+	return &contextIter{Iterator: i, ctx: ctx}
+}
+
+type contextIter struct {
+	Iterator
+	ctx context.Context
+
+	m   sync.Mutex
+	err error
+}
+
+func (i *contextIter) Next(r Record) bool {
+	i.m.Lock()
+	defer i.m.Unlock()
+
+	if i.err != nil {
+		return false
+	}
+
+	if i.err = i.ctx.Err(); i.err != nil {
+		return false
+	}
+
+	return i.Iterator.Next(r)
+}
+
+// Err returns the error of the context, once it is done, or
+// otherwise that of the Iterator, if it is an ErrIterator
+func (i *contextIter) Err() error {
+	i.m.Lock()
+	defer i.m.Unlock()
+
+	if i.err != nil {
+		return WrapError(i.err, "iterate", "", "")
+	}
+
+	if ei, ok := i.Iterator.(ErrIterator); ok {
+		return ei.Err()
+	}
+
+	return nil
+}
+
+func (i *contextIter) Close() error {
+	i.m.Lock()
+	defer i.m.Unlock()
+
+	if err := i.Iterator.Close(); err != nil {
+		return err
+	}
+
+	return WrapError(i.err, "iterate", "", "")
+}
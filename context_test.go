@@ -0,0 +1,123 @@
+package data_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/elos/data"
+	"github.com/elos/data/builtin/mem"
+	"github.com/elos/data/dbtest"
+	"golang.org/x/net/context"
+)
+
+// slowDB holds its populates and query executions until released
+type slowDB struct {
+	data.DB
+
+	release chan struct{}
+
+	// completed receives once a held operation completes
+	completed chan struct{}
+}
+
+func (db *slowDB) PopulateByID(r data.Record) error {
+	<-db.release
+	defer func() { db.completed <- struct{}{} }()
+
+	return db.DB.PopulateByID(r)
+}
+
+func (db *slowDB) Query(k data.Kind) data.Query {
+	return &slowQuery{Query: db.DB.Query(k), db: db}
+}
+
+type slowQuery struct {
+	data.Query
+	db *slowDB
+}
+
+func (q *slowQuery) Execute() (data.Iterator, error) {
+	<-q.db.release
+
+	iter, err := q.Query.Execute()
+	if err != nil {
+		return nil, err
+	}
+
+	return &closedIter{Iterator: iter, closed: q.db.completed}, nil
+}
+
+// closedIter receives on closed once it is closed
+type closedIter struct {
+	data.Iterator
+	closed chan struct{}
+}
+
+func (i *closedIter) Close() error {
+	defer func() { i.closed <- struct{}{} }()
+	return i.Iterator.Close()
+}
+
+func TestContextLate(t *testing.T) {
+	db := &slowDB{
+		DB:        mem.NewDB(),
+		release:   make(chan struct{}),
+		completed: make(chan struct{}),
+	}
+
+	stored := &dbtest.Record{Name: "stored"}
+	stored.SetID(db.NewID())
+	if err := db.Save(stored); err != nil {
+		t.Fatalf("db.Save error: %v", err)
+	}
+
+	cdb := data.WithContext(db)
+
+	// a populate which completes late doesn't change the record
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	r := &dbtest.Record{Id: stored.Id}
+	if err := cdb.PopulateByIDContext(ctx, r); !errors.Is(err, data.ErrTimeout) {
+		t.Errorf("cdb.PopulateByIDContext: got %v, want data.ErrTimeout", err)
+	}
+
+	db.release <- struct{}{}
+	<-db.completed
+
+	if got, want := r.Name, ""; got != want {
+		t.Errorf("r.Name after the deadline: got %q, want %q", got, want)
+	}
+
+	// while one which completes in time does
+	db.release = make(chan struct{})
+	close(db.release)
+
+	go func() { <-db.completed }()
+	if err := cdb.PopulateByIDContext(context.Background(), r); err != nil {
+		t.Fatalf("cdb.PopulateByIDContext error: %v", err)
+	}
+
+	if got, want := r.Name, "stored"; got != want {
+		t.Errorf("r.Name: got %q, want %q", got, want)
+	}
+
+	// and an iterator which arrives late is closed
+	db.release = make(chan struct{})
+
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if _, err := data.ExecuteContext(ctx, cdb.Query(dbtest.RecordKind)); !errors.Is(err, data.ErrTimeout) {
+		t.Errorf("data.ExecuteContext: got %v, want data.ErrTimeout", err)
+	}
+
+	db.release <- struct{}{}
+
+	select {
+	case <-db.completed:
+	case <-time.After(time.Second):
+		t.Error("the late iterator was not closed")
+	}
+}
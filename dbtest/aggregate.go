@@ -0,0 +1,167 @@
+package dbtest
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/elos/data"
+	"golang.org/x/net/context"
+)
+
+// TestAggregates tests the counts and aggregates of queries, which
+// regard every record the query matches, regardless of its limit
+func TestAggregates(t *testing.T, newDB Constructor) {
+	db := open(t, newDB)
+
+	defer seed(t, db,
+		&Record{Name: "a", Count: 1, Note: "x", Flag: true},
+		&Record{Name: "b", Count: 3},
+		&Record{Name: "c", Count: 3, Note: "x", Flag: true},
+		&Record{Name: "d", Count: 2},
+	)()
+
+	all := func() data.Query { return db.Query(RecordKind) }
+	none := func() data.Query { return db.Query(RecordKind).Select(data.AttrMap{"name": "z"}) }
+	noted := func() data.Query { return db.Query(RecordKind).Select(data.AttrMap{"note": "x"}) }
+
+	// Count
+	counts := []struct {
+		what string
+		q    data.Query
+		want int
+	}{
+		{"all", all(), 4},
+		{"Select", db.Query(RecordKind).Select(data.AttrMap{"count": 3}), 2},
+		{"Where", db.Query(RecordKind).Where(data.Gt("count", 1)), 3},
+		{"Limit", db.Query(RecordKind).Limit(1), 4},
+		{"none", none(), 0},
+	}
+
+	for _, c := range counts {
+		n, err := c.q.Count()
+		if err != nil {
+			t.Errorf("%s: q.Count error: %v", c.what, err)
+			continue
+		}
+
+		if got, want := n, c.want; got != want {
+			t.Errorf("%s: q.Count: got %d, want %d", c.what, got, want)
+		}
+	}
+
+	// Exists
+	if exists, err := all().Exists(); err != nil {
+		t.Errorf("q.Exists error: %v", err)
+	} else if !exists {
+		t.Error("q.Exists: got false, want true")
+	}
+
+	if exists, err := none().Exists(); err != nil {
+		t.Errorf("q.Exists of none error: %v", err)
+	} else if exists {
+		t.Error("q.Exists of none: got true, want false")
+	}
+
+	// Sum, Min, Max and Avg
+	aggregates := []struct {
+		what string
+		fn   func(string) (float64, error)
+		want float64
+	}{
+		{"Sum", all().Sum, 9},
+		{"Sum of noted", noted().Sum, 4},
+		{"Sum of none", none().Sum, 0},
+		{"Min", all().Min, 1},
+		{"Max", all().Max, 3},
+		{"Avg", all().Avg, 2.25},
+		{"Avg of noted", noted().Avg, 2},
+	}
+
+	for _, a := range aggregates {
+		v, err := a.fn("count")
+		if err != nil {
+			t.Errorf("q.%s error: %v", a.what, err)
+			continue
+		}
+
+		if got, want := v, a.want; got != want {
+			t.Errorf("q.%s: got %v, want %v", a.what, got, want)
+		}
+	}
+
+	// of no values
+	for what, fn := range map[string]func(string) (float64, error){
+		"Min": none().Min,
+		"Max": none().Max,
+		"Avg": none().Avg,
+	} {
+		if _, err := fn("count"); !errors.Is(err, data.ErrNotFound) {
+			t.Errorf("q.%s of none: got %v, want %v", what, err, data.ErrNotFound)
+		}
+	}
+
+	// GroupBy
+	groupings := []struct {
+		field string
+		want  []data.Group
+	}{
+		{"count", []data.Group{{Value: 1.0, Count: 1}, {Value: 2.0, Count: 1}, {Value: 3.0, Count: 2}}},
+		{"note", []data.Group{{Value: nil, Count: 2}, {Value: "x", Count: 2}}},
+		{"flag", []data.Group{{Value: nil, Count: 2}, {Value: true, Count: 2}}},
+	}
+
+	for _, g := range groupings {
+		groups, err := all().GroupBy(g.field)
+		if err != nil {
+			t.Errorf("q.GroupBy(%q) error: %v", g.field, err)
+			continue
+		}
+
+		if got, want := groups, g.want; !reflect.DeepEqual(got, want) {
+			t.Errorf("q.GroupBy(%q): got %v, want %v", g.field, got, want)
+		}
+	}
+
+	groups, err := none().GroupBy("count")
+	if err != nil {
+		t.Fatalf("q.GroupBy of none error: %v", err)
+	}
+
+	if got, want := len(groups), 0; got != want {
+		t.Errorf("len(q.GroupBy of none): got %d, want %d", got, want)
+	}
+
+	// with a context
+	q, ok := all().(data.ContextQuery)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+	defer cancel()
+
+	if n, err := q.CountContext(ctx); err != nil {
+		t.Errorf("q.CountContext error: %v", err)
+	} else if got, want := n, 4; got != want {
+		t.Errorf("q.CountContext: got %d, want %d", got, want)
+	}
+
+	if v, err := q.SumContext(ctx, "count"); err != nil {
+		t.Errorf("q.SumContext error: %v", err)
+	} else if got, want := v, 9.0; got != want {
+		t.Errorf("q.SumContext: got %v, want %v", got, want)
+	}
+
+	expired, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+
+	if _, err := q.CountContext(expired); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("q.CountContext of expired: got %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	if _, err := q.GroupByContext(expired, "count"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("q.GroupByContext of expired: got %v, want %v", err, context.DeadlineExceeded)
+	}
+}
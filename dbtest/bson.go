@@ -0,0 +1,103 @@
+package dbtest
+
+import "gopkg.in/mgo.v2/bson"
+
+// GetBSON omits the id, which the mongo backend
+// supplies as an ObjectId when upserting.
+func (r *Record) GetBSON() (interface{}, error) {
+	return struct {
+		Name  string   `bson:"name"`
+		Count int      `bson:"count"`
+		Tags  []string `bson:"tags,omitempty"`
+		Note  string   `bson:"note,omitempty"`
+		Flag  bool     `bson:"flag,omitempty"`
+	}{
+		Name:  r.Name,
+		Count: r.Count,
+		Tags:  r.Tags,
+		Note:  r.Note,
+		Flag:  r.Flag,
+	}, nil
+}
+
+func (r *Record) SetBSON(raw bson.Raw) error {
+	tmp := struct {
+		Id    bson.ObjectId `bson:"_id,omitempty"`
+		Name  string        `bson:"name"`
+		Count int           `bson:"count"`
+		Tags  []string      `bson:"tags,omitempty"`
+		Note  string        `bson:"note,omitempty"`
+		Flag  bool          `bson:"flag,omitempty"`
+	}{}
+
+	if err := raw.Unmarshal(&tmp); err != nil {
+		return err
+	}
+
+	r.Id = tmp.Id.Hex()
+	r.Name = tmp.Name
+	r.Count = tmp.Count
+	r.Tags = tmp.Tags
+	r.Note = tmp.Note
+	r.Flag = tmp.Flag
+
+	return nil
+}
+
+// GetBSON omits the id, as does that of the Record
+func (r *VersionedRecord) GetBSON() (interface{}, error) {
+	return struct {
+		Name string `bson:"name"`
+		Ver  int    `bson:"version"`
+	}{
+		Name: r.Name,
+		Ver:  r.Ver,
+	}, nil
+}
+
+func (r *VersionedRecord) SetBSON(raw bson.Raw) error {
+	tmp := struct {
+		Id   bson.ObjectId `bson:"_id,omitempty"`
+		Name string        `bson:"name"`
+		Ver  int           `bson:"version"`
+	}{}
+
+	if err := raw.Unmarshal(&tmp); err != nil {
+		return err
+	}
+
+	r.Id = tmp.Id.Hex()
+	r.Name = tmp.Name
+	r.Ver = tmp.Ver
+
+	return nil
+}
+
+// GetBSON omits the id, as does that of the Record
+func (r *UniqueRecord) GetBSON() (interface{}, error) {
+	return struct {
+		Key  string `bson:"key,omitempty"`
+		Name string `bson:"name"`
+	}{
+		Key:  r.Key,
+		Name: r.Name,
+	}, nil
+}
+
+func (r *UniqueRecord) SetBSON(raw bson.Raw) error {
+	tmp := struct {
+		Id   bson.ObjectId `bson:"_id,omitempty"`
+		Key  string        `bson:"key,omitempty"`
+		Name string        `bson:"name"`
+	}{}
+
+	if err := raw.Unmarshal(&tmp); err != nil {
+		return err
+	}
+
+	r.Id = tmp.Id.Hex()
+	r.Key = tmp.Key
+	r.Name = tmp.Name
+
+	return nil
+}
@@ -0,0 +1,165 @@
+package dbtest
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/elos/data"
+)
+
+// Timeout is how long the suite waits for a change notification
+var Timeout = 1 * time.Second
+
+// TestChanges tests that Save and Delete notify subscribers,
+// and, if the DB is a data.Unsubscriber, that subscriptions end
+func TestChanges(t *testing.T, newDB Constructor) {
+	db := open(t, newDB)
+
+	changes, unsubscribe := subscribe(db)
+	defer unsubscribe()
+
+	r := &Record{Name: "changes"}
+	r.SetID(db.NewID())
+
+	if err := db.Save(r); err != nil {
+		t.Fatalf("db.Save error: %v", err)
+	}
+
+	r.Name = "changed"
+	if err := db.Save(r); err != nil {
+		t.Fatalf("db.Save error: %v", err)
+	}
+
+	if err := db.Delete(r); err != nil {
+		t.Fatalf("db.Delete error: %v", err)
+	}
+
+	// a backend need not deliver changes in order
+	received := make(map[data.ChangeKind]int)
+	var update *data.Change
+	for i := 0; i < 3; i++ {
+		select {
+		case c := <-*changes:
+			if c.Record.ID() != r.ID() {
+				i--
+				continue
+			}
+			received[c.ChangeKind]++
+			if c.ChangeKind == data.Update {
+				update = c
+			}
+		case <-time.After(Timeout):
+			t.Fatalf("timed out waiting for change, received %v", received)
+		}
+	}
+
+	if got, want := received[data.Delete], 1; got != want {
+		t.Errorf("delete changes: got %d, want %d", got, want)
+	}
+
+	if got, want := received[data.Create], 1; got != want {
+		t.Errorf("create changes: got %d, want %d", got, want)
+	}
+
+	if got, want := received[data.Update], 1; got != want {
+		t.Errorf("update changes: got %d, want %d", got, want)
+	}
+
+	// a backend need not compute the diff, but if it does it must be right
+	if update != nil && update.Diff != nil {
+		want := data.Diff{{Op: data.Set, Field: "name", Old: "changes", New: "changed"}}
+		if got := update.Diff; !reflect.DeepEqual(got, want) {
+			t.Errorf("update diff: got %+v, want %+v", got, want)
+		}
+	}
+
+	if _, ok := db.(data.Unsubscriber); !ok {
+		return
+	}
+
+	unsubscribe()
+
+	select {
+	case _, ok := <-*changes:
+		if ok {
+			t.Errorf("received a change after unsubscribing")
+		}
+	case <-time.After(Timeout):
+		t.Errorf("timed out waiting for the changes to close after unsubscribing")
+	}
+}
+
+// TestIdleSubscriber tests that a subscriber of Changes which falls
+// behind misses no change, and stalls none of the lookups of the DB
+func TestIdleSubscriber(t *testing.T, newDB Constructor) {
+	db := open(t, newDB)
+
+	present := &Record{Name: "present"}
+	present.SetID(db.NewID())
+	if err := db.Save(present); err != nil {
+		t.Fatalf("db.Save error: %v", err)
+	}
+	defer db.Delete(present)
+
+	records := make([]*Record, data.DefaultSubscribeOpts.Buffer+10)
+	for i := range records {
+		records[i] = &Record{Name: "idle", Count: i}
+		records[i].SetID(db.NewID())
+	}
+
+	defer func() {
+		for _, r := range records {
+			db.Delete(r)
+		}
+	}()
+
+	// unsubscribed before the records are deleted
+	c := db.Changes()
+	if u, ok := db.(data.Unsubscriber); ok {
+		defer u.Unsubscribe(c)
+	}
+
+	saved := make(chan error, 1)
+	go func() {
+		for _, r := range records {
+			if err := db.Save(r); err != nil {
+				saved <- err
+				return
+			}
+		}
+		saved <- nil
+	}()
+
+	// while the subscriber is idle, and its buffer fills
+	time.Sleep(Timeout / 10)
+
+	populated := make(chan error, 1)
+	go func() { populated <- db.PopulateByID(&Record{Id: present.Id}) }()
+
+	select {
+	case err := <-populated:
+		if err != nil {
+			t.Errorf("db.PopulateByID error: %v", err)
+		}
+	case <-time.After(5 * Timeout):
+		t.Fatal("timed out populating, while a subscriber was idle")
+	}
+
+	// once it receives, the subscriber has every change
+	received := 0
+	for received < len(records) {
+		select {
+		case change := <-*c:
+			if r, ok := change.Record.(*Record); ok && r.Name == "idle" {
+				received++
+			}
+		case <-time.After(5 * Timeout):
+			t.Fatalf("timed out waiting for the changes, received %d of %d", received, len(records))
+		}
+	}
+
+	if err := <-saved; err != nil {
+		t.Fatalf("db.Save error: %v", err)
+	}
+}
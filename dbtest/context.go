@@ -0,0 +1,107 @@
+package dbtest
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/elos/data"
+	"golang.org/x/net/context"
+)
+
+// TestContext tests that the DB, lifted by data.WithContext,
+// honors the cancellation and deadlines of contexts
+func TestContext(t *testing.T, newDB Constructor) {
+	db := data.WithContext(open(t, newDB))
+
+	one, two := &Record{Name: "one"}, &Record{Name: "two"}
+	defer seed(t, db, one, two)()
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	expired, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+
+	for _, c := range []struct {
+		name string
+		ctx  context.Context
+		want error
+	}{
+		{"canceled", canceled, context.Canceled},
+		{"expired", expired, context.DeadlineExceeded},
+	} {
+		r := &Record{Name: "unsaved"}
+		r.SetID(db.NewID())
+
+		if got := db.SaveContext(c.ctx, r); !errors.Is(got, c.want) {
+			t.Errorf("%s: db.SaveContext: got %v, want %v", c.name, got, c.want)
+		}
+
+		if got, want := db.PopulateByID(&Record{Id: r.Id}), data.ErrNotFound; !errors.Is(got, want) {
+			t.Errorf("%s: db.PopulateByID after db.SaveContext: got %v, want %v", c.name, got, want)
+		}
+
+		if got := db.DeleteContext(c.ctx, one); !errors.Is(got, c.want) {
+			t.Errorf("%s: db.DeleteContext: got %v, want %v", c.name, got, c.want)
+		}
+
+		if got := db.PopulateByIDContext(c.ctx, &Record{Id: one.Id}); !errors.Is(got, c.want) {
+			t.Errorf("%s: db.PopulateByIDContext: got %v, want %v", c.name, got, c.want)
+		}
+
+		if got := db.PopulateByFieldContext(c.ctx, "name", "one", new(Record)); !errors.Is(got, c.want) {
+			t.Errorf("%s: db.PopulateByFieldContext: got %v, want %v", c.name, got, c.want)
+		}
+
+		if _, got := data.ExecuteContext(c.ctx, db.Query(RecordKind)); !errors.Is(got, c.want) {
+			t.Errorf("%s: data.ExecuteContext: got %v, want %v", c.name, got, c.want)
+		}
+	}
+
+	// a live context
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+	defer cancel()
+
+	if err := db.PopulateByIDContext(ctx, &Record{Id: one.Id}); err != nil {
+		t.Errorf("db.PopulateByIDContext error: %v", err)
+	}
+
+	three := &Record{Name: "three"}
+	three.SetID(db.NewID())
+	if err := db.SaveContext(ctx, three); err != nil {
+		t.Fatalf("db.SaveContext error: %v", err)
+	}
+
+	if err := db.DeleteContext(ctx, three); err != nil {
+		t.Errorf("db.DeleteContext error: %v", err)
+	}
+
+	// cancel during iteration
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+
+	q, ok := db.Query(RecordKind).Order("name").(data.ContextQuery)
+	if !ok {
+		t.Fatalf("db.Query: got %T, want a data.ContextQuery", q)
+	}
+
+	iter, err := q.ExecuteContext(ctx)
+	if err != nil {
+		t.Fatalf("q.ExecuteContext error: %v", err)
+	}
+
+	if got, want := iter.Next(new(Record)), true; got != want {
+		t.Fatalf("iter.Next: got %t, want %t", got, want)
+	}
+
+	cancel()
+
+	if got, want := iter.Next(new(Record)), false; got != want {
+		t.Errorf("iter.Next after cancel: got %t, want %t", got, want)
+	}
+
+	if got, want := iter.Close(), context.Canceled; !errors.Is(got, want) {
+		t.Errorf("iter.Close: got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,134 @@
+package dbtest
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/elos/data"
+)
+
+// TestSave tests that Save upserts records, and rejects empty ids
+func TestSave(t *testing.T, newDB Constructor) {
+	db := open(t, newDB)
+
+	r := &Record{Name: "save"}
+	if got, want := db.Save(r), data.ErrInvalidID; !errors.Is(got, want) {
+		t.Errorf("db.Save with empty id: got %v, want %v", got, want)
+	}
+
+	defer seed(t, db, r)()
+
+	populated := &Record{Id: r.Id}
+	if err := db.PopulateByID(populated); err != nil {
+		t.Fatalf("db.PopulateByID error: %v", err)
+	}
+
+	if got, want := populated.Name, "save"; got != want {
+		t.Errorf("populated.Name: got %q, want %q", got, want)
+	}
+
+	// upsert the existing record
+	r.Name = "upsert"
+	r.Count = 2
+	if err := db.Save(r); err != nil {
+		t.Fatalf("db.Save error: %v", err)
+	}
+
+	populated = &Record{Id: r.Id}
+	if err := db.PopulateByID(populated); err != nil {
+		t.Fatalf("db.PopulateByID error: %v", err)
+	}
+
+	if got, want := populated.Name, "upsert"; got != want {
+		t.Errorf("populated.Name: got %q, want %q", got, want)
+	}
+
+	if got, want := populated.Count, 2; got != want {
+		t.Errorf("populated.Count: got %d, want %d", got, want)
+	}
+
+	// the upsert must not have created a second record
+	if got, want := names(t, db.Query(RecordKind)), []string{"upsert"}; !equalStrings(got, want) {
+		t.Errorf("names: got %v, want %v", got, want)
+	}
+}
+
+// TestDelete tests that Delete removes records, and
+// reports records which do not exist
+func TestDelete(t *testing.T, newDB Constructor) {
+	db := open(t, newDB)
+
+	r := &Record{Name: "delete"}
+	seed(t, db, r)
+
+	if err := db.Delete(r); err != nil {
+		t.Fatalf("db.Delete error: %v", err)
+	}
+
+	if got, want := db.PopulateByID(&Record{Id: r.Id}), data.ErrNotFound; !errors.Is(got, want) {
+		t.Errorf("db.PopulateByID after delete: got %v, want %v", got, want)
+	}
+
+	if got, want := db.Delete(r), data.ErrNotFound; !errors.Is(got, want) {
+		t.Errorf("db.Delete of deleted record: got %v, want %v", got, want)
+	}
+
+	missing := &Record{}
+	missing.SetID(db.NewID())
+	if got, want := db.Delete(missing), data.ErrNotFound; !errors.Is(got, want) {
+		t.Errorf("db.Delete of missing record: got %v, want %v", got, want)
+	}
+}
+
+// TestPopulate tests PopulateByID and PopulateByField
+func TestPopulate(t *testing.T, newDB Constructor) {
+	db := open(t, newDB)
+
+	r := &Record{Name: "populate", Count: 7, Tags: []string{"one", "two"}}
+	defer seed(t, db, r)()
+
+	byID := &Record{Id: r.Id}
+	if err := db.PopulateByID(byID); err != nil {
+		t.Fatalf("db.PopulateByID error: %v", err)
+	}
+
+	if got, want := byID.Name, "populate"; got != want {
+		t.Errorf("byID.Name: got %q, want %q", got, want)
+	}
+
+	if got, want := byID.Count, 7; got != want {
+		t.Errorf("byID.Count: got %d, want %d", got, want)
+	}
+
+	if got, want := byID.Tags, []string{"one", "two"}; !equalStrings(got, want) {
+		t.Errorf("byID.Tags: got %v, want %v", got, want)
+	}
+
+	byField := new(Record)
+	if err := db.PopulateByField("name", "populate", byField); err != nil {
+		t.Fatalf("db.PopulateByField error: %v", err)
+	}
+
+	if got, want := byField.ID(), r.ID(); got != want {
+		t.Errorf("byField.ID(): got %q, want %q", got, want)
+	}
+
+	byNumber := new(Record)
+	if err := db.PopulateByField("count", 7, byNumber); err != nil {
+		t.Fatalf("db.PopulateByField error: %v", err)
+	}
+
+	if got, want := byNumber.ID(), r.ID(); got != want {
+		t.Errorf("byNumber.ID(): got %q, want %q", got, want)
+	}
+
+	missing := new(Record)
+	missing.SetID(db.NewID())
+	if got, want := db.PopulateByID(missing), data.ErrNotFound; !errors.Is(got, want) {
+		t.Errorf("db.PopulateByID of missing record: got %v, want %v", got, want)
+	}
+
+	if got, want := db.PopulateByField("name", "missing", new(Record)), data.ErrNotFound; !errors.Is(got, want) {
+		t.Errorf("db.PopulateByField of missing record: got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,185 @@
+// Package dbtest provides behavioural tests which any data.DB
+// implementation should pass.
+//
+// A backend's own tests invoke the suite with a Constructor:
+//
+//	func TestConformance(t *testing.T) {
+//		dbtest.TestDB(t, func() (data.DB, error) {
+//			return mem.NewDB(), nil
+//		})
+//	}
+//
+// The suite persists Records of RecordKind. A backend which requires
+// kinds to be registered (e.g., mongo) must register it in the Constructor,
+// and may register the VersionedRecordKind, lest TestVersions be skipped,
+// and the UniqueRecordKind, lest TestUnique be.
+package dbtest
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/elos/data"
+)
+
+// RecordKind is the kind of the Records persisted by the suite
+const RecordKind data.Kind = "dbtest_record"
+
+type (
+	// A Constructor produces the DB under test. It is called once
+	// per test, so that tests may be isolated from one another.
+	Constructor func() (data.DB, error)
+
+	// Record is the structure the suite persists. The json and
+	// bson attribute names are equivalent, so that field names
+	// in queries are portable.
+	Record struct {
+		Id    string   `json:"id" bson:"_id,omitempty"`
+		Name  string   `json:"name" bson:"name"`
+		Count int      `json:"count" bson:"count"`
+		Tags  []string `json:"tags,omitempty" bson:"tags,omitempty"`
+		Note  string   `json:"note,omitempty" bson:"note,omitempty"`
+		Flag  bool     `json:"flag,omitempty" bson:"flag,omitempty"`
+	}
+)
+
+func (r *Record) Kind() data.Kind {
+	return RecordKind
+}
+
+func (r *Record) ID() data.ID {
+	return data.ID(r.Id)
+}
+
+func (r *Record) SetID(id data.ID) {
+	r.Id = id.String()
+}
+
+// Model describes the Records, stored in the table, or collection, storage
+func Model(storage string) *data.Model {
+	return &data.Model{
+		Kind:    RecordKind,
+		New:     func() data.Record { return new(Record) },
+		Storage: storage,
+	}
+}
+
+// TestDB runs the entire suite against the DB
+func TestDB(t *testing.T, newDB Constructor) {
+	t.Run("IDs", func(t *testing.T) { TestIDs(t, newDB) })
+	t.Run("Save", func(t *testing.T) { TestSave(t, newDB) })
+	t.Run("Delete", func(t *testing.T) { TestDelete(t, newDB) })
+	t.Run("Populate", func(t *testing.T) { TestPopulate(t, newDB) })
+	t.Run("Query", func(t *testing.T) { TestQuery(t, newDB) })
+	t.Run("Iterators", func(t *testing.T) { TestIterators(t, newDB) })
+	t.Run("Aggregates", func(t *testing.T) { TestAggregates(t, newDB) })
+	t.Run("Projection", func(t *testing.T) { TestProjection(t, newDB) })
+	t.Run("Predicates", func(t *testing.T) { TestPredicates(t, newDB) })
+	t.Run("DocumentPredicates", func(t *testing.T) { TestDocumentPredicates(t, newDB) })
+	t.Run("Changes", func(t *testing.T) { TestChanges(t, newDB) })
+	t.Run("IdleSubscriber", func(t *testing.T) { TestIdleSubscriber(t, newDB) })
+	t.Run("Journal", func(t *testing.T) { TestJournal(t, newDB) })
+	t.Run("Versions", func(t *testing.T) { TestVersions(t, newDB) })
+	t.Run("Unique", func(t *testing.T) { TestUnique(t, newDB) })
+	t.Run("Transactions", func(t *testing.T) { TestTransactions(t, newDB) })
+	t.Run("Context", func(t *testing.T) { TestContext(t, newDB) })
+}
+
+// TestIDs tests that the DB can parse the IDs it generates
+func TestIDs(t *testing.T, newDB Constructor) {
+	db := open(t, newDB)
+
+	id := db.NewID()
+	if id == "" {
+		t.Fatal("db.NewID: got empty id")
+	}
+
+	if other := db.NewID(); other == id {
+		t.Errorf("db.NewID: got %q twice", id)
+	}
+
+	parsed, err := db.ParseID(id.String())
+	if err != nil {
+		t.Fatalf("db.ParseID(%q) error: %v", id, err)
+	}
+
+	if got, want := parsed, id; got != want {
+		t.Errorf("db.ParseID: got %q, want %q", got, want)
+	}
+}
+
+// open constructs a DB, failing the test if it can't
+func open(t *testing.T, newDB Constructor) data.DB {
+	db, err := newDB()
+	if err != nil {
+		t.Fatalf("constructing db: %v", err)
+	}
+	return db
+}
+
+// subscribe subscribes to the changes to records of the RecordKind,
+// and returns a function which cancels the subscription, if the
+// DB is a data.Unsubscriber.
+func subscribe(db data.DB) (*chan *data.Change, func()) {
+	c := db.Changes()
+
+	return data.FilterKind(c, RecordKind), func() {
+		if u, ok := db.(data.Unsubscriber); ok {
+			u.Unsubscribe(c)
+		}
+	}
+}
+
+// seed saves the records, assigning each a new ID, and returns a
+// function which deletes them.
+func seed(t *testing.T, db data.DB, records ...*Record) func() {
+	for _, r := range records {
+		r.SetID(db.NewID())
+		if err := db.Save(r); err != nil {
+			t.Fatalf("db.Save error: %v", err)
+		}
+	}
+
+	return func() {
+		for _, r := range records {
+			db.Delete(r)
+		}
+	}
+}
+
+// names executes the query, and returns the sorted names of the results
+func names(t *testing.T, q data.Query) []string {
+	iter, err := q.Execute()
+	if err != nil {
+		t.Fatalf("q.Execute error: %v", err)
+	}
+
+	ns := make([]string, 0)
+
+	r := new(Record)
+	for iter.Next(r) {
+		ns = append(ns, r.Name)
+		r = new(Record)
+	}
+
+	if err := iter.Close(); err != nil {
+		t.Fatalf("iter.Close error: %v", err)
+	}
+
+	sort.Strings(ns)
+	return ns
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,78 @@
+package dbtest
+
+import (
+	"testing"
+
+	"github.com/elos/data"
+)
+
+// TestIterators tests that the DB's iterators may be closed early, and
+// that those which are data.ErrIterators report no error when they aren't
+func TestIterators(t *testing.T, newDB Constructor) {
+	db := open(t, newDB)
+
+	defer seed(t, db,
+		&Record{Name: "a"},
+		&Record{Name: "b"},
+		&Record{Name: "c"},
+	)()
+
+	errOf := func(iter data.Iterator) error {
+		if ei, ok := iter.(data.ErrIterator); ok {
+			return ei.Err()
+		}
+		return nil
+	}
+
+	// exhausted
+	iter, err := db.Query(RecordKind).Execute()
+	if err != nil {
+		t.Fatalf("q.Execute error: %v", err)
+	}
+
+	n := 0
+	for iter.Next(new(Record)) {
+		n++
+		if err := errOf(iter); err != nil {
+			t.Errorf("iter.Err while iterating: %v", err)
+		}
+	}
+
+	if got, want := n, 3; got != want {
+		t.Errorf("records: got %d, want %d", got, want)
+	}
+
+	if err := errOf(iter); err != nil {
+		t.Errorf("iter.Err once exhausted: %v", err)
+	}
+
+	if err := iter.Close(); err != nil {
+		t.Errorf("iter.Close error: %v", err)
+	}
+
+	// closed early
+	iter, err = db.Query(RecordKind).Order("name").Execute()
+	if err != nil {
+		t.Fatalf("q.Execute error: %v", err)
+	}
+
+	if !iter.Next(new(Record)) {
+		t.Fatal("iter.Next: got false, want true")
+	}
+
+	if err := iter.Close(); err != nil {
+		t.Errorf("iter.Close before exhausted error: %v", err)
+	}
+
+	if iter.Next(new(Record)) {
+		t.Error("iter.Next once closed: got true, want false")
+	}
+
+	if err := errOf(iter); err != nil {
+		t.Errorf("iter.Err once closed: %v", err)
+	}
+
+	if err := iter.Close(); err != nil {
+		t.Errorf("iter.Close once closed error: %v", err)
+	}
+}
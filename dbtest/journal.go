@@ -0,0 +1,105 @@
+package dbtest
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/elos/data"
+)
+
+// TestJournal tests that a subscriber may resume its subscription,
+// receiving the changes it missed, and then those which follow.
+// It skips DBs which aren't data.Resumers, or don't journal.
+func TestJournal(t *testing.T, newDB Constructor) {
+	db := open(t, newDB)
+
+	resumer, ok := db.(data.Resumer)
+	if !ok {
+		t.Skipf("%T does not implement data.Resumer", db)
+	}
+
+	if _, err := resumer.ChangesSince(0); errors.Is(err, data.ErrNoJournal) {
+		t.Skipf("%T does not journal changes", db)
+	}
+
+	changes, unsubscribe := subscribe(db)
+
+	r := &Record{Name: "journaled"}
+	r.SetID(db.NewID())
+	if err := db.Save(r); err != nil {
+		t.Fatalf("db.Save error: %v", err)
+	}
+	defer db.Delete(r)
+
+	last := next(t, changes)
+	if last.Seq == 0 {
+		t.Fatalf("change not assigned a sequence number")
+	}
+
+	unsubscribe()
+
+	// missed
+	r.Name = "missed"
+	if err := db.Save(r); err != nil {
+		t.Fatalf("db.Save error: %v", err)
+	}
+
+	missed := &Record{Name: "missed"}
+	defer seed(t, db, missed)()
+
+	c, err := resumer.ChangesSince(last.Seq)
+	if err != nil {
+		t.Fatalf("ChangesSince error: %v", err)
+	}
+	defer func() {
+		if u, ok := db.(data.Unsubscriber); ok {
+			u.Unsubscribe(c)
+		}
+	}()
+	resumed := data.FilterKind(c, RecordKind)
+
+	// live
+	r.Name = "live"
+	if err := db.Save(r); err != nil {
+		t.Fatalf("db.Save error: %v", err)
+	}
+
+	for i, want := range []struct {
+		id   data.ID
+		name string
+	}{
+		{r.ID(), "missed"},
+		{missed.ID(), "missed"},
+		{r.ID(), "live"},
+	} {
+		change := next(t, resumed)
+
+		if got, want := change.Seq, last.Seq+uint64(i)+1; got != want {
+			t.Errorf("change %d: seq: got %d, want %d", i, got, want)
+		}
+
+		record, ok := change.Record.(*Record)
+		if !ok {
+			t.Fatalf("change %d: record: got %T, want *dbtest.Record", i, change.Record)
+		}
+
+		if record.ID() != want.id || record.Name != want.name {
+			t.Errorf("change %d: record: got %s %q, want %s %q", i, record.ID(), record.Name, want.id, want.name)
+		}
+	}
+}
+
+// next receives the next change
+func next(t *testing.T, changes *chan *data.Change) *data.Change {
+	select {
+	case c, ok := <-*changes:
+		if !ok {
+			t.Fatalf("changes closed")
+		}
+		return c
+	case <-time.After(Timeout):
+		t.Fatalf("timed out waiting for change")
+	}
+	return nil
+}
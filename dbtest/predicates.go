@@ -0,0 +1,152 @@
+package dbtest
+
+import (
+	"testing"
+
+	"github.com/elos/data"
+)
+
+type predicateCase struct {
+	name  string
+	query func(data.Query) data.Query
+	want  []string
+}
+
+// testPredicates runs the cases against the records
+// which the predicate tests share
+func testPredicates(t *testing.T, newDB Constructor, cases []predicateCase) {
+	db := open(t, newDB)
+
+	defer seed(t, db,
+		&Record{Name: "alpha", Count: 1, Tags: []string{"a", "b"}, Note: "x"},
+		&Record{Name: "beta", Count: 2, Tags: []string{"b"}},
+		&Record{Name: "gamma", Count: 3, Note: "y"},
+		&Record{Name: "delta", Count: 4},
+	)()
+
+	for _, c := range cases {
+		got := names(t, c.query(db.Query(RecordKind)))
+		if !equalStrings(got, c.want) {
+			t.Errorf("%s: got %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+// TestPredicates tests that the DB's queries evaluate data.Predicates
+func TestPredicates(t *testing.T, newDB Constructor) {
+	testPredicates(t, newDB, []predicateCase{
+		{
+			name:  "eq",
+			query: func(q data.Query) data.Query { return q.Where(data.Eq("name", "beta")) },
+			want:  []string{"beta"},
+		},
+		{
+			name:  "ne",
+			query: func(q data.Query) data.Query { return q.Where(data.Ne("name", "beta")) },
+			want:  []string{"alpha", "delta", "gamma"},
+		},
+		{
+			name:  "gt",
+			query: func(q data.Query) data.Query { return q.Where(data.Gt("count", 2)) },
+			want:  []string{"delta", "gamma"},
+		},
+		{
+			name:  "gte",
+			query: func(q data.Query) data.Query { return q.Where(data.Gte("count", 2)) },
+			want:  []string{"beta", "delta", "gamma"},
+		},
+		{
+			name:  "lt",
+			query: func(q data.Query) data.Query { return q.Where(data.Lt("count", 2)) },
+			want:  []string{"alpha"},
+		},
+		{
+			name:  "lte",
+			query: func(q data.Query) data.Query { return q.Where(data.Lte("count", 2)) },
+			want:  []string{"alpha", "beta"},
+		},
+		{
+			name:  "string range",
+			query: func(q data.Query) data.Query { return q.Where(data.Lt("name", "c")) },
+			want:  []string{"alpha", "beta"},
+		},
+		{
+			name:  "in",
+			query: func(q data.Query) data.Query { return q.Where(data.In("name", "alpha", "delta", "omega")) },
+			want:  []string{"alpha", "delta"},
+		},
+		{
+			name:  "nin",
+			query: func(q data.Query) data.Query { return q.Where(data.Nin("name", "alpha", "delta")) },
+			want:  []string{"beta", "gamma"},
+		},
+		{
+			name:  "exists",
+			query: func(q data.Query) data.Query { return q.Where(data.Exists("note", true)) },
+			want:  []string{"alpha", "gamma"},
+		},
+		{
+			name:  "not exists",
+			query: func(q data.Query) data.Query { return q.Where(data.Exists("note", false)) },
+			want:  []string{"beta", "delta"},
+		},
+		{
+			name: "and",
+			query: func(q data.Query) data.Query {
+				return q.Where(data.And(data.Gt("count", 1), data.Lt("count", 4)))
+			},
+			want: []string{"beta", "gamma"},
+		},
+		{
+			name: "or",
+			query: func(q data.Query) data.Query {
+				return q.Where(data.Or(data.Eq("name", "alpha"), data.Gte("count", 4)))
+			},
+			want: []string{"alpha", "delta"},
+		},
+		{
+			name:  "not",
+			query: func(q data.Query) data.Query { return q.Where(data.Not(data.Eq("name", "alpha"))) },
+			want:  []string{"beta", "delta", "gamma"},
+		},
+		{
+			name: "not composite",
+			query: func(q data.Query) data.Query {
+				return q.Where(data.Not(data.Or(data.Lt("count", 2), data.Gt("count", 3))))
+			},
+			want: []string{"beta", "gamma"},
+		},
+		{
+			name: "successive wheres",
+			query: func(q data.Query) data.Query {
+				return q.Where(data.Gt("count", 1)).Where(data.Lt("count", 3))
+			},
+			want: []string{"beta"},
+		},
+		{
+			name: "select and where",
+			query: func(q data.Query) data.Query {
+				return q.Select(data.AttrMap{"name": "gamma"}).Where(data.Gt("count", 1))
+			},
+			want: []string{"gamma"},
+		},
+	})
+}
+
+// TestDocumentPredicates tests the evaluation of data.Predicates
+// particular to document stores, which match the elements of
+// arrays, and only compare values of the same type.
+func TestDocumentPredicates(t *testing.T, newDB Constructor) {
+	testPredicates(t, newDB, []predicateCase{
+		{
+			name:  "array element",
+			query: func(q data.Query) data.Query { return q.Where(data.Eq("tags", "b")) },
+			want:  []string{"alpha", "beta"},
+		},
+		{
+			name:  "mismatched types",
+			query: func(q data.Query) data.Query { return q.Where(data.Gt("name", 1)) },
+			want:  []string{},
+		},
+	})
+}
@@ -0,0 +1,99 @@
+package dbtest
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/elos/data"
+)
+
+// TestProjection tests that queries fetch only the attributes their
+// projection includes, and zero those they don't
+func TestProjection(t *testing.T, newDB Constructor) {
+	db := open(t, newDB)
+
+	a := &Record{Name: "a", Count: 2, Tags: []string{"x"}, Note: "n"}
+	b := &Record{Name: "b", Count: 1, Tags: []string{"y"}, Note: "m"}
+	defer seed(t, db, a, b)()
+
+	projections := []struct {
+		what string
+		q    data.Query
+		want []Record
+	}{
+		{
+			"Include",
+			db.Query(RecordKind).Include("name"),
+			[]Record{{Id: b.Id, Name: "b"}, {Id: a.Id, Name: "a"}},
+		},
+		{
+			"Exclude",
+			db.Query(RecordKind).Exclude("tags", "note"),
+			[]Record{{Id: b.Id, Name: "b", Count: 1}, {Id: a.Id, Name: "a", Count: 2}},
+		},
+		{
+			"Include and Exclude",
+			db.Query(RecordKind).Include("name", "count").Exclude("count"),
+			[]Record{{Id: b.Id, Name: "b"}, {Id: a.Id, Name: "a"}},
+		},
+		{
+			"Exclude of id",
+			db.Query(RecordKind).Include("note").Exclude("id", "_id"),
+			[]Record{{Id: b.Id, Note: "m"}, {Id: a.Id, Note: "n"}},
+		},
+		{
+			"Include of absent field",
+			db.Query(RecordKind).Include("absent"),
+			[]Record{{Id: b.Id}, {Id: a.Id}},
+		},
+		{
+			// the projection doesn't affect the selection, nor the order
+			"Select",
+			db.Query(RecordKind).Select(data.AttrMap{"note": "n"}).Include("name"),
+			[]Record{{Id: a.Id, Name: "a"}},
+		},
+	}
+
+	for _, p := range projections {
+		iter, err := p.q.Order("count").Execute()
+		if err != nil {
+			t.Fatalf("%s: q.Execute error: %v", p.what, err)
+		}
+
+		got := make([]Record, 0)
+
+		// the record is reused, so that any attribute not fetched
+		// must be zeroed
+		r := &Record{Name: "stale", Count: 99, Tags: []string{"stale"}, Note: "stale"}
+		for iter.Next(r) {
+			got = append(got, *r)
+		}
+
+		if err := iter.Close(); err != nil {
+			t.Errorf("%s: iter.Close error: %v", p.what, err)
+		}
+
+		if want := p.want; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %+v, want %+v", p.what, got, want)
+		}
+	}
+
+	// the projection doesn't restrict the records saved
+	n, err := db.Query(RecordKind).Include("name").Count()
+	if err != nil {
+		t.Fatalf("q.Count error: %v", err)
+	}
+
+	if got, want := n, 2; got != want {
+		t.Errorf("q.Count: got %d, want %d", got, want)
+	}
+
+	full := &Record{Id: a.Id}
+	if err := db.PopulateByID(full); err != nil {
+		t.Fatalf("db.PopulateByID error: %v", err)
+	}
+
+	if got, want := *full, *a; !reflect.DeepEqual(got, want) {
+		t.Errorf("db.PopulateByID: got %+v, want %+v", got, want)
+	}
+}
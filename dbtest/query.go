@@ -0,0 +1,104 @@
+package dbtest
+
+import (
+	"testing"
+
+	"github.com/elos/data"
+)
+
+// ordered executes the query, and returns the names of the results in order
+func ordered(t *testing.T, q data.Query) []string {
+	iter, err := q.Execute()
+	if err != nil {
+		t.Fatalf("q.Execute error: %v", err)
+	}
+
+	ns := make([]string, 0)
+
+	r := new(Record)
+	for iter.Next(r) {
+		ns = append(ns, r.Name)
+		r = new(Record)
+	}
+
+	if err := iter.Close(); err != nil {
+		t.Fatalf("iter.Close error: %v", err)
+	}
+
+	return ns
+}
+
+// TestQuery tests Select, Order, Skip and Limit
+func TestQuery(t *testing.T, newDB Constructor) {
+	db := open(t, newDB)
+
+	defer seed(t, db,
+		&Record{Name: "c", Count: 1},
+		&Record{Name: "a", Count: 3},
+		&Record{Name: "d", Count: 2},
+		&Record{Name: "b", Count: 3},
+	)()
+
+	if got, want := names(t, db.Query(RecordKind)), []string{"a", "b", "c", "d"}; !equalStrings(got, want) {
+		t.Errorf("all: got %v, want %v", got, want)
+	}
+
+	selects := []struct {
+		name  string
+		attrs data.AttrMap
+		want  []string
+	}{
+		{"select string", data.AttrMap{"name": "d"}, []string{"d"}},
+		{"select number", data.AttrMap{"count": 3}, []string{"a", "b"}},
+		{"select multiple", data.AttrMap{"name": "b", "count": 3}, []string{"b"}},
+		{"select none", data.AttrMap{"name": "z"}, []string{}},
+	}
+
+	for _, s := range selects {
+		if got := names(t, db.Query(RecordKind).Select(s.attrs)); !equalStrings(got, s.want) {
+			t.Errorf("%s: got %v, want %v", s.name, got, s.want)
+		}
+	}
+
+	orders := []struct {
+		name  string
+		query data.Query
+		want  []string
+	}{
+		{"order", db.Query(RecordKind).Order("name"), []string{"a", "b", "c", "d"}},
+		{"order descending", db.Query(RecordKind).Order("-name"), []string{"d", "c", "b", "a"}},
+		{"order by multiple fields", db.Query(RecordKind).Order("count", "name"), []string{"c", "d", "a", "b"}},
+		{"order by mixed directions", db.Query(RecordKind).Order("-count", "name"), []string{"a", "b", "d", "c"}},
+		{"select and order", db.Query(RecordKind).Select(data.AttrMap{"count": 3}).Order("name"), []string{"a", "b"}},
+		{"order and limit", db.Query(RecordKind).Order("name").Limit(2), []string{"a", "b"}},
+		{"order, skip and limit", db.Query(RecordKind).Order("-name").Skip(1).Limit(2), []string{"c", "b"}},
+		{"order and limit by multiple fields", db.Query(RecordKind).Order("count", "name").Limit(3), []string{"c", "d", "a"}},
+	}
+
+	for _, o := range orders {
+		if got := ordered(t, o.query); !equalStrings(got, o.want) {
+			t.Errorf("%s: got %v, want %v", o.name, got, o.want)
+		}
+	}
+
+	counts := []struct {
+		name  string
+		query data.Query
+		want  int
+	}{
+		{"limit", db.Query(RecordKind).Limit(3), 3},
+		{"limit beyond", db.Query(RecordKind).Limit(10), 4},
+		{"skip", db.Query(RecordKind).Skip(1), 3},
+		{"skip beyond", db.Query(RecordKind).Skip(10), 0},
+		{"skip and limit", db.Query(RecordKind).Skip(1).Limit(2), 2},
+		{"skip and limit beyond", db.Query(RecordKind).Skip(3).Limit(2), 1},
+		{"select and limit", db.Query(RecordKind).Select(data.AttrMap{"count": 3}).Limit(1), 1},
+		{"batch", db.Query(RecordKind).Batch(1), 4},
+	}
+
+	for _, c := range counts {
+		if got := len(names(t, c.query)); got != c.want {
+			t.Errorf("%s: got %d records, want %d", c.name, got, c.want)
+		}
+	}
+}
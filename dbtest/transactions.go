@@ -0,0 +1,133 @@
+package dbtest
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/elos/data"
+)
+
+// TestTransactions tests that a data.Transactor's transactions are
+// atomic, isolated until they are committed, and only notify
+// subscribers once committed. It skips DBs which aren't Transactors.
+func TestTransactions(t *testing.T, newDB Constructor) {
+	db := open(t, newDB)
+
+	transactor, ok := db.(data.Transactor)
+	if !ok {
+		t.Skipf("%T does not implement data.Transactor", db)
+	}
+
+	changes, unsubscribe := subscribe(db)
+	defer unsubscribe()
+
+	// commit
+	tx, err := transactor.Begin()
+	if err != nil {
+		t.Fatalf("Begin error: %v", err)
+	}
+
+	one, two := &Record{Name: "one"}, &Record{Name: "two"}
+	seed(t, tx, one, two)
+	defer func() {
+		db.Delete(one)
+		db.Delete(two)
+	}()
+
+	if err := tx.PopulateByID(&Record{Id: one.Id}); err != nil {
+		t.Errorf("tx.PopulateByID error: %v", err)
+	}
+
+	if got, want := db.PopulateByID(&Record{Id: one.Id}), data.ErrNotFound; !errors.Is(got, want) {
+		t.Errorf("db.PopulateByID before commit: got %v, want %v", got, want)
+	}
+
+	select {
+	case c := <-*changes:
+		t.Errorf("received change before commit: %+v", c)
+	case <-time.After(Timeout / 10):
+	}
+
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("tx.Commit error: %v", err)
+	}
+
+	if got, want := names(t, db.Query(RecordKind)), []string{"one", "two"}; !equalStrings(got, want) {
+		t.Errorf("names after commit: got %v, want %v", got, want)
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case c := <-*changes:
+			if c.ChangeKind == data.Delete {
+				t.Errorf("change after commit: got %+v, want a save", c)
+			}
+		case <-time.After(Timeout):
+			t.Fatal("timed out waiting for the changes of the commit")
+		}
+	}
+
+	if got, want := tx.Save(&Record{Id: one.Id}), data.ErrTxDone; !errors.Is(got, want) {
+		t.Errorf("tx.Save after commit: got %v, want %v", got, want)
+	}
+
+	if got, want := tx.Commit(), data.ErrTxDone; !errors.Is(got, want) {
+		t.Errorf("tx.Commit after commit: got %v, want %v", got, want)
+	}
+
+	// rollback
+	tx, err = transactor.Begin()
+	if err != nil {
+		t.Fatalf("Begin error: %v", err)
+	}
+
+	three := &Record{Name: "three"}
+	three.SetID(tx.NewID())
+	if err := tx.Save(three); err != nil {
+		t.Fatalf("tx.Save error: %v", err)
+	}
+
+	if err := tx.Delete(one); err != nil {
+		t.Fatalf("tx.Delete error: %v", err)
+	}
+
+	if got, want := names(t, tx.Query(RecordKind)), []string{"three", "two"}; !equalStrings(got, want) {
+		t.Errorf("names in transaction: got %v, want %v", got, want)
+	}
+
+	if err := tx.Rollback(); err != nil {
+		t.Fatalf("tx.Rollback error: %v", err)
+	}
+
+	if got, want := names(t, db.Query(RecordKind)), []string{"one", "two"}; !equalStrings(got, want) {
+		t.Errorf("names after rollback: got %v, want %v", got, want)
+	}
+
+	select {
+	case c := <-*changes:
+		t.Errorf("received change after rollback: %+v", c)
+	case <-time.After(Timeout / 10):
+	}
+
+	if got, want := tx.Rollback(), data.ErrTxDone; !errors.Is(got, want) {
+		t.Errorf("tx.Rollback after rollback: got %v, want %v", got, want)
+	}
+
+	// InTx
+	four := &Record{Name: "four"}
+	four.SetID(db.NewID())
+	err = data.InTx(transactor, func(tx data.DB) error {
+		if err := tx.Save(four); err != nil {
+			return err
+		}
+		return tx.Delete(&Record{Id: db.NewID().String()})
+	})
+	if got, want := err, data.ErrNotFound; !errors.Is(got, want) {
+		t.Errorf("data.InTx: got %v, want %v", got, want)
+	}
+
+	if got, want := db.PopulateByID(&Record{Id: four.Id}), data.ErrNotFound; !errors.Is(got, want) {
+		t.Errorf("db.PopulateByID after failed InTx: got %v, want %v", got, want)
+	}
+}
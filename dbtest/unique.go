@@ -0,0 +1,160 @@
+package dbtest
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/elos/data"
+)
+
+// UniqueRecordKind is the kind of the UniqueRecords persisted by the suite
+const UniqueRecordKind data.Kind = "dbtest_unique_record"
+
+// UniqueRecord is the structure the suite persists to test unique
+// constraints, no two UniqueRecords may share a Key
+type UniqueRecord struct {
+	Id   string `json:"id" bson:"_id,omitempty"`
+	Key  string `json:"key,omitempty" bson:"key,omitempty"`
+	Name string `json:"name" bson:"name"`
+}
+
+func (r *UniqueRecord) Kind() data.Kind {
+	return UniqueRecordKind
+}
+
+func (r *UniqueRecord) ID() data.ID {
+	return data.ID(r.Id)
+}
+
+func (r *UniqueRecord) SetID(id data.ID) {
+	r.Id = id.String()
+}
+
+// UniqueModel describes the UniqueRecords, stored in the table, or
+// collection, storage, whose "key" attribute is unique
+func UniqueModel(storage string) *data.Model {
+	return &data.Model{
+		Kind:    UniqueRecordKind,
+		New:     func() data.Record { return new(UniqueRecord) },
+		Storage: storage,
+		Unique:  []string{"key"},
+	}
+}
+
+// TestUnique tests that the DB enforces the unique constraints of its
+// schema. It skips DBs whose schema doesn't register the UniqueRecordKind.
+func TestUnique(t *testing.T, newDB Constructor) {
+	db := open(t, newDB)
+
+	if s, ok := db.(interface{ Schema() *data.Schema }); !ok || s.Schema() == nil || !s.Schema().Registered(UniqueRecordKind) {
+		t.Skipf("%T does not register %s", db, UniqueRecordKind)
+	}
+
+	one, two := &UniqueRecord{Key: "one", Name: "one"}, &UniqueRecord{Key: "two", Name: "two"}
+	for _, r := range []*UniqueRecord{one, two} {
+		r.SetID(db.NewID())
+		if err := db.Save(r); err != nil {
+			t.Fatalf("db.Save error: %v", err)
+		}
+		defer db.Delete(r)
+	}
+
+	duplicate := func(what string, err error) {
+		if !errors.Is(err, data.ErrDuplicateKey) {
+			t.Errorf("%s: got %v, want %v", what, err, data.ErrDuplicateKey)
+			return
+		}
+
+		var e *data.Error
+		if !errors.As(err, &e) {
+			t.Errorf("%s: got %T, want *data.Error", what, err)
+			return
+		}
+
+		if got, want := e.Field, "key"; got != want {
+			t.Errorf("%s: e.Field: got %q, want %q", what, got, want)
+		}
+	}
+
+	// a new record may not take the key of another
+	three := &UniqueRecord{Key: "one", Name: "three"}
+	three.SetID(db.NewID())
+	duplicate("db.Save of new record", db.Save(three))
+
+	if got, want := db.PopulateByID(&UniqueRecord{Id: three.Id}), data.ErrNotFound; !errors.Is(got, want) {
+		t.Errorf("db.PopulateByID of rejected record: got %v, want %v", got, want)
+	}
+
+	// nor may an existing one
+	two.Key = "one"
+	duplicate("db.Save of updated record", db.Save(two))
+
+	populated := &UniqueRecord{Id: two.Id}
+	if err := db.PopulateByID(populated); err != nil {
+		t.Fatalf("db.PopulateByID error: %v", err)
+	}
+
+	if got, want := populated.Key, "two"; got != want {
+		t.Errorf("populated.Key: got %q, want %q", got, want)
+	}
+
+	// a record may be saved with its own key
+	one.Name = "first"
+	if err := db.Save(one); err != nil {
+		t.Errorf("db.Save of record with its own key error: %v", err)
+	}
+
+	// the key of a record is free once it changes, or the record is deleted
+	one.Key = "first"
+	if err := db.Save(one); err != nil {
+		t.Fatalf("db.Save error: %v", err)
+	}
+
+	if err := db.Save(three); err != nil {
+		t.Errorf("db.Save of freed key error: %v", err)
+	}
+	defer db.Delete(three)
+
+	if err := db.Delete(one); err != nil {
+		t.Fatalf("db.Delete error: %v", err)
+	}
+
+	two.Key = "first"
+	if err := db.Save(two); err != nil {
+		t.Errorf("db.Save of deleted record's key error: %v", err)
+	}
+
+	// records without keys are not constrained
+	four, five := &UniqueRecord{Name: "four"}, &UniqueRecord{Name: "five"}
+	for _, r := range []*UniqueRecord{four, five} {
+		r.SetID(db.NewID())
+		if err := db.Save(r); err != nil {
+			t.Errorf("db.Save of record without key error: %v", err)
+		}
+		defer db.Delete(r)
+	}
+
+	transactor, ok := db.(data.Transactor)
+	if !ok {
+		return
+	}
+
+	// a transaction may not commit a duplicate
+	tx, err := transactor.Begin()
+	if err != nil {
+		t.Fatalf("Begin error: %v", err)
+	}
+
+	six := &UniqueRecord{Key: "one", Name: "six"}
+	six.SetID(tx.NewID())
+	if err := tx.Save(six); err != nil {
+		tx.Rollback()
+		duplicate("tx.Save", err)
+	} else {
+		duplicate("tx.Commit", tx.Commit())
+	}
+
+	if got, want := db.PopulateByID(&UniqueRecord{Id: six.Id}), data.ErrNotFound; !errors.Is(got, want) {
+		t.Errorf("db.PopulateByID of rejected record: got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,135 @@
+package dbtest
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/elos/data"
+)
+
+// VersionedRecordKind is the kind of the VersionedRecords persisted by the suite
+const VersionedRecordKind data.Kind = "dbtest_versioned_record"
+
+// VersionedRecord is the data.Versioned structure the suite persists
+type VersionedRecord struct {
+	Id   string `json:"id" bson:"_id,omitempty"`
+	Name string `json:"name" bson:"name"`
+	Ver  int    `json:"version" bson:"version"`
+}
+
+func (r *VersionedRecord) Kind() data.Kind {
+	return VersionedRecordKind
+}
+
+func (r *VersionedRecord) ID() data.ID {
+	return data.ID(r.Id)
+}
+
+func (r *VersionedRecord) SetID(id data.ID) {
+	r.Id = id.String()
+}
+
+func (r *VersionedRecord) Version() int {
+	return r.Ver
+}
+
+func (r *VersionedRecord) SetVersion(v int) {
+	r.Ver = v
+}
+
+// VersionedModel describes the VersionedRecords, stored in the table, or collection, storage
+func VersionedModel(storage string) *data.Model {
+	return &data.Model{
+		Kind:    VersionedRecordKind,
+		New:     func() data.Record { return new(VersionedRecord) },
+		Storage: storage,
+	}
+}
+
+// TestVersions tests that the saves of Versioned records are conditional
+// on their versions. It skips DBs which don't register the VersionedRecordKind.
+func TestVersions(t *testing.T, newDB Constructor) {
+	db := open(t, newDB)
+
+	r := &VersionedRecord{Name: "first"}
+	r.SetID(db.NewID())
+	if err := db.Save(r); errors.Is(err, data.ErrUnregisteredKind) {
+		t.Skipf("%T does not register %s", db, VersionedRecordKind)
+	} else if err != nil {
+		t.Fatalf("db.Save error: %v", err)
+	}
+	defer db.Delete(r)
+
+	if got, want := r.Ver, 1; got != want {
+		t.Errorf("r.Version: got %d, want %d", got, want)
+	}
+
+	stale := *r
+
+	r.Name = "second"
+	if err := db.Save(r); err != nil {
+		t.Fatalf("db.Save error: %v", err)
+	}
+
+	if got, want := r.Ver, 2; got != want {
+		t.Errorf("r.Version: got %d, want %d", got, want)
+	}
+
+	stale.Name = "stale"
+	if err := db.Save(&stale); !errors.Is(err, data.ErrConflict) {
+		t.Errorf("db.Save of stale record: got %v, want data.ErrConflict", err)
+	}
+
+	if got, want := stale.Ver, 1; got != want {
+		t.Errorf("stale.Version: got %d, want %d", got, want)
+	}
+
+	fresh := &VersionedRecord{Id: r.Id, Name: "fresh"}
+	if err := db.Save(fresh); !errors.Is(err, data.ErrConflict) {
+		t.Errorf("db.Save of new record with existing id: got %v, want data.ErrConflict", err)
+	}
+
+	populated := &VersionedRecord{Id: r.Id}
+	if err := db.PopulateByID(populated); err != nil {
+		t.Fatalf("db.PopulateByID error: %v", err)
+	}
+
+	if populated.Name != "second" || populated.Ver != 2 {
+		t.Errorf("populated: got %q at %d, want %q at %d", populated.Name, populated.Ver, "second", 2)
+	}
+
+	if transactor, ok := db.(data.Transactor); ok {
+		// a transaction which doesn't commit leaves r at its version
+		tx, err := transactor.Begin()
+		if err != nil {
+			t.Fatalf("Begin error: %v", err)
+		}
+
+		r.Name = "rolled back"
+		if err := tx.Save(r); err != nil {
+			t.Fatalf("tx.Save error: %v", err)
+		}
+
+		if got, want := r.Ver, 3; got != want {
+			t.Errorf("r.Version in transaction: got %d, want %d", got, want)
+		}
+
+		if err := tx.Rollback(); err != nil {
+			t.Fatalf("tx.Rollback error: %v", err)
+		}
+
+		if got, want := r.Ver, 2; got != want {
+			t.Errorf("r.Version after rollback: got %d, want %d", got, want)
+		}
+
+		r.Name = "third"
+		if err := db.Save(r); err != nil {
+			t.Errorf("db.Save after rollback error: %v", err)
+		}
+	}
+
+	// a delete is not conditional
+	if err := db.Delete(&stale); err != nil {
+		t.Errorf("db.Delete error: %v", err)
+	}
+}
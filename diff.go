@@ -0,0 +1,70 @@
+package data
+
+import (
+	"reflect"
+	"sort"
+)
+
+type (
+	// A DiffOp is the operation a FieldDiff makes to a field
+	DiffOp string
+
+	// A FieldDiff describes the change to one field of a record
+	FieldDiff struct {
+		Op    DiffOp      `json:"op"`
+		Field string      `json:"field"`
+		Old   interface{} `json:"old,omitempty"`
+		New   interface{} `json:"new,omitempty"`
+	}
+
+	// A Diff describes the changes to the fields of a record, in
+	// the order of the fields. The diff of a record which didn't
+	// change is empty.
+	Diff []FieldDiff
+)
+
+const (
+	// Set is the DiffOp of a field which was given a new value,
+	// the Old value is nil if the field was previously unset
+	Set DiffOp = "set"
+
+	// Unset is the DiffOp of a field which was removed
+	Unset DiffOp = "unset"
+)
+
+// DiffAttrs computes the diff of the attributes of a record,
+// from those it had, old, to those it has, new. Values are
+// compared deeply, so they should be decoded alike, e.g., both
+// from json.
+//
+// Use DiffAttrs to compute the Diff of a Change:
+//
+//	c := data.NewUpdate(r)
+//	c.Diff = data.DiffAttrs(before, after)
+func DiffAttrs(old, new AttrMap) Diff {
+	d := make(Diff, 0)
+
+	for field, v := range new {
+		ov, ok := old[field]
+		if ok && reflect.DeepEqual(ov, v) {
+			continue
+		}
+
+		d = append(d, FieldDiff{Op: Set, Field: field, Old: ov, New: v})
+	}
+
+	for field, ov := range old {
+		if _, ok := new[field]; !ok {
+			d = append(d, FieldDiff{Op: Unset, Field: field, Old: ov})
+		}
+	}
+
+	sort.Sort(byField(d))
+	return d
+}
+
+type byField Diff
+
+func (d byField) Len() int           { return len(d) }
+func (d byField) Less(i, j int) bool { return d[i].Field < d[j].Field }
+func (d byField) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
@@ -0,0 +1,38 @@
+package data_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/elos/data"
+)
+
+func TestDiffAttrs(t *testing.T) {
+	old := data.AttrMap{
+		"name":  "old",
+		"count": 1.0,
+		"tags":  []interface{}{"a"},
+		"note":  "removed",
+	}
+
+	new := data.AttrMap{
+		"name":  "new",
+		"count": 1.0,
+		"tags":  []interface{}{"a"},
+		"added": true,
+	}
+
+	want := data.Diff{
+		{Op: data.Set, Field: "added", New: true},
+		{Op: data.Set, Field: "name", Old: "old", New: "new"},
+		{Op: data.Unset, Field: "note", Old: "removed"},
+	}
+
+	if got := data.DiffAttrs(old, new); !reflect.DeepEqual(got, want) {
+		t.Errorf("data.DiffAttrs: got %+v, want %+v", got, want)
+	}
+
+	if got, want := len(data.DiffAttrs(old, old)), 0; got != want {
+		t.Errorf("len(data.DiffAttrs(old, old)): got %d, want %d", got, want)
+	}
+}
@@ -1,10 +1,17 @@
 package data
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+const errorPrefix = "data Error: "
 
 // formatError prepends a package identifier to all error messages
 func formatError(s string) error {
-	return fmt.Errorf("data Error: %s", s)
+	return fmt.Errorf("%s%s", errorPrefix, s)
 }
 
 var (
@@ -41,4 +48,188 @@ var (
 	// maintains the access rules for you schema. In this case, said structure could
 	// use ErrAccessDenial to reject access to a client.
 	ErrAccessDenial = formatError("access denied")
+
+	// ErrUnregisteredKind indicates that a DB was handed a record, or
+	// asked to query, a kind which is not registered with its Schema.
+	//
+	// Use ErrUnregisteredKind, rather than panicking, when a DB can
+	// not determine where records of a kind are stored.
+	ErrUnregisteredKind = formatError("unregistered kind")
+
+	// ErrTxDone indicates that a Tx has already been committed or rolled back.
+	//
+	// Use ErrTxDone to reject operations on a Tx which has finished.
+	ErrTxDone = formatError("transaction has already been committed or rolled back")
+
+	// ErrNoJournal indicates that changes can not be replayed,
+	// because they are not journaled.
+	//
+	// Use ErrNoJournal to reject ChangesSince when there is no Journal.
+	ErrNoJournal = formatError("changes are not journaled")
+
+	// ErrJournalTruncated indicates that a Journal no longer retains
+	// the changes since the requested sequence number.
+	//
+	// Use ErrJournalTruncated, rather than replaying only the changes
+	// retained, so that subscribers know they have missed changes.
+	ErrJournalTruncated = formatError("journal no longer retains the changes")
+
+	// ErrConflict indicates that a write was rejected because the record
+	// was changed by another since it was read.
+	//
+	// Use ErrConflict to reject a conditional write whose condition failed.
+	ErrConflict = formatError("conflicting write")
+
+	// ErrDuplicateKey indicates that a write was rejected because another
+	// record already has the same value for a unique field.
+	//
+	// Use ErrDuplicateKey for the unique constraint violations of a database.
+	ErrDuplicateKey = formatError("duplicate key")
+
+	// ErrTimeout indicates that an operation did not complete in time.
+	//
+	// Use ErrTimeout for the timeouts of a database, and of deadlines.
+	ErrTimeout = formatError("operation timed out")
+
+	// ErrClosed indicates that a DB has been closed.
+	//
+	// Use ErrClosed to reject the writes made to a DB after it is closed.
+	ErrClosed = formatError("db is closed")
 )
+
+// sentinels are the errors which classify an Error
+var sentinels = []error{
+	ErrNotFound,
+	ErrNoConnection,
+	ErrInvalidID,
+	ErrAccessDenial,
+	ErrUnregisteredKind,
+	ErrTxDone,
+	ErrNoJournal,
+	ErrJournalTruncated,
+	ErrConflict,
+	ErrDuplicateKey,
+	ErrTimeout,
+	ErrClosed,
+}
+
+// Error is the error of an operation of a DB. It records the operation,
+// and the kind and id of the record it was given, if any.
+//
+// An Error is classified by one of the package's sentinel errors, such
+// as ErrNotFound, and may wrap the underlying cause, such as the error
+// of a database driver. Use errors.Is to inspect either:
+//
+//	if errors.Is(err, data.ErrNotFound) {
+//		// ...
+//	}
+type Error struct {
+	// Op is the operation, e.g., "save", "delete", "populate" or "query"
+	Op   string
+	Kind Kind
+	ID   ID
+
+	// Err is the sentinel which classifies the error, it is nil if
+	// the error is not one the package recognizes
+	Err error
+
+	// Cause is the underlying error, it is nil if the Err says it all
+	Cause error
+
+	// Field is the attribute of a unique constraint violated by
+	// an ErrDuplicateKey, it is empty if the DB can't determine it
+	Field string
+}
+
+func (e *Error) Error() string {
+	b := new(strings.Builder)
+	b.WriteString(errorPrefix)
+	b.WriteString(e.Op)
+
+	if e.Kind != "" {
+		fmt.Fprintf(b, " %s", e.Kind)
+	}
+
+	if e.ID != "" {
+		fmt.Fprintf(b, " %s", e.ID)
+	}
+
+	if e.Err != nil {
+		fmt.Fprintf(b, ": %s", strings.TrimPrefix(e.Err.Error(), errorPrefix))
+	}
+
+	if e.Field != "" {
+		fmt.Fprintf(b, " of field %s", e.Field)
+	}
+
+	if e.Cause != nil {
+		fmt.Fprintf(b, ": %s", e.Cause)
+	}
+
+	return b.String()
+}
+
+// Is reports whether the sentinel target classifies the error
+func (e *Error) Is(target error) bool {
+	return e.Err != nil && e.Err == target
+}
+
+// Unwrap retrieves the cause of the error
+func (e *Error) Unwrap() error {
+	return e.Cause
+}
+
+// WrapError wraps the error of the operation op, on a record of the
+// kind k with the id, in an Error. If err is already an Error, the op,
+// kind and id fill in those it lacks. Otherwise, err is classified if
+// it is a sentinel, or the error of a context. It returns nil if err
+// is nil, and a sentinel as is, if there is no op, kind or id to add,
+// so that it may still be compared with ==.
+//
+// A DB should classify the errors of its driver, before wrapping them:
+//
+//	if isDuplicate(err) {
+//		err = &data.Error{Err: data.ErrDuplicateKey, Cause: err}
+//	}
+//	return data.WrapError(err, "save", r.Kind(), r.ID())
+func WrapError(err error, op string, k Kind, id ID) error {
+	if err == nil {
+		return nil
+	}
+
+	if e, ok := err.(*Error); ok {
+		wrapped := *e
+		if wrapped.Op == "" {
+			wrapped.Op = op
+		}
+		if wrapped.Kind == "" {
+			wrapped.Kind = k
+		}
+		if wrapped.ID == "" {
+			wrapped.ID = id
+		}
+		return &wrapped
+	}
+
+	e := &Error{Op: op, Kind: k, ID: id}
+
+	for _, s := range sentinels {
+		if err != s {
+			continue
+		}
+
+		if op == "" && k == "" && id == "" {
+			return err
+		}
+
+		e.Err = err
+		return e
+	}
+
+	if err == context.DeadlineExceeded {
+		e.Err = ErrTimeout
+	}
+
+	e.Cause = err
+	return e
+}
@@ -0,0 +1,81 @@
+package data_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/elos/data"
+	"golang.org/x/net/context"
+)
+
+func TestWrapError(t *testing.T) {
+	if err := data.WrapError(nil, "save", thingKind, "1"); err != nil {
+		t.Errorf("data.WrapError(nil): got %v, want nil", err)
+	}
+
+	err := data.WrapError(data.ErrNotFound, "populate", thingKind, "1")
+
+	if !errors.Is(err, data.ErrNotFound) {
+		t.Errorf("errors.Is(err, data.ErrNotFound): got false, want true")
+	}
+
+	if errors.Is(err, data.ErrInvalidID) {
+		t.Errorf("errors.Is(err, data.ErrInvalidID): got true, want false")
+	}
+
+	if got, want := err.Error(), "data Error: populate thing 1: record not found"; got != want {
+		t.Errorf("err.Error(): got %q, want %q", got, want)
+	}
+
+	var e *data.Error
+	if !errors.As(err, &e) {
+		t.Fatalf("errors.As(err, *data.Error): got false, want true")
+	}
+
+	if got, want := e.Op, "populate"; got != want {
+		t.Errorf("e.Op: got %q, want %q", got, want)
+	}
+
+	// a driver error, classified by the DB, keeps its cause
+	cause := errors.New("E11000 duplicate key error")
+	err = data.WrapError(&data.Error{Err: data.ErrDuplicateKey, Cause: cause}, "save", thingKind, "1")
+
+	if !errors.Is(err, data.ErrDuplicateKey) {
+		t.Errorf("errors.Is(err, data.ErrDuplicateKey): got false, want true")
+	}
+
+	if !errors.Is(err, cause) {
+		t.Errorf("errors.Is(err, cause): got false, want true")
+	}
+
+	if got, want := err.Error(), "data Error: save thing 1: duplicate key: E11000 duplicate key error"; got != want {
+		t.Errorf("err.Error(): got %q, want %q", got, want)
+	}
+
+	// the violated constraint is named, and kept through rewrapping
+	err = data.WrapError(&data.Error{Err: data.ErrDuplicateKey, Field: "key"}, "save", thingKind, "1")
+
+	if got, want := err.Error(), "data Error: save thing 1: duplicate key of field key"; got != want {
+		t.Errorf("err.Error(): got %q, want %q", got, want)
+	}
+
+	if err := data.WrapError(err, "commit", "", ""); !errors.As(err, &e) || e.Field != "key" {
+		t.Errorf("e.Field: got %q, want %q", e.Field, "key")
+	}
+
+	// a sentinel with nothing to add is left bare
+	if got, want := data.WrapError(data.ErrNotFound, "", "", ""), data.ErrNotFound; got != want {
+		t.Errorf("data.WrapError(data.ErrNotFound): got %v, want %v", got, want)
+	}
+
+	// a deadline is a timeout
+	err = data.WrapError(context.DeadlineExceeded, "query", thingKind, "")
+
+	if !errors.Is(err, data.ErrTimeout) {
+		t.Errorf("errors.Is(err, data.ErrTimeout): got false, want true")
+	}
+
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("errors.Is(err, context.DeadlineExceeded): got false, want true")
+	}
+}
@@ -0,0 +1,200 @@
+//go:build go1.18
+// +build go1.18
+
+package data
+
+import (
+	"fmt"
+	"reflect"
+
+	"golang.org/x/net/context"
+)
+
+// The generic functions retrieve records of a concrete type, T, which
+// must be a pointer to a struct, as is conventional for a Record:
+//
+//	users, err := data.All[*User](db.Query(UserKind).Select(data.AttrMap{"admin": true}))
+//	user, err := data.ByID[*User](db, id)
+//
+// Each record is a fresh T, so the records may be retained.
+
+// All executes the query, and retrieves every record it matches. The
+// error of the query, or of its iteration, is returned, with none of
+// the records.
+func All[T Record](q Query) ([]T, error) {
+	results := make([]T, 0)
+
+	err := Each(q, func(r T) error {
+		results = append(results, r)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// First executes the query, and retrieves the first record it matches,
+// closing the iteration after it. It returns ErrNotFound if the query
+// matches none. The query is not changed, so it may be reused, but the
+// DB may fetch more than the one record, unless it is limited.
+func First[T Record](q Query) (T, error) {
+	var first T
+
+	iter, err := q.Execute()
+	if err != nil {
+		return first, err
+	}
+
+	r, err := newRecord[T]()
+	if err != nil {
+		iter.Close()
+		return first, err
+	}
+
+	if !iter.Next(r) {
+		if err := iter.Close(); err != nil {
+			return first, err
+		}
+
+		return first, WrapError(ErrNotFound, "query", "", "")
+	}
+
+	if err := iter.Close(); err != nil {
+		return first, err
+	}
+
+	return r, nil
+}
+
+// Each executes the query, and calls fn with each record it matches,
+// until fn returns an error. It returns the error of fn, if any, or
+// otherwise that of the query, or of its iteration.
+func Each[T Record](q Query, fn func(T) error) error {
+	iter, err := q.Execute()
+	if err != nil {
+		return err
+	}
+
+	return each(iter, fn)
+}
+
+// EachContext is Each, with the query bound to the context, see
+// ExecuteContext. Once the context is done, the iteration stops,
+// and EachContext returns an Error which wraps the context's error.
+func EachContext[T Record](ctx context.Context, q Query, fn func(T) error) error {
+	iter, err := ExecuteContext(ctx, q)
+	if err != nil {
+		return err
+	}
+
+	return each(iter, fn)
+}
+
+// Stream executes the query, bound to the context, and sends the
+// records it matches on the channel it returns, which is closed once
+// the iteration ends. Once the channel is closed, the function it
+// returns retrieves the error which ended the iteration, if any.
+//
+// To stop streaming early, cancel the context. The iteration is
+// then closed, and its error is an Error which wraps the context's:
+//
+//	ctx, cancel := context.WithCancel(ctx)
+//	defer cancel()
+//
+//	records, errc := data.Stream[*User](ctx, q)
+//	for u := range records {
+//		// ...
+//	}
+//	if err := errc(); err != nil {
+//		// ...
+//	}
+func Stream[T Record](ctx context.Context, q Query) (<-chan T, func() error) {
+	out := make(chan T)
+
+	var err error
+	go func() {
+		defer close(out)
+
+		err = EachContext(ctx, q, func(r T) error {
+			select {
+			case out <- r:
+				return nil
+			case <-ctx.Done():
+				return WrapError(ctx.Err(), "iterate", "", "")
+			}
+		})
+	}()
+
+	return out, func() error { return err }
+}
+
+// each calls fn with each record of the iterator, until fn returns an
+// error, and then closes the iterator
+func each[T Record](iter Iterator, fn func(T) error) error {
+	r, err := newRecord[T]()
+	if err != nil {
+		iter.Close()
+		return err
+	}
+
+	for iter.Next(r) {
+		if err := fn(r); err != nil {
+			iter.Close()
+			return err
+		}
+
+		if r, err = newRecord[T](); err != nil {
+			iter.Close()
+			return err
+		}
+	}
+
+	return iter.Close()
+}
+
+// ByID retrieves the record of T's kind with the id, see PopulateByID
+func ByID[T Record](p Populater, id ID) (T, error) {
+	r, err := newRecord[T]()
+	if err != nil {
+		return r, err
+	}
+
+	r.SetID(id)
+
+	if err := p.PopulateByID(r); err != nil {
+		var zero T
+		return zero, err
+	}
+
+	return r, nil
+}
+
+// ByField retrieves a record of T's kind whose field has the value v,
+// see PopulateByField
+func ByField[T Record](p Populater, field string, v interface{}) (T, error) {
+	r, err := newRecord[T]()
+	if err != nil {
+		return r, err
+	}
+
+	if err := p.PopulateByField(field, v, r); err != nil {
+		var zero T
+		return zero, err
+	}
+
+	return r, nil
+}
+
+// newRecord allocates a T, which must be a pointer
+func newRecord[T Record]() (T, error) {
+	var r T
+
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	if t.Kind() != reflect.Ptr {
+		return r, fmt.Errorf("data: can not construct record of type %s, must be a pointer", t)
+	}
+
+	return reflect.New(t.Elem()).Interface().(T), nil
+}
@@ -0,0 +1,237 @@
+//go:build go1.18
+// +build go1.18
+
+package data_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/elos/data"
+	"github.com/elos/data/builtin/mem"
+	"github.com/elos/data/dbtest"
+	"golang.org/x/net/context"
+)
+
+func genericDB(t *testing.T) data.DB {
+	db := mem.NewDB()
+
+	for _, name := range []string{"one", "two", "three"} {
+		r := &dbtest.Record{Name: name, Count: len(name)}
+		r.SetID(db.NewID())
+		if err := db.Save(r); err != nil {
+			t.Fatalf("db.Save error: %v", err)
+		}
+	}
+
+	return db
+}
+
+func TestAll(t *testing.T) {
+	db := genericDB(t)
+
+	records, err := data.All[*dbtest.Record](db.Query(dbtest.RecordKind).Select(data.AttrMap{"count": 3}).Order("name"))
+	if err != nil {
+		t.Fatalf("data.All error: %v", err)
+	}
+
+	if got, want := len(records), 2; got != want {
+		t.Fatalf("len(records): got %d, want %d", got, want)
+	}
+
+	if got, want := records[0].Name+","+records[1].Name, "one,two"; got != want {
+		t.Errorf("names: got %s, want %s", got, want)
+	}
+
+	if records[0] == records[1] {
+		t.Error("records: got the same record twice")
+	}
+
+	records, err = data.All[*dbtest.Record](db.Query(dbtest.RecordKind).Select(data.AttrMap{"count": 4}))
+	if err != nil {
+		t.Fatalf("data.All error: %v", err)
+	}
+
+	if records == nil || len(records) != 0 {
+		t.Errorf("records: got %v, want empty", records)
+	}
+}
+
+func TestFirst(t *testing.T) {
+	db := genericDB(t)
+
+	r, err := data.First[*dbtest.Record](db.Query(dbtest.RecordKind).Order("-name"))
+	if err != nil {
+		t.Fatalf("data.First error: %v", err)
+	}
+
+	if got, want := r.Name, "two"; got != want {
+		t.Errorf("r.Name: got %q, want %q", got, want)
+	}
+
+	if _, err := data.First[*dbtest.Record](db.Query(dbtest.RecordKind).Select(data.AttrMap{"name": "four"})); !errors.Is(err, data.ErrNotFound) {
+		t.Errorf("data.First: got %v, want %v", err, data.ErrNotFound)
+	}
+
+	// the query may be reused
+	q := db.Query(dbtest.RecordKind).Order("name")
+	if _, err := data.First[*dbtest.Record](q); err != nil {
+		t.Fatalf("data.First error: %v", err)
+	}
+
+	records, err := data.All[*dbtest.Record](q)
+	if err != nil {
+		t.Fatalf("data.All error: %v", err)
+	}
+
+	if got, want := len(records), 3; got != want {
+		t.Errorf("len(records) after data.First: got %d, want %d", got, want)
+	}
+}
+
+// failing is a query whose iterator fails
+type failing struct {
+	data.Query
+}
+
+type failingIter struct{}
+
+func (failing) Execute() (data.Iterator, error) { return failingIter{}, nil }
+
+func (failingIter) Next(data.Record) bool { return false }
+func (failingIter) Close() error          { return data.ErrNoConnection }
+
+func TestEach(t *testing.T) {
+	db := genericDB(t)
+
+	var names []string
+	err := data.Each(db.Query(dbtest.RecordKind).Order("name"), func(r *dbtest.Record) error {
+		names = append(names, r.Name)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("data.Each error: %v", err)
+	}
+
+	if got, want := len(names), 3; got != want {
+		t.Fatalf("len(names): got %d, want %d", got, want)
+	}
+
+	stop := errors.New("stop")
+	calls := 0
+	err = data.Each(db.Query(dbtest.RecordKind), func(r *dbtest.Record) error {
+		calls++
+		return stop
+	})
+
+	if got, want := err, stop; got != want {
+		t.Errorf("data.Each: got %v, want %v", got, want)
+	}
+
+	if got, want := calls, 1; got != want {
+		t.Errorf("calls: got %d, want %d", got, want)
+	}
+
+	// the error of the iterator is surfaced
+	if err := data.Each(failing{}, func(*dbtest.Record) error { return nil }); !errors.Is(err, data.ErrNoConnection) {
+		t.Errorf("data.Each: got %v, want %v", err, data.ErrNoConnection)
+	}
+
+	if _, err := data.All[*dbtest.Record](failing{}); !errors.Is(err, data.ErrNoConnection) {
+		t.Errorf("data.All: got %v, want %v", err, data.ErrNoConnection)
+	}
+}
+
+func TestByID(t *testing.T) {
+	db := genericDB(t)
+
+	r, err := data.ByID[*dbtest.Record](db, "2")
+	if err != nil {
+		t.Fatalf("data.ByID error: %v", err)
+	}
+
+	if got, want := r.Name, "two"; got != want {
+		t.Errorf("r.Name: got %q, want %q", got, want)
+	}
+
+	if _, err := data.ByID[*dbtest.Record](db, "4"); !errors.Is(err, data.ErrNotFound) {
+		t.Errorf("data.ByID: got %v, want %v", err, data.ErrNotFound)
+	}
+
+	r, err = data.ByField[*dbtest.Record](db, "name", "three")
+	if err != nil {
+		t.Fatalf("data.ByField error: %v", err)
+	}
+
+	if got, want := r.ID(), data.ID("3"); got != want {
+		t.Errorf("r.ID: got %q, want %q", got, want)
+	}
+
+	// a record must be a pointer
+	if _, err := data.ByID[data.Record](db, "2"); err == nil {
+		t.Error("data.ByID of an interface: got nil error")
+	}
+}
+
+func TestStream(t *testing.T) {
+	db := genericDB(t)
+
+	records, errc := data.Stream[*dbtest.Record](context.Background(), db.Query(dbtest.RecordKind).Order("name"))
+
+	var names []string
+	for r := range records {
+		names = append(names, r.Name)
+	}
+
+	if err := errc(); err != nil {
+		t.Fatalf("data.Stream error: %v", err)
+	}
+
+	if got, want := len(names), 3; got != want {
+		t.Errorf("len(names): got %d, want %d", got, want)
+	}
+
+	// the stream stops once the context is cancelled
+	ctx, cancel := context.WithCancel(context.Background())
+	records, errc = data.Stream[*dbtest.Record](ctx, db.Query(dbtest.RecordKind))
+
+	<-records
+	cancel()
+
+	select {
+	case _, ok := <-records:
+		// a record may have been sent before the cancellation
+		if ok {
+			for range records {
+			}
+		}
+	case <-time.After(time.Second):
+		t.Fatal("records not closed once cancelled")
+	}
+
+	if err := errc(); !errors.Is(err, context.Canceled) {
+		t.Errorf("data.Stream: got %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestEachContext(t *testing.T) {
+	db := genericDB(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	calls := 0
+	err := data.EachContext(ctx, db.Query(dbtest.RecordKind), func(*dbtest.Record) error {
+		calls++
+		cancel()
+		return nil
+	})
+
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("data.EachContext: got %v, want %v", err, context.Canceled)
+	}
+
+	if got, want := calls, 1; got != want {
+		t.Errorf("calls: got %d, want %d", got, want)
+	}
+}
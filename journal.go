@@ -0,0 +1,27 @@
+package data
+
+type (
+	// A Journal is a durable, ordered, log of Changes.
+	//
+	// A ChangeHub with a Journal appends each change to it before
+	// notifying subscribers, so that a subscriber which was
+	// disconnected can replay the changes it missed.
+	Journal interface {
+		// Append assigns the change the next sequence number, one
+		// greater than that of the last change, and records it
+		Append(*Change) error
+
+		// Since retrieves, in order, the changes recorded after the
+		// change with the sequence number seq. Since(0) retrieves all
+		// the changes. If the changes are no longer retained, Since
+		// returns ErrJournalTruncated.
+		Since(seq uint64) ([]*Change, error)
+	}
+
+	// A Resumer can resume a subscription to its changes, from the
+	// sequence number of the last change the subscriber received.
+	// It is an optional interface, which a DB may implement.
+	Resumer interface {
+		ChangesSince(seq uint64) (*chan *Change, error)
+	}
+)
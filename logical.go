@@ -239,13 +239,70 @@ type (
 		Limit(int) Query
 		Batch(int) Query
 		Select(AttrMap) Query
+		// Where restricts the query to records satisfying the predicate.
+		// Successive calls, and any Select, are conjoined.
+		Where(*Predicate) Query
 		Order(field ...string) Query
+
+		// Include restricts the attributes the query fetches to the
+		// fields, and Exclude fetches every attribute but the fields.
+		// Each replaces the fields of a previous call, and together
+		// they fetch the fields included but not excluded. The ID is
+		// always fetched. Fields the records lack are disregarded.
+		//
+		// The projection neither affects which records the query
+		// matches, nor their order. The attributes of a record which
+		// aren't fetched are zero once it is populated, whatever
+		// they were before, so saving the record zeroes them.
+		Include(field ...string) Query
+		Exclude(field ...string) Query
+
+		// Count counts the records the query matches. Count, and the
+		// aggregates which follow, consider every record the query
+		// matches, regardless of its Order, Skip and Limit.
+		Count() (int, error)
+
+		// Exists reports whether the query matches any record
+		Exists() (bool, error)
+
+		// Sum sums the numeric values of the field, it is 0 if there are none
+		Sum(field string) (float64, error)
+
+		// Min, Max and Avg aggregate the numeric values of the
+		// field, they return ErrNotFound if there are none
+		Min(field string) (float64, error)
+		Max(field string) (float64, error)
+		Avg(field string) (float64, error)
+
+		// GroupBy counts the records by the value of the field
+		GroupBy(field string) ([]Group, error)
 	}
 
 	Iterator interface {
 		Next(Record) bool
 		Close() error
 	}
+
+	// An ErrIterator is an Iterator which reports the error which ended
+	// its iteration, before it is closed. The Iterators of the builtin
+	// DBs are ErrIterators, and share their semantics:
+	//
+	//	* Next returns false once the records are exhausted, once an
+	//	  error occurs, and once the Iterator is closed.
+	//	* An error decoding a record, or retrieving a record it
+	//	  references, ends the iteration. Err returns it, as an Error
+	//	  with the kind and id of the record, if they are known, and
+	//	  so does Close.
+	//	* Close may be called before the records are exhausted, to
+	//	  release the resources of the iteration, which is not an error.
+	//	  Close may be called more than once.
+	ErrIterator interface {
+		Iterator
+
+		// Err returns the error which ended the iteration. It is nil
+		// while Next returns true, and if the iteration completed.
+		Err() error
+	}
 )
 
 func Equivalent(r1, r2 Record) bool {
@@ -0,0 +1,124 @@
+package data
+
+type (
+	// An Operator identifies the comparison, or logical composition,
+	// which a Predicate performs. The values mirror the mongo query
+	// operators, as a matter of familiarity, however a Predicate is
+	// backend-neutral, and each DB translates it to its own query language.
+	Operator string
+
+	// A Predicate is a node in a tree of conditions over the attributes
+	// of a Record.
+	//
+	// Comparison predicates (OpEq, OpNe, OpGt, OpGte, OpLt, OpLte, OpIn,
+	// OpNin and OpExists) are leaves, and use the Field and Value. Logical
+	// predicates (OpAnd, OpOr and OpNot) compose their Operands.
+	//
+	// Field names are the names of the attributes as persisted by the
+	// DB, i.e., the json or bson names, not the names of the go struct fields.
+	//
+	// Use the constructors (Eq, Gt, And, ...) to build predicates:
+	//		data.Or(
+	//			data.Gte("count", 5),
+	//			data.And(data.Exists("note", true), data.Ne("name", "nick")),
+	//		)
+	Predicate struct {
+		Op       Operator     `json:"op"`
+		Field    string       `json:"field,omitempty"`
+		Value    interface{}  `json:"value,omitempty"`
+		Operands []*Predicate `json:"operands,omitempty"`
+	}
+)
+
+const (
+	// Comparison operators
+	OpEq     Operator = "$eq"
+	OpNe     Operator = "$ne"
+	OpGt     Operator = "$gt"
+	OpGte    Operator = "$gte"
+	OpLt     Operator = "$lt"
+	OpLte    Operator = "$lte"
+	OpIn     Operator = "$in"
+	OpNin    Operator = "$nin"
+	OpExists Operator = "$exists"
+
+	// Logical operators
+	OpAnd Operator = "$and"
+	OpOr  Operator = "$or"
+	OpNot Operator = "$not"
+)
+
+// Logical reports whether the operator composes other predicates,
+// as opposed to comparing an attribute.
+func (op Operator) Logical() bool {
+	return op == OpAnd || op == OpOr || op == OpNot
+}
+
+func compare(op Operator, field string, v interface{}) *Predicate {
+	return &Predicate{Op: op, Field: field, Value: v}
+}
+
+func compose(op Operator, ps []*Predicate) *Predicate {
+	return &Predicate{Op: op, Operands: ps}
+}
+
+// Eq matches records whose field is equal to v
+func Eq(field string, v interface{}) *Predicate {
+	return compare(OpEq, field, v)
+}
+
+// Ne matches records whose field is not equal to v
+func Ne(field string, v interface{}) *Predicate {
+	return compare(OpNe, field, v)
+}
+
+// Gt matches records whose field is greater than v
+func Gt(field string, v interface{}) *Predicate {
+	return compare(OpGt, field, v)
+}
+
+// Gte matches records whose field is greater than or equal to v
+func Gte(field string, v interface{}) *Predicate {
+	return compare(OpGte, field, v)
+}
+
+// Lt matches records whose field is less than v
+func Lt(field string, v interface{}) *Predicate {
+	return compare(OpLt, field, v)
+}
+
+// Lte matches records whose field is less than or equal to v
+func Lte(field string, v interface{}) *Predicate {
+	return compare(OpLte, field, v)
+}
+
+// In matches records whose field is equal to any of the vs
+func In(field string, vs ...interface{}) *Predicate {
+	return compare(OpIn, field, vs)
+}
+
+// Nin matches records whose field is equal to none of the vs
+func Nin(field string, vs ...interface{}) *Predicate {
+	return compare(OpNin, field, vs)
+}
+
+// Exists matches records which have (or, if exists is false,
+// do not have) the field
+func Exists(field string, exists bool) *Predicate {
+	return compare(OpExists, field, exists)
+}
+
+// And matches records which satisfy all of the predicates
+func And(ps ...*Predicate) *Predicate {
+	return compose(OpAnd, ps)
+}
+
+// Or matches records which satisfy any of the predicates
+func Or(ps ...*Predicate) *Predicate {
+	return compose(OpOr, ps)
+}
+
+// Not matches records which do not satisfy the predicate
+func Not(p *Predicate) *Predicate {
+	return compose(OpNot, []*Predicate{p})
+}
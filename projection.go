@@ -0,0 +1,35 @@
+package data
+
+// A Projection is the attributes a query fetches, as declared by
+// Include and Exclude. The zero Projection fetches every attribute.
+type Projection struct {
+	Include, Exclude []string
+}
+
+// Restricts reports whether the projection fetches fewer than
+// every attribute
+func (p Projection) Restricts() bool {
+	return len(p.Include) > 0 || len(p.Exclude) > 0
+}
+
+// Fetches reports whether the projection fetches the field. It
+// doesn't regard the ID, which a query always fetches.
+func (p Projection) Fetches(field string) bool {
+	for _, f := range p.Exclude {
+		if f == field {
+			return false
+		}
+	}
+
+	if len(p.Include) == 0 {
+		return true
+	}
+
+	for _, f := range p.Include {
+		if f == field {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,44 @@
+package data
+
+import "testing"
+
+func TestProjection(t *testing.T) {
+	cases := []struct {
+		p         Projection
+		restricts bool
+		fetches   map[string]bool
+	}{
+		{
+			p:         Projection{},
+			restricts: false,
+			fetches:   map[string]bool{"name": true, "count": true},
+		},
+		{
+			p:         Projection{Include: []string{"name"}},
+			restricts: true,
+			fetches:   map[string]bool{"name": true, "count": false},
+		},
+		{
+			p:         Projection{Exclude: []string{"name"}},
+			restricts: true,
+			fetches:   map[string]bool{"name": false, "count": true},
+		},
+		{
+			p:         Projection{Include: []string{"name", "count"}, Exclude: []string{"count"}},
+			restricts: true,
+			fetches:   map[string]bool{"name": true, "count": false, "note": false},
+		},
+	}
+
+	for _, c := range cases {
+		if got, want := c.p.Restricts(), c.restricts; got != want {
+			t.Errorf("%+v.Restricts(): got %t, want %t", c.p, got, want)
+		}
+
+		for field, want := range c.fetches {
+			if got := c.p.Fetches(field); got != want {
+				t.Errorf("%+v.Fetches(%q): got %t, want %t", c.p, field, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package data
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+type (
+	// A Model describes a Kind of Record, and how a DB persists it.
+	Model struct {
+		// Kind is the kind of the records the model describes
+		Kind Kind
+
+		// New constructs an empty record of the kind. It is
+		// optional, though without it the Schema can not produce
+		// records of the kind, only describe them.
+		New func() Record
+
+		// Storage is the name of the table, or collection, in which
+		// records of the kind are stored. It defaults to the kind.
+		Storage string
+
+		// Fields lists the attributes of the kind which are persisted.
+		// It is optional, a DB which needs the fields derives them
+		// when they are not given.
+		Fields []string
+
+		// IDField is the attribute which stores the ID. It defaults to "id".
+		IDField string
+
+		// VersionField is the attribute which stores the version of
+		// Versioned records. It defaults to "version".
+		VersionField string
+
+		// Unique lists the attributes of the kind which no two records
+		// may share. The builtin DBs reject the Save of a record which
+		// would duplicate another's with an Error of ErrDuplicateKey,
+		// whose Field is the attribute. A record which lacks the
+		// attribute is not constrained.
+		//
+		// A DB which doesn't manage its tables, such as osql, relies on
+		// them to declare the constraints.
+		Unique []string
+	}
+
+	// A Schema is a registry of the Models of the Kinds a program
+	// recognizes. The builtin DBs consult their Schema to determine
+	// where records are stored, and reject the kinds which are not
+	// registered with ErrUnregisteredKind.
+	//
+	// A Schema is safe for concurrent use.
+	Schema struct {
+		m      sync.RWMutex
+		models map[Kind]*Model
+	}
+)
+
+const (
+	// DefaultIDField is the IDField of a Model which does not declare one
+	DefaultIDField = "id"
+
+	// DefaultVersionField is the VersionField of a Model which does not declare one
+	DefaultVersionField = "version"
+)
+
+func NewSchema() *Schema {
+	return &Schema{
+		models: make(map[Kind]*Model),
+	}
+}
+
+// Register adds the model to the schema, replacing any model
+// previously registered for the same kind. Register fills in
+// the model's defaults.
+func (s *Schema) Register(m *Model) error {
+	if m.Kind == "" {
+		return fmt.Errorf("data: can not register a model without a kind")
+	}
+
+	registered := *m
+
+	if registered.Storage == "" {
+		registered.Storage = string(m.Kind)
+	}
+
+	if registered.IDField == "" {
+		registered.IDField = DefaultIDField
+	}
+
+	if registered.VersionField == "" {
+		registered.VersionField = DefaultVersionField
+	}
+
+	if m.Fields != nil {
+		registered.Fields = append([]string(nil), m.Fields...)
+	}
+
+	if m.Unique != nil {
+		registered.Unique = append([]string(nil), m.Unique...)
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.models[m.Kind] = &registered
+	return nil
+}
+
+// Model retrieves the model registered for the kind k, it returns
+// ErrUnregisteredKind if there is none. The model must not be modified.
+func (s *Schema) Model(k Kind) (*Model, error) {
+	s.m.RLock()
+	defer s.m.RUnlock()
+
+	m, ok := s.models[k]
+	if !ok {
+		return nil, ErrUnregisteredKind
+	}
+
+	return m, nil
+}
+
+// Registered reports whether a model is registered for the kind k
+func (s *Schema) Registered(k Kind) bool {
+	_, err := s.Model(k)
+	return err == nil
+}
+
+// Kinds lists the registered kinds, in order
+func (s *Schema) Kinds() []Kind {
+	s.m.RLock()
+	defer s.m.RUnlock()
+
+	kinds := make([]Kind, 0, len(s.models))
+	for k := range s.models {
+		kinds = append(kinds, k)
+	}
+
+	sort.Sort(byKind(kinds))
+	return kinds
+}
+
+// Storage retrieves the name of the table, or collection, of the kind k
+func (s *Schema) Storage(k Kind) (string, error) {
+	m, err := s.Model(k)
+	if err != nil {
+		return "", err
+	}
+
+	return m.Storage, nil
+}
+
+// New constructs an empty record of the kind k
+func (s *Schema) New(k Kind) (Record, error) {
+	m, err := s.Model(k)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.New == nil {
+		return nil, fmt.Errorf("data: no constructor registered for kind: %s", k)
+	}
+
+	return m.New(), nil
+}
+
+// Load constructs a record of the kind k, and populates it by the id.
+//
+// Use Load to retrieve records whose kind is only known at runtime:
+//
+//	r, err := schema.Load(db, data.Kind(kindName), id)
+func (s *Schema) Load(p Populater, k Kind, id ID) (Record, error) {
+	r, err := s.New(k)
+	if err != nil {
+		return nil, err
+	}
+
+	r.SetID(id)
+
+	if err := p.PopulateByID(r); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+type byKind []Kind
+
+func (ks byKind) Len() int           { return len(ks) }
+func (ks byKind) Less(i, j int) bool { return ks[i] < ks[j] }
+func (ks byKind) Swap(i, j int)      { ks[i], ks[j] = ks[j], ks[i] }
@@ -0,0 +1,121 @@
+package data_test
+
+import (
+	"testing"
+
+	"github.com/elos/data"
+)
+
+const thingKind data.Kind = "thing"
+
+type thing struct {
+	Id string `json:"id"`
+}
+
+func (t *thing) ID() data.ID      { return data.ID(t.Id) }
+func (t *thing) SetID(id data.ID) { t.Id = id.String() }
+func (t *thing) Kind() data.Kind  { return thingKind }
+
+// populater populates things whose id is "1"
+type populater struct{}
+
+func (populater) PopulateByID(r data.Record) error {
+	if r.ID() != "1" {
+		return data.ErrNotFound
+	}
+	return nil
+}
+
+func (populater) PopulateByField(string, interface{}, data.Record) error {
+	return data.ErrNotFound
+}
+
+func TestSchemaRegister(t *testing.T) {
+	s := data.NewSchema()
+
+	if err := s.Register(&data.Model{}); err == nil {
+		t.Error("s.Register without a kind: got nil error")
+	}
+
+	if err := s.Register(&data.Model{Kind: thingKind}); err != nil {
+		t.Fatalf("s.Register error: %v", err)
+	}
+
+	m, err := s.Model(thingKind)
+	if err != nil {
+		t.Fatalf("s.Model error: %v", err)
+	}
+
+	if got, want := m.Storage, "thing"; got != want {
+		t.Errorf("m.Storage: got %q, want %q", got, want)
+	}
+
+	if got, want := m.IDField, data.DefaultIDField; got != want {
+		t.Errorf("m.IDField: got %q, want %q", got, want)
+	}
+
+	if _, err := s.New(thingKind); err == nil {
+		t.Error("s.New without a constructor: got nil error")
+	}
+
+	if err := s.Register(&data.Model{
+		Kind:    thingKind,
+		New:     func() data.Record { return new(thing) },
+		Storage: "things",
+	}); err != nil {
+		t.Fatalf("s.Register error: %v", err)
+	}
+
+	if got, err := s.Storage(thingKind); err != nil || got != "things" {
+		t.Errorf("s.Storage: got %q, %v, want %q", got, err, "things")
+	}
+
+	if got, want := s.Kinds(), []data.Kind{thingKind}; len(got) != 1 || got[0] != want[0] {
+		t.Errorf("s.Kinds: got %v, want %v", got, want)
+	}
+}
+
+func TestSchemaUnregistered(t *testing.T) {
+	s := data.NewSchema()
+
+	if s.Registered(thingKind) {
+		t.Error("s.Registered: got true, want false")
+	}
+
+	if _, err := s.Model(thingKind); err != data.ErrUnregisteredKind {
+		t.Errorf("s.Model: got %v, want %v", err, data.ErrUnregisteredKind)
+	}
+
+	if _, err := s.Storage(thingKind); err != data.ErrUnregisteredKind {
+		t.Errorf("s.Storage: got %v, want %v", err, data.ErrUnregisteredKind)
+	}
+
+	if _, err := s.Load(populater{}, thingKind, "1"); err != data.ErrUnregisteredKind {
+		t.Errorf("s.Load: got %v, want %v", err, data.ErrUnregisteredKind)
+	}
+}
+
+func TestSchemaLoad(t *testing.T) {
+	s := data.NewSchema()
+	s.Register(&data.Model{
+		Kind: thingKind,
+		New:  func() data.Record { return new(thing) },
+	})
+
+	r, err := s.Load(populater{}, thingKind, "1")
+	if err != nil {
+		t.Fatalf("s.Load error: %v", err)
+	}
+
+	if _, ok := r.(*thing); !ok {
+		t.Errorf("s.Load: got %T, want *thing", r)
+	}
+
+	if got, want := r.ID(), data.ID("1"); got != want {
+		t.Errorf("r.ID(): got %q, want %q", got, want)
+	}
+
+	if _, err := s.Load(populater{}, thingKind, "2"); err != data.ErrNotFound {
+		t.Errorf("s.Load of missing record: got %v, want %v", err, data.ErrNotFound)
+	}
+}
@@ -0,0 +1,53 @@
+package data
+
+type (
+	// A Tx is a transactional view of a DB. The Saves and Deletes made
+	// through a Tx are applied to the DB atomically, all or none, when
+	// the Tx is committed, and are only visible through the Tx until then.
+	//
+	// A Tx does not notify subscribers of its changes until it is
+	// committed, and then only if it is committed successfully.
+	//
+	// After Commit or Rollback, Save and Delete return ErrTxDone, as
+	// do further calls to Commit or Rollback.
+	Tx interface {
+		DB
+
+		Commit() error
+		Rollback() error
+	}
+
+	// A Transactor can begin transactions. It is an optional
+	// interface, which a DB may implement.
+	//
+	// Use a Transactor when several records must be saved, or deleted,
+	// together.
+	Transactor interface {
+		Begin() (Tx, error)
+	}
+)
+
+// InTx runs fn in a transaction of the Transactor, committing the
+// transaction if fn succeeds, and rolling it back if fn returns an error.
+//
+// Use InTx to write several records atomically:
+//
+//	err := data.InTx(db, func(tx data.DB) error {
+//		if err := tx.Save(user); err != nil {
+//			return err
+//		}
+//		return tx.Save(calendar)
+//	})
+func InTx(t Transactor, fn func(DB) error) error {
+	tx, err := t.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,28 @@
+package transfer
+
+import "github.com/elos/data"
+
+// Attrs retrieves the json attributes of the record
+func Attrs(r data.Record) (data.AttrMap, error) {
+	attrs := make(data.AttrMap)
+	if err := TransferAttrs(r, &attrs); err != nil {
+		return nil, err
+	}
+	return attrs, nil
+}
+
+// Diff computes the diff of the json attributes of a
+// record, from those of old to those of new
+func Diff(old, new data.Record) (data.Diff, error) {
+	oldAttrs, err := Attrs(old)
+	if err != nil {
+		return nil, err
+	}
+
+	newAttrs, err := Attrs(new)
+	if err != nil {
+		return nil, err
+	}
+
+	return data.DiffAttrs(oldAttrs, newAttrs), nil
+}
@@ -0,0 +1,45 @@
+package transfer
+
+import (
+	"strings"
+
+	"github.com/elos/data"
+)
+
+// PatchOp is an operation of a JSON Patch (RFC 6902)
+type PatchOp struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// Patch translates the diff into the JSON Patch which applies it to
+// the json attributes of the record. A field set for the first time
+// is added, one set anew is replaced, and one unset is removed.
+func Patch(d data.Diff) []PatchOp {
+	ops := make([]PatchOp, len(d))
+
+	for i, fd := range d {
+		op := PatchOp{Path: pointer(fd.Field)}
+
+		switch {
+		case fd.Op == data.Unset:
+			op.Op = "remove"
+		case fd.Old == nil:
+			op.Op, op.Value = "add", fd.New
+		default:
+			op.Op, op.Value = "replace", fd.New
+		}
+
+		ops[i] = op
+	}
+
+	return ops
+}
+
+var pointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+// pointer is the JSON Pointer (RFC 6901) of the field
+func pointer(field string) string {
+	return "/" + pointerEscaper.Replace(field)
+}
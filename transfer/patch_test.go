@@ -0,0 +1,27 @@
+package transfer_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/elos/data"
+	"github.com/elos/data/transfer"
+)
+
+func TestPatch(t *testing.T) {
+	d := data.Diff{
+		{Op: data.Set, Field: "added", New: true},
+		{Op: data.Set, Field: "a/b", Old: "old", New: "new"},
+		{Op: data.Unset, Field: "note", Old: "removed"},
+	}
+
+	want := []transfer.PatchOp{
+		{Op: "add", Path: "/added", Value: true},
+		{Op: "replace", Path: "/a~1b", Value: "new"},
+		{Op: "remove", Path: "/note"},
+	}
+
+	if got := transfer.Patch(d); !reflect.DeepEqual(got, want) {
+		t.Errorf("transfer.Patch: got %+v, want %+v", got, want)
+	}
+}
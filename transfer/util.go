@@ -45,17 +45,27 @@ type ChangeTransport struct {
 	ChangeKind data.ChangeKind        `json:"change_kind"`
 	RecordKind data.Kind              `json:"record_kind"`
 	Record     map[string]interface{} `json:"record"`
+
+	// Patch is the JSON Patch of the record's change, if
+	// the change carries a Diff
+	Patch []PatchOp `json:"patch,omitempty"`
 }
 
 func Change(c *data.Change) *ChangeTransport {
 	m := make(map[string]interface{})
 	TransferAttrs(c.Record, &m)
 
-	return &ChangeTransport{
+	ct := &ChangeTransport{
 		ChangeKind: c.ChangeKind,
 		RecordKind: c.Record.Kind(),
 		Record:     m,
 	}
+
+	if c.Diff != nil {
+		ct.Patch = Patch(c.Diff)
+	}
+
+	return ct
 }
 
 func ChangeFrom(ct *ChangeTransport, r data.Record) *data.Change {
@@ -0,0 +1,34 @@
+package data
+
+// A Versioned record carries the version at which it was read, so
+// that a DB may reject the writes of those who read it before it
+// was last written.
+//
+// The Save of a Versioned record is conditional: it succeeds only
+// if the stored record is at the version of the record, or if there
+// is no stored record and the version is 0, in which case the DB
+// increments the version of the record, and stores it. Otherwise the
+// Save fails with ErrConflict, and neither is changed. Delete is not
+// conditional.
+//
+// Use a Versioned record when several may edit it at once:
+//
+//	if err := db.Save(task); errors.Is(err, data.ErrConflict) {
+//		// reload the task, reapply the edit, and save it again
+//	}
+type Versioned interface {
+	Record
+
+	Version() int
+	SetVersion(int)
+}
+
+// VersionOf retrieves the version of the record, and whether it is Versioned
+func VersionOf(r Record) (int, bool) {
+	v, ok := r.(Versioned)
+	if !ok {
+		return 0, false
+	}
+
+	return v.Version(), true
+}